@@ -1,18 +1,27 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/yngus4862/chat/internal/tlsutil"
 )
 
 func main() {
 	addr := flag.String("addr", "http://127.0.0.1:9099", "admin base url")
 	token := flag.String("token", "", "admin token (Bearer)")
+	caCert := flag.String("cacert", "", "CA bundle to verify the admin server (https)")
+	cert := flag.String("cert", "", "client certificate for mTLS")
+	key := flag.String("key", "", "client private key for mTLS")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -27,6 +36,13 @@ func main() {
 	}
 
 	client := &http.Client{Timeout: 6 * time.Second}
+	if *caCert != "" || *cert != "" || *key != "" {
+		tlsCfg, err := clientTLS(*caCert, *cert, *key)
+		if err != nil {
+			fatal(err)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsCfg}
+	}
 
 	switch cmd {
 	case "status":
@@ -35,6 +51,62 @@ func main() {
 		doPOST(client, *addr+"/admin/stop", *token)
 	case "restart":
 		doPOST(client, *addr+"/admin/restart", *token)
+	case "latency":
+		doGET(client, *addr+"/admin/latency", *token)
+	case "log-level":
+		if lvl := flag.Arg(1); lvl != "" {
+			doPUT(client, *addr+"/admin/log-level", *token, `{"level":`+strconv.Quote(lvl)+`}`)
+		} else {
+			doGET(client, *addr+"/admin/log-level", *token)
+		}
+	case "retention":
+		retentionCmd(client, *addr, *token, flag.Args()[1:])
+	case "export":
+		exportCmd(client, *addr, *token, flag.Args()[1:])
+	case "import":
+		importCmd(client, *addr, *token, flag.Args()[1:])
+	case "audit":
+		auditCmd(client, *addr, *token, flag.Args()[1:])
+	case "reviews":
+		reviewsCmd(client, *addr, *token, flag.Args()[1:])
+	case "reports":
+		reportsCmd(client, *addr, *token, flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// retentionCmd runs `chatctl retention ...`; without a subcommand it prints
+// the dry-run report.
+func retentionCmd(c *http.Client, addr, token string, args []string) {
+	sub := "report"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch {
+	case sub == "report":
+		doGET(c, addr+"/admin/retention/report", token)
+	case sub == "purge":
+		doPOST(c, addr+"/admin/retention/purge", token)
+	case sub == "log" && len(args) <= 2:
+		url := addr + "/admin/retention/purges"
+		if len(args) == 2 {
+			url += "?roomId=" + args[1]
+		}
+		doGET(c, url, token)
+	case sub == "show" && len(args) == 2:
+		doGET(c, addr+"/admin/retention/rooms/"+args[1], token)
+	case sub == "set" && len(args) == 3:
+		days := args[2]
+		if days == "default" {
+			days = "null"
+		} else if _, err := strconv.Atoi(days); err != nil {
+			fatal(errors.New(`days must be a number or "default"`))
+		}
+		doPUT(c, addr+"/admin/retention/rooms/"+args[1], token, `{"days":`+days+`}`)
+	case sub == "hold" && len(args) == 3 && (args[2] == "on" || args[2] == "off"):
+		doPUT(c, addr+"/admin/retention/rooms/"+args[1], token, `{"legalHold":`+strconv.FormatBool(args[2] == "on")+`}`)
 	default:
 		usage()
 		os.Exit(2)
@@ -43,7 +115,158 @@ func main() {
 
 func usage() {
 	fmt.Println("usage:")
-	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> status|stop|restart")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> status|latency|stop|restart")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> log-level [debug|info|warn|error]")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> retention [report|purge|log [roomId]]")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> retention show <roomId> | set <roomId> <days|default> | hold <roomId> on|off")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> export <roomId> [file.jsonl]")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> import [file.jsonl]")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> reviews [pending|approved|rejected|all] | reviews approve|reject <reviewId>")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> reports [open|resolved|dismissed|all] | reports resolve|dismiss <reportId>")
+	fmt.Println("  chatctl -addr http://127.0.0.1:9099 -token <TOKEN> audit [actor=|action=|targetType=|targetId=|since=|until=|cursor=|limit=<value> ...]")
+	fmt.Println("  chatctl -addr https://127.0.0.1:9099 -cacert ca.crt -cert admin.crt -key admin.key -token <TOKEN> status")
+}
+
+// reviewsCmd lists the moderation review queue (pending by default) or
+// resolves one review.
+func reviewsCmd(c *http.Client, addr, token string, args []string) {
+	switch {
+	case len(args) == 0:
+		doGET(c, addr+"/admin/moderation/reviews", token)
+	case len(args) == 1:
+		doGET(c, addr+"/admin/moderation/reviews?status="+url.QueryEscape(args[0]), token)
+	case len(args) == 2 && (args[0] == "approve" || args[0] == "reject"):
+		if _, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+			fatal(errors.New("reviewId must be a number"))
+		}
+		status := map[string]string{"approve": "approved", "reject": "rejected"}[args[0]]
+		doPOSTJSON(c, addr+"/admin/moderation/reviews/"+args[1], token, `{"status":"`+status+`"}`)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// reportsCmd lists the reports users filed (open by default) or closes one.
+func reportsCmd(c *http.Client, addr, token string, args []string) {
+	switch {
+	case len(args) == 0:
+		doGET(c, addr+"/admin/reports", token)
+	case len(args) == 1:
+		doGET(c, addr+"/admin/reports?status="+url.QueryEscape(args[0]), token)
+	case len(args) == 2 && (args[0] == "resolve" || args[0] == "dismiss"):
+		if _, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+			fatal(errors.New("reportId must be a number"))
+		}
+		status := map[string]string{"resolve": "resolved", "dismiss": "dismissed"}[args[0]]
+		doPOSTJSON(c, addr+"/admin/reports/"+args[1], token, `{"status":"`+status+`"}`)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// auditCmd queries the audit log; each argument is a key=value filter passed
+// on as a query parameter, e.g. `chatctl audit action=room.export limit=20`.
+func auditCmd(c *http.Client, addr, token string, args []string) {
+	q := url.Values{}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			fatal(fmt.Errorf("filter %q is not key=value", a))
+		}
+		q.Set(k, v)
+	}
+	u := addr + "/admin/audit"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	doGET(c, u, token)
+}
+
+// exportCmd writes a room archive to the file given, or to stdout.
+func exportCmd(c *http.Client, addr, token string, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+		os.Exit(2)
+	}
+	if _, err := strconv.ParseInt(args[0], 10, 64); err != nil {
+		fatal(errors.New("roomId must be a number"))
+	}
+	out := io.Writer(os.Stdout)
+	if len(args) == 2 {
+		f, err := os.Create(args[1])
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	req, _ := http.NewRequest(http.MethodGet, addr+"/admin/rooms/"+args[0]+"/export", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	stream(c, req, out)
+}
+
+// importCmd sends the archive in the file given, or on stdin.
+func importCmd(c *http.Client, addr, token string, args []string) {
+	if len(args) > 1 {
+		usage()
+		os.Exit(2)
+	}
+	in := io.Reader(os.Stdin)
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	req, _ := http.NewRequest(http.MethodPost, addr+"/admin/rooms/import", in)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	stream(c, req, os.Stdout)
+}
+
+// stream sends req without the client timeout, since archives can be large,
+// and copies the response body to out.
+func stream(c *http.Client, req *http.Request, out io.Writer) {
+	sc := *c
+	sc.Timeout = 0
+	res, err := sc.Do(req)
+	if err != nil {
+		fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(res.Body)
+		fatal(fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(b))))
+	}
+	if _, err := io.Copy(out, res.Body); err != nil {
+		fatal(err)
+	}
+}
+
+func clientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := tlsutil.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("-cert and -key must be given together")
+		}
+		c, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{c}
+	}
+	return cfg, nil
 }
 
 func doGET(c *http.Client, url, token string) {
@@ -76,6 +299,30 @@ func doPOST(c *http.Client, url, token string) {
 	}
 }
 
+func doPUT(c *http.Client, url, token, body string) {
+	doJSON(c, http.MethodPut, url, token, body)
+}
+
+func doPOSTJSON(c *http.Client, url, token, body string) {
+	doJSON(c, http.MethodPost, url, token, body)
+}
+
+func doJSON(c *http.Client, method, url, token, body string) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.Do(req)
+	if err != nil {
+		fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	fmt.Print(string(b))
+	if res.StatusCode >= 300 {
+		os.Exit(1)
+	}
+}
+
 func fatal(err error) {
 	fmt.Fprintln(os.Stderr, "ERROR:", err)
 	os.Exit(1)
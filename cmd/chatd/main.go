@@ -2,91 +2,254 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/archive"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/clientip"
 	"github.com/yngus4862/chat/internal/config"
 	"github.com/yngus4862/chat/internal/control"
 	"github.com/yngus4862/chat/internal/db"
+	"github.com/yngus4862/chat/internal/grpcapi"
 	"github.com/yngus4862/chat/internal/health"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/push"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/retention"
+	"github.com/yngus4862/chat/internal/scheduler"
 	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/tlsutil"
+	"github.com/yngus4862/chat/internal/tracing"
 	"github.com/yngus4862/chat/internal/ws"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 )
 
 func main() {
 	cfg := config.Load()
 	startedAt := time.Now()
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fatal("logging setup failed", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			fatal("migrate failed", err)
+		}
+		return
+	}
 
 	rootCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// DB
-	dbConn, err := db.Connect(rootCtx, cfg.PostgresURL())
+	shutdownTracing, err := tracing.Setup(rootCtx, tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		File:        cfg.TracingFile,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
 	if err != nil {
-		log.Fatal("db connect failed: ", err)
+		fatal("tracing setup failed", err)
 	}
-	defer dbConn.Close()
+	flushTraces := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}
+	defer flushTraces()
 
-	st := store.New(dbConn.Pool)
+	// Storage
+	st, closeStore := openStore(rootCtx, cfg)
+	defer closeStore()
 
 	// Redis pubsub for WS (optional but recommended)
 	var ps *ws.RedisPubSub
 	ps = ws.NewRedisPubSub(cfg.RedisAddr())
 	defer func() { _ = ps.Close() }()
 
-	hub := ws.NewHub(st, ps)
+	// Rate limiting
+	limiter, budgets, wsBudget := setupRateLimit(cfg)
+	if c, ok := limiter.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+
+	// Moderation
+	filters, err := moderation.Filters(moderation.Options{
+		Words:         cfg.ModerationWords,
+		WordsAction:   cfg.ModerationWordsAction,
+		SecretsAction: cfg.ModerationSecrets,
+		MaxRunes:      cfg.ModerationMaxLength,
+		MaxLines:      cfg.ModerationMaxLines,
+		LengthAction:  cfg.ModerationLengthAction,
+	})
+	if err != nil {
+		fatal("invalid MODERATION_* settings", err)
+	}
+	moderator := moderation.New(st, filters...)
+
+	proxies, err := clientip.New(cfg.TrustedProxies)
+	if err != nil {
+		fatal("invalid TRUSTED_PROXIES", err)
+	}
+
+	hub := ws.NewHub(st, ps, ws.Options{
+		Proxies:         proxies,
+		SendLimiter:     limiter,
+		SendBudget:      wsBudget,
+		AllowedOrigins:  cfg.WSAllowedOrigins,
+		MaxConns:        cfg.WSMaxConns,
+		MaxConnsPerUser: cfg.WSMaxConnsPerUser,
+		MaxRoomsPerConn: cfg.WSMaxRoomsPerConn,
+		Moderator:       moderator,
+	})
+
+	metrics.RegisterConnStats(func() metrics.ConnStats {
+		s := hub.Stats()
+		return metrics.ConnStats{Connections: s.Connections, Users: s.Users, Rooms: s.Rooms}
+	})
+
+	// Scheduled messages & reminders
+	sched := scheduler.New(st, hub, moderator, push.LogNotifier{}, cfg.SchedulerInterval)
+	go sched.Run(rootCtx)
+
+	// Message retention
+	purger := retention.New(st, retention.Options{
+		DefaultDays: cfg.RetentionDays,
+		Interval:    cfg.RetentionInterval,
+		BatchSize:   cfg.RetentionBatch,
+		BatchPause:  cfg.RetentionBatchPause,
+	})
+	go purger.Run(rootCtx)
 
 	// Readiness
 	readyFn := func() health.Result {
 		return health.Ready(rootCtx, st, ps)
 	}
 
-	h := &api.Handlers{Store: st, Hub: hub}
-	router := api.NewRouter(api.Deps{Handlers: h, ReadyFn: readyFn})
+	single := cfg.AppListenMode == "single"
+	wsAddr := cfg.AppWSAddr
+	if single {
+		wsAddr = cfg.AppHTTPAddr
+	}
 
-	restSrv := &http.Server{Addr: cfg.AppHTTPAddr, Handler: router, ReadHeaderTimeout: 5 * time.Second}
-	wsMux := http.NewServeMux()
-	wsMux.HandleFunc("/ws", hub.ServeWS)
-	wsSrv := &http.Server{Addr: cfg.AppWSAddr, Handler: wsMux, ReadHeaderTimeout: 5 * time.Second}
+	auditRec := audit.New(st)
+	h := &api.Handlers{Store: st, Hub: hub, Audit: auditRec, Moderator: moderator}
+	deps := api.Deps{Handlers: h, ReadyFn: readyFn, RateLimiter: limiter, RateBudgets: budgets, Proxies: proxies}
+	if single {
+		deps.WS = hub.ServeWS
+	}
+	router := api.NewRouter(deps)
+
+	// TLS (optional, per listener)
+	tlsMin, err := tlsutil.ParseMinVersion(cfg.TLSMinVersion)
+	if err != nil {
+		fatal("invalid TLS_MIN_VERSION", err)
+	}
+	restTLS := listenerTLS(rootCtx, "rest", cfg.RESTTLS, tlsMin, cfg.TLSReload)
+	wsTLS := listenerTLS(rootCtx, "ws", cfg.WSTLS, tlsMin, cfg.TLSReload)
+	grpcTLS := listenerTLS(rootCtx, "grpc", cfg.GRPCTLS, tlsMin, cfg.TLSReload)
+	adminTLS := listenerTLS(rootCtx, "admin", cfg.AdminTLS, tlsMin, cfg.TLSReload)
+
+	var restHandler http.Handler = router
+	if cfg.AppHTTPH2C {
+		if restTLS != nil {
+			slog.Warn("APP_HTTP_H2C ignored: REST listener uses TLS")
+		} else {
+			restHandler = h2c.NewHandler(router, &http2.Server{})
+		}
+	}
+	restSrv := &http.Server{Addr: cfg.AppHTTPAddr, Handler: restHandler, ReadHeaderTimeout: 5 * time.Second, TLSConfig: restTLS}
+	servers := []*http.Server{restSrv}
+	var wsSrv *http.Server
+	if !single {
+		wsMux := http.NewServeMux()
+		wsMux.HandleFunc("/ws", hub.ServeWS)
+		wsSrv = &http.Server{Addr: cfg.AppWSAddr, Handler: wsMux, ReadHeaderTimeout: 5 * time.Second, TLSConfig: wsTLS}
+		servers = append(servers, wsSrv)
+	}
+
+	var grpcSrv *grpc.Server
+	if cfg.AppGRPCAddr != "" {
+		grpcSrv = grpcapi.NewServer(st, hub, auditRec, moderator, limiter, budgets, grpcTLS)
+	}
 
 	// Control
 	emitter, sigs := control.New()
 	statusFn := func() control.Status {
-		return control.BuildStatus(startedAt, cfg.AppHTTPAddr, cfg.AppWSAddr, cfg.AdminHTTPAddr)
+		s := control.BuildStatus(startedAt, cfg.AppHTTPAddr, wsAddr, cfg.AdminHTTPAddr)
+		conns := hub.Stats()
+		s.WS = &control.ConnStats{
+			Connections:           conns.Connections,
+			Users:                 conns.Users,
+			Rooms:                 conns.Rooms,
+			MaxConnections:        conns.MaxConnections,
+			MaxConnectionsPerUser: conns.MaxConnectionsPerUser,
+		}
+		return s
 	}
-	control.StartConsole(rootCtx, os.Stdin, os.Stdout, emitter, statusFn)
+	control.StartConsole(rootCtx, os.Stdin, os.Stdout, emitter, statusFn, auditRec.Record)
+	adminRoutes := append(purger.Routes(), archive.Routes(st)...)
+	adminRoutes = append(adminRoutes, auditRec.Routes()...)
+	adminRoutes = append(adminRoutes, moderator.Routes()...)
+	adminRoutes = append(adminRoutes, moderation.ReportRoutes(st)...)
 
 	go func() {
 		if cfg.AdminToken == "" {
-			log.Println("[admin] ADMIN_TOKEN empty -> admin server disabled")
+			slog.Warn("ADMIN_TOKEN empty, admin server disabled")
 			return
 		}
-		if err := control.StartAdminHTTP(rootCtx, cfg.AdminHTTPAddr, cfg.AdminToken, emitter, statusFn); err != nil {
-			log.Println("[admin] error:", err)
+		if err := control.StartAdminHTTP(rootCtx, cfg.AdminHTTPAddr, cfg.AdminToken, adminTLS, emitter, statusFn, auditRec.Record, adminRoutes...); err != nil {
+			slog.Error("admin server failed", logging.Err(err))
 			emitter.RequestStop()
 		}
 	}()
 
 	// Start servers
 	go func() {
-		log.Println("[app] REST listening:", cfg.AppHTTPAddr)
-		if err := restSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Println("[app] REST error:", err)
-			emitter.RequestStop()
+		if single {
+			slog.Info("REST+WS listening", "addr", cfg.AppHTTPAddr)
+		} else {
+			slog.Info("REST listening", "addr", cfg.AppHTTPAddr)
 		}
-	}()
-	go func() {
-		log.Println("[app] WS listening:", cfg.AppWSAddr)
-		if err := wsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Println("[app] WS error:", err)
+		if err := serve(restSrv); err != nil && err != http.ErrServerClosed {
+			slog.Error("REST server failed", logging.Err(err))
 			emitter.RequestStop()
 		}
 	}()
+	if wsSrv != nil {
+		go func() {
+			slog.Info("WS listening", "addr", cfg.AppWSAddr)
+			if err := serve(wsSrv); err != nil && err != http.ErrServerClosed {
+				slog.Error("WS server failed", logging.Err(err))
+				emitter.RequestStop()
+			}
+		}()
+	}
+	if grpcSrv != nil {
+		lis, err := net.Listen("tcp", cfg.AppGRPCAddr)
+		if err != nil {
+			fatal("gRPC listen failed", err)
+		}
+		go func() {
+			slog.Info("gRPC listening", "addr", cfg.AppGRPCAddr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				slog.Error("gRPC server failed", logging.Err(err))
+				emitter.RequestStop()
+			}
+		}()
+	}
 
 	// OS signals
 	osSig := make(chan os.Signal, 2)
@@ -95,27 +258,132 @@ func main() {
 	for {
 		select {
 		case <-osSig:
-			log.Println("[ctrl] signal -> stop")
-			gracefulStop(restSrv, wsSrv)
+			slog.Info("signal received, stopping")
+			gracefulStop(hub, grpcSrv, servers...)
 			return
 		case <-sigs.Stop:
-			log.Println("[ctrl] stop requested")
-			gracefulStop(restSrv, wsSrv)
+			slog.Info("stop requested")
+			gracefulStop(hub, grpcSrv, servers...)
 			return
 		case <-sigs.Restart:
-			log.Println("[ctrl] restart requested")
-			gracefulStop(restSrv, wsSrv)
+			slog.Info("restart requested")
+			gracefulStop(hub, grpcSrv, servers...)
+			flushTraces() // exec skips deferred calls
 			if err := control.ReexecSelf(); err != nil {
-				log.Println("[ctrl] reexec failed:", err)
+				slog.Error("reexec failed", logging.Err(err))
 				return
 			}
 		}
 	}
 }
 
-func gracefulStop(restSrv, wsSrv *http.Server) {
+// openStore opens the STORE_BACKEND store. For Postgres it also applies
+// DB_MIGRATE and registers the pool metrics.
+func openStore(ctx context.Context, cfg config.Config) (store.Store, func()) {
+	switch cfg.StoreBackend {
+	case "postgres", "":
+		dbConn, err := db.Connect(ctx, cfg.PostgresURL())
+		if err != nil {
+			fatal("db connect failed", err)
+		}
+		st := store.NewPostgres(dbConn.Pool)
+		if err := migrateOnStart(ctx, cfg, st, dbConn); err != nil {
+			fatal("schema not ready", err, "db_migrate", cfg.DBMigrate)
+		}
+		metrics.RegisterPool(dbConn.Pool)
+		return st, dbConn.Close
+	case "sqlite":
+		st, err := store.OpenSQLite(cfg.SQLitePath)
+		if err != nil {
+			fatal("sqlite open failed", err, "path", cfg.SQLitePath)
+		}
+		slog.Info("store", "backend", "sqlite", "path", cfg.SQLitePath)
+		return st, func() { _ = st.Close() }
+	case "memory":
+		slog.Warn("store backend is memory: data is lost on exit and not shared between instances")
+		return store.NewMemory(), func() {}
+	default:
+		fatal("invalid STORE_BACKEND", fmt.Errorf("unknown backend %q (postgres|sqlite|memory)", cfg.StoreBackend))
+		return nil, nil
+	}
+}
+
+// listenerTLS builds a hot-reloading TLS config for a listener, or returns nil
+// when no certificate is configured for it.
+func listenerTLS(ctx context.Context, name string, files tlsutil.Files, minVersion uint16, reload time.Duration) *tls.Config {
+	if !files.Enabled() {
+		return nil
+	}
+	r, err := tlsutil.NewReloader(files, minVersion)
+	if err != nil {
+		fatal("tls setup failed", err, "listener", name)
+	}
+	go r.Watch(ctx, reload)
+	slog.Info("TLS enabled", "listener", name, "client_certs", files.ClientCAFile != "")
+	return r.TLSConfig()
+}
+
+func serve(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
+
+func setupRateLimit(cfg config.Config) (ratelimit.Limiter, ratelimit.Budgets, ratelimit.Budget) {
+	def, err := ratelimit.ParseBudget(cfg.RateLimitDefault)
+	if err != nil {
+		fatal("invalid RATE_LIMIT_DEFAULT", err)
+	}
+	routes, err := ratelimit.ParseRoutes(cfg.RateLimitRoutes)
+	if err != nil {
+		fatal("invalid RATE_LIMIT_ROUTES", err)
+	}
+	wsSend, err := ratelimit.ParseBudget(cfg.RateLimitWSSend)
+	if err != nil {
+		fatal("invalid RATE_LIMIT_WS_SEND", err)
+	}
+	budgets := ratelimit.Budgets{Default: def, Routes: routes}
+
+	switch cfg.RateLimitBackend {
+	case "off":
+		slog.Info("rate limiting disabled")
+		return nil, budgets, wsSend
+	case "redis":
+		slog.Info("rate limiting", "backend", "redis")
+		return ratelimit.NewRedis(redis.NewClient(&redis.Options{Addr: cfg.RedisAddr()})), budgets, wsSend
+	default:
+		return ratelimit.NewMemory(), budgets, wsSend
+	}
+}
+
+// fatal logs msg with err and exits; it stands in for log.Fatal.
+func fatal(msg string, err error, args ...any) {
+	slog.Error(msg, append(args, logging.Err(err))...)
+	os.Exit(1)
+}
+
+// gracefulStop ends the hub's streams first: Shutdown neither waits for nor
+// closes hijacked WebSocket connections, and SSE and StreamRoom would
+// otherwise hold it and GracefulStop until the timeout.
+func gracefulStop(hub *ws.Hub, grpcSrv *grpc.Server, servers ...*http.Server) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	_ = wsSrv.Shutdown(ctx)
-	_ = restSrv.Shutdown(ctx)
+	hub.Close()
+	if grpcSrv != nil {
+		// GracefulStop waits for open StreamRoom calls, so bound it like Shutdown.
+		done := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
+	}
+	for i := len(servers) - 1; i >= 0; i-- {
+		_ = servers[i].Shutdown(ctx)
+	}
 }
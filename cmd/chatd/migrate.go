@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/yngus4862/chat/internal/config"
+	"github.com/yngus4862/chat/internal/db"
+	"github.com/yngus4862/chat/internal/migrate"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/migrations"
+)
+
+const migrateUsage = "usage: chatd migrate up | down [n] | status [-json] | version"
+
+// runMigrate implements `chatd migrate ...` against the configured database.
+func runMigrate(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.StoreBackend != "postgres" && cfg.StoreBackend != "" {
+		return fmt.Errorf("migrations apply to the postgres backend only (STORE_BACKEND=%s)", cfg.StoreBackend)
+	}
+	ctx := context.Background()
+	conn, err := db.Connect(ctx, cfg.PostgresURL())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r, err := migrate.New(conn.Pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := r.Up(ctx)
+		for _, v := range applied {
+			fmt.Printf("applied %d\n", v)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no change")
+		}
+		return err
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return errors.New("down: n must be a positive number")
+			}
+		}
+		reverted, err := r.Down(ctx, n)
+		for _, v := range reverted {
+			fmt.Printf("reverted %d\n", v)
+		}
+		return err
+	case "status":
+		st, err := r.Status(ctx)
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 && args[1] == "-json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(st)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range st {
+			fmt.Fprintf(tw, "%d\t%s\t%t\n", s.Version, s.Name, s.Applied)
+		}
+		return tw.Flush()
+	case "version":
+		v, dirty, err := r.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", v)
+		} else {
+			fmt.Println(v)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// migrateOnStart applies DB_MIGRATE before chatd starts serving.
+func migrateOnStart(ctx context.Context, cfg config.Config, st *store.Postgres, conn *db.Conn) error {
+	switch cfg.DBMigrate {
+	case "off":
+		return nil
+	case "auto":
+		return st.EnsureSchema(ctx)
+	case "check", "":
+		r, err := migrate.New(conn.Pool, migrations.FS)
+		if err != nil {
+			return err
+		}
+		return r.Check(ctx)
+	default:
+		return fmt.Errorf("unknown DB_MIGRATE %q (auto|check|off)", cfg.DBMigrate)
+	}
+}
@@ -26,11 +26,19 @@ type room struct {
 type message struct {
 	ID      int64  `json:"id"`
 	RoomID  int64  `json:"roomId"`
+	Seq     int64  `json:"seq"`
 	Content string `json:"content"`
 }
 
+type listRoomsResp struct {
+	Items      []room `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
 type listMessagesResp struct {
 	Items      []message `json:"items"`
+	PrevCursor string    `json:"prevCursor,omitempty"`
 	NextCursor string    `json:"nextCursor,omitempty"`
 	HasMore    bool      `json:"hasMore"`
 }
@@ -38,12 +46,20 @@ type listMessagesResp struct {
 func main() {
 	apiBase := flag.String("api", "http://127.0.0.1:8080", "api base url")
 	wsBase := flag.String("ws", "ws://127.0.0.1:8081/ws", "ws url")
+	grpcAddr := flag.String("grpc", "127.0.0.1:9090", "grpc address (mode=grpc)")
+	mode := flag.String("mode", "rest", "rest | grpc")
 	timeout := flag.Int("timeout", 20, "timeout seconds")
 	flag.Parse()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*timeout)*time.Second)
 	defer cancel()
 
+	if *mode == "grpc" {
+		runGRPC(ctx, *grpcAddr)
+		fmt.Println("[smoke] ✅ ALL PASSED (grpc)")
+		return
+	}
+
 	client := &http.Client{Timeout: 6 * time.Second}
 
 	mustHealth(ctx, client, *apiBase+"/healthz", "ok")
@@ -101,9 +117,9 @@ func mustCreateRoom(ctx context.Context, c *http.Client, url, name string) int64
 }
 
 func mustListRoomsContains(ctx context.Context, c *http.Client, url string, roomID int64) {
-	var rooms []room
-	getJSON(ctx, c, url, &rooms)
-	for _, r := range rooms {
+	var resp listRoomsResp
+	getJSON(ctx, c, url, &resp)
+	for _, r := range resp.Items {
 		if r.ID == roomID {
 			return
 		}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/archive"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/logging"
+)
+
+// ExportRoom streams the room's history as a JSON Lines archive (see
+// internal/archive). Only room admins may export; the admin API can export any
+// room and import archives.
+func (h *Handlers) ExportRoom(c *gin.Context) {
+	roomID, ok := h.requireRoomAdmin(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	h.audit(c, audit.ActionRoomExport, audit.TargetRoom, roomID, nil)
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="room-`+strconv.FormatInt(roomID, 10)+`.jsonl"`)
+	c.Status(http.StatusOK)
+	if err := archive.Export(ctx, h.Store, roomID, c.Writer); err != nil && ctx.Err() == nil {
+		logging.FromContext(ctx).Error("room export failed", "room_id", roomID, logging.Err(err))
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// audit records an action of the calling user; details may be nil.
+func (h *Handlers) audit(c *gin.Context, action, targetType string, targetID int64, details any) {
+	if h.Audit == nil {
+		return
+	}
+	e := store.AuditEvent{
+		Actor:      auth.UserID(c.Request),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatInt(targetID, 10),
+		RequestID:  c.GetString("requestId"),
+		// resolved behind Deps.Proxies only, so a client cannot pick the
+		// address its actions are logged under
+		RemoteAddr: c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if details != nil {
+		e.Details = audit.Details(details)
+	}
+	h.Audit.Record(c.Request.Context(), e)
+}
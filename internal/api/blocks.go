@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/validate"
+)
+
+type reportMessageReq struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+func (h *Handlers) ListBlocks(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	blocks, err := h.Store.ListBlocks(c.Request.Context(), userID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": blocks})
+}
+
+func (h *Handlers) PutBlock(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	blockedID, err := validate.UserID(c.Param("userId"))
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if blockedID == userID {
+		abort(c, validate.ErrBlockSelf)
+		return
+	}
+	b, created, err := h.Store.BlockUser(c.Request.Context(), userID, blockedID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	h.blocksChanged(c, userID)
+	if !created {
+		c.JSON(http.StatusOK, b)
+		return
+	}
+	c.JSON(http.StatusCreated, b)
+}
+
+func (h *Handlers) DeleteBlock(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	blockedID, err := validate.UserID(c.Param("userId"))
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if err := h.Store.UnblockUser(c.Request.Context(), userID, blockedID); err != nil {
+		abort(c, err)
+		return
+	}
+	h.blocksChanged(c, userID)
+	c.Status(http.StatusNoContent)
+}
+
+// blocksChanged applies a block change to the user's open streams.
+func (h *Handlers) blocksChanged(c *gin.Context, userID string) {
+	if h.Hub != nil {
+		h.Hub.BlocksChanged(c.Request.Context(), userID)
+	}
+}
+
+// ReportMessage files a report for the admins (GET /admin/reports). A user
+// reports a message once; reporting it again returns the first report.
+func (h *Handlers) ReportMessage(c *gin.Context) {
+	roomID, ok := parseID(c, "roomId")
+	if !ok {
+		return
+	}
+	messageID, ok := parseID(c, "messageId")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	var req reportMessageReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, errInvalidJSON)
+		return
+	}
+	reason, err := validate.ReportReason(req.Reason)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	note, err := validate.Note(req.Note)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	r, created, err := h.Store.ReportMessage(c.Request.Context(), store.Report{
+		MessageID: messageID,
+		RoomID:    roomID,
+		Reporter:  userID,
+		Reason:    reason,
+		Note:      note,
+	})
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("message reported",
+		"report_id", r.ID, "message_id", messageID, "room_id", roomID, "reason", reason)
+	c.JSON(http.StatusCreated, r)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/logging"
+)
+
+// errorResp is the body of every error response. "error" stays a plain
+// message for older clients; "code" is what new clients should switch on.
+type errorResp struct {
+	Error     string      `json:"error"`
+	Code      apperr.Code `json:"code"`
+	Field     string      `json:"field,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// abort answers err with the uniform error body and stops the handler chain.
+// Internal errors are logged with their cause; the client only sees the code.
+func abort(c *gin.Context, err error) {
+	e := apperr.From(err)
+	if e.Code == apperr.CodeInternal {
+		logging.FromContext(c.Request.Context()).Error("request failed",
+			"method", c.Request.Method, "route", c.FullPath(), logging.Err(err))
+	}
+	c.AbortWithStatusJSON(e.Code.HTTPStatus(), errorResp{
+		Error:     e.Message,
+		Code:      e.Code,
+		Field:     e.Field,
+		RequestID: c.GetString("requestId"),
+	})
+}
+
+var (
+	errInvalidJSON    = apperr.Validation("", "invalid json")
+	errCursorConflict = apperr.Validation("cursor", "cursor cannot be combined with before, after or around")
+)
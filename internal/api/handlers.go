@@ -3,16 +3,27 @@ package api
 import (
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/latency"
+	"github.com/yngus4862/chat/internal/moderation"
 	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/validate"
 	"github.com/yngus4862/chat/internal/ws"
 )
 
 type Handlers struct {
-	Store *store.Store
+	Store store.Store
 	Hub   *ws.Hub
+	// Audit records room creation and room admin actions; nil disables it.
+	Audit *audit.Recorder
+	// Moderator checks message content before it is stored; nil stores it
+	// as sent.
+	Moderator *moderation.Moderator
 }
 
 type createRoomReq struct {
@@ -24,8 +35,15 @@ type postMessageReq struct {
 	ClientMsgID string `json:"clientMsgId"`
 }
 
+type listRoomsResp struct {
+	Items      []store.Room `json:"items"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+	HasMore    bool         `json:"hasMore"`
+}
+
 type listMessagesResp struct {
 	Items      []store.Message `json:"items"`
+	PrevCursor string          `json:"prevCursor,omitempty"`
 	NextCursor string          `json:"nextCursor,omitempty"`
 	HasMore    bool            `json:"hasMore"`
 }
@@ -33,63 +51,81 @@ type listMessagesResp struct {
 func (h *Handlers) CreateRoom(c *gin.Context) {
 	var req createRoomReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		abort(c, errInvalidJSON)
 		return
 	}
-	name := strings.TrimSpace(req.Name)
-	if name == "" || len([]rune(name)) > 200 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "name required (<=200)"})
+	name, err := validate.RoomName(req.Name)
+	if err != nil {
+		abort(c, err)
 		return
 	}
 
-	r, err := h.Store.CreateRoom(c.Request.Context(), name)
+	r, err := h.Store.CreateRoom(c.Request.Context(), name, auth.UserID(c.Request))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abort(c, err)
 		return
 	}
+	h.audit(c, audit.ActionRoomCreate, audit.TargetRoom, r.ID, gin.H{"name": r.Name})
 	c.JSON(http.StatusCreated, r)
 }
 
 func (h *Handlers) ListRooms(c *gin.Context) {
+	sort, err := validate.RoomSort(c.Query("sort"))
+	if err != nil {
+		abort(c, err)
+		return
+	}
 	limit := parseInt(c.Query("limit"), 50)
-	rooms, err := h.Store.ListRooms(c.Request.Context(), limit)
+
+	rooms, nextCursor, err := h.Store.ListRooms(c.Request.Context(), store.ListRoomsParams{
+		Sort:   sort,
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abort(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, rooms)
+	c.JSON(http.StatusOK, listRoomsResp{Items: rooms, NextCursor: nextCursor, HasMore: nextCursor != ""})
 }
 
 func (h *Handlers) PostMessage(c *gin.Context) {
+	ctx := latency.WithReceived(c.Request.Context(), time.Now())
 	roomID, err := strconv.ParseInt(c.Param("roomId"), 10, 64)
 	if err != nil || roomID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid roomId"})
+		abort(c, apperr.Validation("roomId", "invalid roomId"))
 		return
 	}
 	var req postMessageReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		abort(c, errInvalidJSON)
 		return
 	}
-	content := strings.TrimSpace(req.Content)
-	if content == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "content required"})
+	content, ok := validContent(c, req.Content)
+	if !ok {
 		return
 	}
-	if len([]rune(content)) > 5000 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "content too long (<=5000)"})
+	clientMsgID, err := validate.ClientMsgID(req.ClientMsgID)
+	if err != nil {
+		abort(c, err)
 		return
 	}
-	clientMsgID := strings.TrimSpace(req.ClientMsgID)
 
-	msg, err := h.Store.CreateMessage(c.Request.Context(), roomID, content, "rest", clientMsgID)
+	verdict, err := h.Moderator.Check(ctx, content)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	userID := auth.UserID(c.Request)
+	msg, err := h.Store.CreateMessage(ctx, roomID, userID, verdict.Content, "rest", clientMsgID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abort(c, err)
 		return
 	}
+	h.Moderator.Review(ctx, msg, userID, verdict)
 
 	if h.Hub != nil {
-		h.Hub.BroadcastMessage(c.Request.Context(), msg)
+		h.Hub.BroadcastMessage(ctx, msg)
 	}
 
 	c.JSON(http.StatusCreated, msg)
@@ -98,26 +134,76 @@ func (h *Handlers) PostMessage(c *gin.Context) {
 func (h *Handlers) ListMessages(c *gin.Context) {
 	roomID, err := strconv.ParseInt(c.Param("roomId"), 10, 64)
 	if err != nil || roomID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid roomId"})
+		abort(c, apperr.Validation("roomId", "invalid roomId"))
+		return
+	}
+	q := store.MessageQuery{
+		Before: parseInt64(c.Query("before"), 0),
+		After:  parseInt64(c.Query("after"), 0),
+		Around: parseInt64(c.Query("around"), 0),
+		Limit:  parseInt(c.Query("limit"), 50),
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if q.Before != 0 || q.After != 0 || q.Around != 0 {
+			abort(c, errCursorConflict)
+			return
+		}
+		if q.Before, q.After, err = store.DecodeMessageCursor(cursor); err != nil {
+			abort(c, err)
+			return
+		}
+	}
+	if err := validate.MessageQuery(q); err != nil {
+		abort(c, err)
 		return
 	}
-	limit := parseInt(c.Query("limit"), 50)
-	cursor := parseInt64(c.Query("cursor"), 0)
 
-	items, nextCursor, err := h.Store.ListMessages(c.Request.Context(), roomID, cursor, limit)
+	page, err := h.Store.ListMessages(c.Request.Context(), roomID, q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abort(c, err)
 		return
 	}
-	resp := listMessagesResp{Items: items}
-	if nextCursor > 0 {
-		resp.NextCursor = strconv.FormatInt(nextCursor, 10)
+	resp := listMessagesResp{Items: page.Items}
+	if page.NextCursor > 0 {
+		resp.NextCursor = store.EncodeMessageCursor(page.NextCursor, 0)
+	}
+	if page.PrevCursor > 0 {
+		resp.PrevCursor = store.EncodeMessageCursor(0, page.PrevCursor)
 	}
-	resp.HasMore = len(items) == limit
+	resp.HasMore = page.NextCursor > 0
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// validContent trims message content and answers 400 when it is empty or too long.
+func validContent(c *gin.Context, raw string) (string, bool) {
+	content, err := validate.Content(raw)
+	if err != nil {
+		abort(c, err)
+		return "", false
+	}
+	return content, true
+}
+
+// requireUser returns the caller's user id, answering 401 for anonymous requests.
+func requireUser(c *gin.Context) (string, bool) {
+	userID := auth.UserID(c.Request)
+	if userID == "" {
+		abort(c, apperr.New(apperr.CodeUnauthenticated, "authentication required"))
+		return "", false
+	}
+	return userID, true
+}
+
+func parseID(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		abort(c, apperr.Validation(name, "invalid "+name))
+		return 0, false
+	}
+	return id, true
+}
+
 func parseInt(v string, def int) int {
 	if v == "" {
 		return def
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
+	"github.com/yngus4862/chat/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request id in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID keeps the caller's X-Request-ID (e.g. set by nginx) or assigns a
+// new one, stores it under "requestId" and echoes it in the response. The
+// request context gets a logger tagged with the id, so the store and hub log
+// under the same request_id as the access log.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(c.GetHeader(RequestIDHeader))
+		if id == "" || len(id) > 128 {
+			id = logging.NewID()
+		}
+		c.Set("requestId", id)
+		c.Header(RequestIDHeader, id)
+
+		l := logging.FromContext(c.Request.Context()).With("request_id", id)
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), l))
+		c.Next()
+	}
+}
+
+// untraced are the routes that hold their connection open (WebSocket, SSE).
+// A span would last as long as the connection and say nothing about the
+// messages; the hub traces those itself.
+var untraced = map[string]bool{
+	"/ws":                      true,
+	"/v1/rooms/:roomId/events": true,
+}
+
+// Trace starts a server span per request, continuing the caller's
+// traceparent, and adds trace_id to the request logger. Streaming routes get
+// no span.
+func Trace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if untraced[c.FullPath()] {
+			c.Next()
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			name = c.Request.Method
+		}
+		ctx, span := tracing.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				attribute.String("chat.request_id", c.GetString("requestId")),
+			),
+		)
+		defer span.End()
+		if id := tracing.TraceID(ctx); id != "" {
+			ctx = logging.WithContext(ctx, logging.FromContext(ctx).With("trace_id", id))
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// AccessLog writes one line per request through the request's logger and
+// records it in the HTTP metrics. Server errors are logged at error level,
+// everything else at info.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		elapsed := time.Since(start)
+		metrics.ObserveHTTP(c.Request.Method, c.FullPath(), status, elapsed)
+		lvl := slog.LevelInfo
+		if status >= 500 {
+			lvl = slog.LevelError
+		}
+		logging.FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), lvl, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_id", auth.UserID(c.Request)),
+		)
+	}
+}
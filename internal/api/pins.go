@@ -0,0 +1,208 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/validate"
+	"github.com/yngus4862/chat/internal/ws"
+)
+
+type addPinReq struct {
+	MessageID int64 `json:"messageId"`
+}
+
+type reorderPinsReq struct {
+	MessageIDs []int64 `json:"messageIds"`
+}
+
+type putBookmarkReq struct {
+	Note string `json:"note"`
+}
+
+type listBookmarksResp struct {
+	Items      []store.Bookmark `json:"items"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+	HasMore    bool             `json:"hasMore"`
+}
+
+func (h *Handlers) ListPins(c *gin.Context) {
+	roomID, ok := parseID(c, "roomId")
+	if !ok {
+		return
+	}
+	pins, err := h.Store.ListPins(c.Request.Context(), roomID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": pins})
+}
+
+func (h *Handlers) AddPin(c *gin.Context) {
+	roomID, ok := h.requireRoomAdmin(c)
+	if !ok {
+		return
+	}
+	userID := auth.UserID(c.Request)
+	var req addPinReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.MessageID <= 0 {
+		abort(c, apperr.Validation("messageId", "messageId required"))
+		return
+	}
+
+	pin, created, err := h.Store.AddPin(c.Request.Context(), roomID, req.MessageID, userID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, pin)
+		return
+	}
+	h.audit(c, audit.ActionPinAdd, audit.TargetMessage, req.MessageID, gin.H{"roomId": roomID})
+	if h.Hub != nil {
+		h.Hub.BroadcastEvent(c.Request.Context(), ws.Event{Type: ws.EventPinAdded, RoomID: roomID, Data: pin})
+	}
+	c.JSON(http.StatusCreated, pin)
+}
+
+func (h *Handlers) RemovePin(c *gin.Context) {
+	roomID, ok := h.requireRoomAdmin(c)
+	if !ok {
+		return
+	}
+	messageID, ok := parseID(c, "messageId")
+	if !ok {
+		return
+	}
+	err := h.Store.RemovePin(c.Request.Context(), roomID, messageID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	h.audit(c, audit.ActionPinRemove, audit.TargetMessage, messageID, gin.H{"roomId": roomID})
+	if h.Hub != nil {
+		h.Hub.BroadcastEvent(c.Request.Context(), ws.Event{
+			Type:   ws.EventPinRemoved,
+			RoomID: roomID,
+			Data:   gin.H{"messageId": messageID},
+		})
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) ReorderPins(c *gin.Context) {
+	roomID, ok := h.requireRoomAdmin(c)
+	if !ok {
+		return
+	}
+	var req reorderPinsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, errInvalidJSON)
+		return
+	}
+	pins, err := h.Store.ReorderPins(c.Request.Context(), roomID, req.MessageIDs)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	h.audit(c, audit.ActionPinReorder, audit.TargetRoom, roomID, gin.H{"messageIds": req.MessageIDs})
+	c.JSON(http.StatusOK, gin.H{"items": pins})
+}
+
+func (h *Handlers) ListBookmarks(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	limit := parseInt(c.Query("limit"), 50)
+	cursor := parseInt64(c.Query("cursor"), 0)
+
+	items, next, err := h.Store.ListBookmarks(c.Request.Context(), userID, cursor, limit)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	resp := listBookmarksResp{Items: items, HasMore: next > 0}
+	if next > 0 {
+		resp.NextCursor = strconv.FormatInt(next, 10)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) PutBookmark(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	messageID, ok := parseID(c, "messageId")
+	if !ok {
+		return
+	}
+	var req putBookmarkReq
+	// the body is optional; a bookmark without a note is fine
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abort(c, errInvalidJSON)
+			return
+		}
+	}
+	note, err := validate.Note(req.Note)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	b, err := h.Store.PutBookmark(c.Request.Context(), userID, messageID, note)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+func (h *Handlers) DeleteBookmark(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	messageID, ok := parseID(c, "messageId")
+	if !ok {
+		return
+	}
+	err := h.Store.DeleteBookmark(c.Request.Context(), userID, messageID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// requireRoomAdmin parses :roomId and checks that the caller administers the
+// room, answering 400/401/403 otherwise.
+func (h *Handlers) requireRoomAdmin(c *gin.Context) (int64, bool) {
+	roomID, ok := parseID(c, "roomId")
+	if !ok {
+		return 0, false
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return 0, false
+	}
+	admin, err := h.Store.IsRoomAdmin(c.Request.Context(), roomID, userID)
+	if err != nil {
+		abort(c, err)
+		return 0, false
+	}
+	if !admin {
+		abort(c, apperr.New(apperr.CodeForbidden, "room admin only"))
+		return 0, false
+	}
+	return roomID, true
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/ratelimit"
+)
+
+// RateLimit applies the budget of the matched route per user (or per client IP
+// for anonymous callers). Limiter errors fail open so a Redis outage does not
+// take the API down with it.
+func RateLimit(l ratelimit.Limiter, budgets ratelimit.Budgets) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		b := budgets.For(c.Request.Method, route)
+		if route == "" || !b.Enabled() {
+			c.Next()
+			return
+		}
+		key := c.Request.Method + " " + route + "|" + ratelimit.Subject(auth.UserID(c.Request), c.ClientIP())
+		ok, wait, err := l.Allow(c.Request.Context(), key, b)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Warn("rate limiter failed, allowing", logging.Err(err))
+		}
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abort(c, apperr.New(apperr.CodeRateLimited, "rate limited"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -4,17 +4,36 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/clientip"
 	"github.com/yngus4862/chat/internal/health"
+	"github.com/yngus4862/chat/internal/ratelimit"
 )
 
 type Deps struct {
 	Handlers *Handlers
 	ReadyFn  func() health.Result
+
+	// RateLimiter is optional; nil disables rate limiting.
+	RateLimiter ratelimit.Limiter
+	RateBudgets ratelimit.Budgets
+
+	// Proxies are the trusted reverse proxies c.ClientIP() looks behind; nil
+	// trusts none, so forwarding headers are ignored.
+	Proxies *clientip.Resolver
+
+	// WS, when set, is mounted at /ws so REST and WebSocket share one listener
+	// and the same middleware. Nil leaves WebSocket to its own listener.
+	WS http.HandlerFunc
 }
 
 func NewRouter(d Deps) *gin.Engine {
 	r := gin.New()
-	r.Use(gin.Recovery())
+	// gin trusts every proxy unless told otherwise; the CIDRs are parsed
+	// already, so this cannot fail.
+	if err := r.SetTrustedProxies(d.Proxies.CIDRs()); err != nil {
+		panic(err)
+	}
+	r.Use(gin.Recovery(), RequestID(), Trace(), AccessLog())
 
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -29,12 +48,46 @@ func NewRouter(d Deps) *gin.Engine {
 		c.JSON(http.StatusServiceUnavailable, res)
 	})
 
+	if d.WS != nil {
+		r.GET("/ws", gin.WrapF(d.WS))
+	}
+
 	v1 := r.Group("/v1")
+	if d.RateLimiter != nil {
+		v1.Use(RateLimit(d.RateLimiter, d.RateBudgets))
+	}
+	v1.Use(ValidateRequest())
 	{
+		v1.GET("/openapi.json", func(c *gin.Context) {
+			c.JSON(http.StatusOK, OpenAPI())
+		})
+
 		v1.POST("/rooms", d.Handlers.CreateRoom)
 		v1.GET("/rooms", d.Handlers.ListRooms)
 		v1.POST("/rooms/:roomId/messages", d.Handlers.PostMessage)
 		v1.GET("/rooms/:roomId/messages", d.Handlers.ListMessages)
+		v1.GET("/rooms/:roomId/messages/poll", d.Handlers.PollMessages)
+		v1.GET("/rooms/:roomId/events", d.Handlers.RoomEvents)
+		v1.GET("/rooms/:roomId/export", d.Handlers.ExportRoom)
+
+		v1.GET("/rooms/:roomId/pins", d.Handlers.ListPins)
+		v1.POST("/rooms/:roomId/pins", d.Handlers.AddPin)
+		v1.PUT("/rooms/:roomId/pins", d.Handlers.ReorderPins)
+		v1.DELETE("/rooms/:roomId/pins/:messageId", d.Handlers.RemovePin)
+
+		v1.POST("/rooms/:roomId/scheduled", d.Handlers.ScheduleMessage)
+		v1.POST("/me/reminders", d.Handlers.CreateReminder)
+		v1.GET("/me/scheduled", d.Handlers.ListScheduled)
+		v1.DELETE("/me/scheduled/:id", d.Handlers.CancelScheduled)
+
+		v1.GET("/me/bookmarks", d.Handlers.ListBookmarks)
+		v1.PUT("/me/bookmarks/:messageId", d.Handlers.PutBookmark)
+		v1.DELETE("/me/bookmarks/:messageId", d.Handlers.DeleteBookmark)
+
+		v1.GET("/me/blocks", d.Handlers.ListBlocks)
+		v1.PUT("/me/blocks/:userId", d.Handlers.PutBlock)
+		v1.DELETE("/me/blocks/:userId", d.Handlers.DeleteBlock)
+		v1.POST("/rooms/:roomId/messages/:messageId/reports", d.Handlers.ReportMessage)
 	}
 
 	return r
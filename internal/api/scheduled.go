@@ -0,0 +1,130 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/validate"
+)
+
+// maxScheduleAhead bounds how far in the future items can be scheduled.
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+type scheduleMessageReq struct {
+	Content string    `json:"content"`
+	SendAt  time.Time `json:"sendAt"`
+}
+
+type createReminderReq struct {
+	MessageID int64     `json:"messageId"`
+	RemindAt  time.Time `json:"remindAt"`
+	Note      string    `json:"note"`
+}
+
+func (h *Handlers) ScheduleMessage(c *gin.Context) {
+	roomID, ok := parseID(c, "roomId")
+	if !ok {
+		return
+	}
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	var req scheduleMessageReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, errInvalidJSON)
+		return
+	}
+	content, ok := validContent(c, req.Content)
+	if !ok {
+		return
+	}
+	if !validScheduleTime(c, req.SendAt, "sendAt") {
+		return
+	}
+
+	// rejects and masks apply now; flags once the scheduler posts it
+	verdict, err := h.Moderator.Check(c.Request.Context(), content)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	sm, err := h.Store.ScheduleMessage(c.Request.Context(), userID, roomID, verdict.Content, req.SendAt)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, sm)
+}
+
+func (h *Handlers) CreateReminder(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	var req createReminderReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.MessageID <= 0 {
+		abort(c, apperr.Validation("messageId", "messageId required"))
+		return
+	}
+	if !validScheduleTime(c, req.RemindAt, "remindAt") {
+		return
+	}
+	note, err := validate.Note(req.Note)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	sm, err := h.Store.ScheduleReminder(c.Request.Context(), userID, req.MessageID, note, req.RemindAt)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, sm)
+}
+
+func (h *Handlers) ListScheduled(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	items, err := h.Store.ListScheduled(c.Request.Context(), userID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handlers) CancelScheduled(c *gin.Context) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	err := h.Store.CancelScheduled(c.Request.Context(), userID, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func validScheduleTime(c *gin.Context, at time.Time, field string) bool {
+	now := time.Now()
+	if at.IsZero() || !at.After(now) {
+		abort(c, apperr.Validation(field, field+" must be in the future"))
+		return false
+	}
+	if at.After(now.Add(maxScheduleAhead)) {
+		abort(c, apperr.Validation(field, field+" too far ahead (<=365d)"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+)
+
+// Fallback transports for clients whose proxies break WebSocket upgrades.
+// Both attach to the hub's room fan-out like a WS connection does.
+
+const (
+	// maxStreamReplay bounds the SSE catch-up on resume, as for WS sinceSeq.
+	maxStreamReplay = 1000
+	sseHeartbeat    = 15 * time.Second
+
+	defaultPollTimeout = 25 * time.Second
+	maxPollTimeout     = 60 * time.Second
+)
+
+type pollResp struct {
+	Items []store.Message `json:"items"`
+	// LastSeq is the "after" value for the next poll.
+	LastSeq int64 `json:"lastSeq"`
+	HasMore bool  `json:"hasMore"`
+}
+
+// frameHead is what the transports need from a hub frame: events carry a
+// type, messages an id and seq.
+type frameHead struct {
+	Type string `json:"type"`
+	ID   int64  `json:"id"`
+	Seq  int64  `json:"seq"`
+}
+
+// RoomEvents streams a room as Server-Sent Events. Messages are sent as the
+// default "message" event with the message ID as the event id, so a
+// reconnecting EventSource resumes through Last-Event-ID; room and personal
+// events use their type as the event name.
+func (h *Handlers) RoomEvents(c *gin.Context) {
+	roomID, ok := h.requireRoom(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	// EventSource cannot set headers on the first connect, so the query
+	// parameter is accepted too.
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("lastEventId")
+	}
+	replayAfter := int64(-1)
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || id <= 0 {
+			abort(c, apperr.Validation("Last-Event-ID", "invalid Last-Event-ID"))
+			return
+		}
+		m, err := h.Store.GetMessage(ctx, id)
+		if errors.Is(err, store.ErrMessageNotFound) || (err == nil && m.RoomID != roomID) {
+			abort(c, apperr.Validation("Last-Event-ID", "Last-Event-ID is not a message of this room"))
+			return
+		}
+		if err != nil {
+			abort(c, err)
+			return
+		}
+		replayAfter = m.Seq
+	}
+
+	if h.Hub == nil {
+		abort(c, apperr.New(apperr.CodeUnavailable, "realtime unavailable"))
+		return
+	}
+	sub, err := h.Hub.Subscribe(c.Request.Context(), roomID, ws.SubscribeOptions{UserID: auth.UserID(c.Request), IP: c.ClientIP(), Personal: true, Replay: replayAfter >= 0})
+	if err != nil {
+		refuse(c, err)
+		return
+	}
+	defer sub.Close()
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, "retry: 3000\n\n")
+	w.Flush()
+
+	// Subscribed before replaying so nothing published meanwhile is missed.
+	// Those frames are held until the replay is written; live messages the
+	// replay already covered are skipped.
+	var replayed int64
+	send := func(f ws.Frame) error {
+		var head frameHead
+		if err := json.Unmarshal(f.Data, &head); err != nil {
+			return nil
+		}
+		if head.Type == "" && head.Seq <= replayed {
+			return nil
+		}
+		// Flushed here rather than by the loop so the write time covers it.
+		start := time.Now()
+		if err := writeSSE(w, head, f.Data); err != nil {
+			return err
+		}
+		w.Flush()
+		f.Written(start)
+		return nil
+	}
+	if replayAfter >= 0 {
+		var err error
+		if replayed, err = h.replaySSE(c, sub, roomID, replayAfter); err != nil {
+			return
+		}
+		for _, f := range sub.EndReplay(replayed) {
+			if err := send(f); err != nil {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Done():
+			return
+		case f := <-sub.Frames():
+			if err := send(f); err != nil {
+				return
+			}
+			continue
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}
+}
+
+// replaySSE writes the messages after afterSeq, except those sub hides, and
+// returns the last seq covered.
+func (h *Handlers) replaySSE(c *gin.Context, sub *ws.Subscriber, roomID, afterSeq int64) (int64, error) {
+	for sent := 0; sent < maxStreamReplay; {
+		msgs, more, err := h.Store.MessagesAfter(c.Request.Context(), roomID, afterSeq, 200)
+		if err != nil {
+			return afterSeq, err
+		}
+		for _, m := range msgs {
+			if sub.Hides(m) {
+				afterSeq = m.Seq
+				continue
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				return afterSeq, err
+			}
+			if err := writeSSE(c.Writer, frameHead{ID: m.ID, Seq: m.Seq}, b); err != nil {
+				return afterSeq, err
+			}
+			afterSeq = m.Seq
+		}
+		c.Writer.Flush()
+		sent += len(msgs)
+		if !more {
+			break
+		}
+	}
+	return afterSeq, nil
+}
+
+func writeSSE(w io.Writer, head frameHead, data []byte) error {
+	var err error
+	if head.Type != "" {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", head.Type, data)
+	} else {
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", head.ID, data)
+	}
+	return err
+}
+
+// PollMessages long-polls for messages with seq greater than "after". It
+// answers as soon as any exist, or with an empty page once the timeout
+// (seconds, default 25, max 60) passes. Messages of users the caller blocked
+// are left out; lastSeq still moves past them.
+func (h *Handlers) PollMessages(c *gin.Context) {
+	roomID, ok := h.requireRoom(c)
+	if !ok {
+		return
+	}
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		abort(c, apperr.Validation("after", "invalid after"))
+		return
+	}
+	timeout := defaultPollTimeout
+	if v := c.Query("timeout"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 0 {
+			abort(c, apperr.Validation("timeout", "invalid timeout"))
+			return
+		}
+		timeout = min(time.Duration(secs)*time.Second, maxPollTimeout)
+	}
+	limit := parseInt(c.Query("limit"), 50)
+	ctx := c.Request.Context()
+	userID := auth.UserID(c.Request)
+	blocks, err := store.LoadBlockSet(ctx, h.Store, userID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	// Subscribe before the first read so a message committed in between still
+	// wakes the request. Poll waiters are not personal: they should not count
+	// as presence for reminders they will never see.
+	var (
+		frames   <-chan ws.Frame
+		shutdown <-chan struct{}
+	)
+	if h.Hub != nil && timeout > 0 {
+		sub, err := h.Hub.Subscribe(c.Request.Context(), roomID, ws.SubscribeOptions{UserID: userID, IP: c.ClientIP()})
+		if err != nil {
+			refuse(c, err)
+			return
+		}
+		defer sub.Close()
+		frames, shutdown = sub.Frames(), sub.Done()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		msgs, more, err := h.Store.MessagesAfter(ctx, roomID, after, limit)
+		if err != nil {
+			abort(c, err)
+			return
+		}
+		items := make([]store.Message, 0, len(msgs))
+		for _, m := range msgs {
+			if !blocks.Hides(m) {
+				items = append(items, m)
+			}
+		}
+		if len(msgs) > 0 {
+			after = msgs[len(msgs)-1].Seq
+		}
+		if len(items) > 0 {
+			c.JSON(http.StatusOK, pollResp{Items: items, LastSeq: after, HasMore: more})
+			return
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			c.JSON(http.StatusOK, pollResp{Items: []store.Message{}, LastSeq: after})
+			return
+		case <-shutdown:
+			// answer as on timeout so the client polls again elsewhere
+			c.JSON(http.StatusOK, pollResp{Items: []store.Message{}, LastSeq: after})
+			return
+		case <-frames:
+			// re-read from the store so the page is ordered and gap-free
+		}
+	}
+}
+
+// requireRoom parses :roomId and answers 404 for rooms that do not exist, so
+// streaming clients do not wait on a room that will never speak.
+func (h *Handlers) requireRoom(c *gin.Context) (int64, bool) {
+	roomID, ok := parseID(c, "roomId")
+	if !ok {
+		return 0, false
+	}
+	exists, err := h.Store.RoomExists(c.Request.Context(), roomID)
+	if err != nil {
+		abort(c, err)
+		return 0, false
+	}
+	if !exists {
+		abort(c, store.ErrRoomNotFound)
+		return 0, false
+	}
+	return roomID, true
+}
+
+// refuse answers a hub admission refusal the way ServeWS does.
+func refuse(c *gin.Context, err error) {
+	if apperr.From(err).Code == apperr.CodeUnavailable {
+		c.Header("Retry-After", "5")
+	}
+	abort(c, err)
+}
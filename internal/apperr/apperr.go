@@ -0,0 +1,116 @@
+// Package apperr is the error model shared by the REST, gRPC and WebSocket
+// surfaces: a stable code clients can switch on, a message that is safe to
+// show them and, for validation errors, the offending field. Causes are kept
+// for logs and never sent to clients.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type Code string
+
+const (
+	CodeValidation      Code = "validation_failed"
+	CodeUnauthenticated Code = "unauthenticated"
+	CodeForbidden       Code = "forbidden"
+	CodeNotFound        Code = "not_found"
+	CodeRoomNotFound    Code = "room_not_found"
+	CodeMessageNotFound Code = "message_not_found"
+	CodeConflict        Code = "conflict"
+	CodeRateLimited     Code = "rate_limited"
+	CodeNotJoined       Code = "not_joined"
+	CodeRejected        Code = "content_rejected"
+	CodeUnavailable     Code = "unavailable"
+	CodeCanceled        Code = "canceled"
+	CodeInternal        Code = "internal"
+)
+
+// HTTPStatus is the status REST responses use for the code.
+func (c Code) HTTPStatus() int {
+	switch c {
+	case CodeValidation, CodeNotJoined:
+		return http.StatusBadRequest
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotFound, CodeRoomNotFound, CodeMessageNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeRejected:
+		return http.StatusUnprocessableEntity
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodeCanceled:
+		return 499 // client closed request, as nginx logs it
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type Error struct {
+	Code    Code
+	Message string
+	Field   string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Validation reports a bad input; field may be empty when no single field is
+// to blame.
+func Validation(field, msg string) *Error {
+	return &Error{Code: CodeValidation, Message: msg, Field: field}
+}
+
+// Wrap attaches a cause to a client-facing error.
+func Wrap(code Code, msg string, err error) *Error {
+	return &Error{Code: code, Message: msg, Err: err}
+}
+
+// From classifies any error. *Error values (including the store's sentinels)
+// keep their code, PostgreSQL constraint violations are mapped, and anything
+// else becomes an internal error whose text stays server-side.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.Canceled) {
+		return Wrap(CodeCanceled, "request canceled", err)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23503": // foreign_key_violation
+			return Wrap(CodeNotFound, "referenced resource not found", err)
+		case "23505": // unique_violation
+			return Wrap(CodeConflict, "already exists", err)
+		case "23502", "23514", "22001": // not null, check, string too long
+			return Wrap(CodeValidation, "invalid value", err)
+		}
+	}
+	return Wrap(CodeInternal, "internal error", err)
+}
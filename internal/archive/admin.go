@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/control"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// maxImport bounds the body of an import request.
+const maxImport = 1 << 30
+
+// Routes returns the admin endpoints:
+//
+//	GET  /admin/rooms/{roomId}/export  archive of any room
+//	POST /admin/rooms/import           archive in the body (up to 1 GiB); answers a Result
+func Routes(st store.Store) []control.Route {
+	return []control.Route{
+		{Pattern: "GET /admin/rooms/{roomId}/export", Handler: func(w http.ResponseWriter, r *http.Request) {
+			roomID, err := strconv.ParseInt(r.PathValue("roomId"), 10, 64)
+			if err != nil || roomID <= 0 {
+				http.Error(w, "invalid roomId", http.StatusBadRequest)
+				return
+			}
+			ServeExport(w, r, st, roomID)
+		}, Action: audit.ActionRoomExport},
+		{Pattern: "POST /admin/rooms/import", Handler: func(w http.ResponseWriter, r *http.Request) {
+			res, err := Import(r.Context(), st, http.MaxBytesReader(w, r.Body, maxImport))
+			if err != nil {
+				if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+					http.Error(w, "archive too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				e := apperr.From(err)
+				if e.Code == apperr.CodeInternal {
+					slog.Error("room import failed", "room_id", res.RoomID, logging.Err(err))
+				}
+				http.Error(w, e.Message, e.Code.HTTPStatus())
+				return
+			}
+			slog.Info("room imported", "room_id", res.RoomID, "created", res.RoomCreated,
+				"members", res.Members, "messages", res.Messages, "duplicates", res.Duplicates)
+			control.AuditDetail(r, "roomId", res.RoomID)
+			control.AuditDetail(r, "roomCreated", res.RoomCreated)
+			control.AuditDetail(r, "messages", res.Messages)
+			control.WriteJSON(w, res)
+		}, Action: audit.ActionRoomImport},
+	}
+}
+
+// ServeExport answers with the room's archive. Errors before the first byte
+// get a status; later ones can only cut the stream short.
+func ServeExport(w http.ResponseWriter, r *http.Request, st store.Store, roomID int64) {
+	ctx := r.Context()
+	if _, err := st.GetRoom(ctx, roomID); err != nil {
+		e := apperr.From(err)
+		http.Error(w, e.Message, e.Code.HTTPStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="room-`+strconv.FormatInt(roomID, 10)+`.jsonl"`)
+	if err := Export(ctx, st, roomID, w); err != nil && ctx.Err() == nil {
+		logging.FromContext(ctx).Error("room export failed", "room_id", roomID, logging.Err(err))
+	}
+}
@@ -0,0 +1,274 @@
+// Package archive exports a room's history as JSON Lines and imports it back,
+// for archiving and for moving rooms between environments. An archive starts
+// with one "room" line, followed by "member" lines, then "message" lines in
+// seq order, and ends with an "end" line counting the messages, so a cut-off
+// archive is told apart from a complete one.
+//
+// Imports are idempotent: the room is found again by its origin and messages
+// are deduplicated by clientMsgId, so an interrupted import can simply be
+// run again.
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/validate"
+)
+
+// Version is the archive format version written by Export.
+const Version = 1
+
+const (
+	TypeRoom    = "room"
+	TypeMember  = "member"
+	TypeMessage = "message"
+	TypeEnd     = "end"
+)
+
+// Line is one line of an archive; exactly one of the pointers is set. End
+// lines carry Messages, the number of message lines before them.
+type Line struct {
+	Type     string         `json:"type"`
+	Room     *RoomHeader    `json:"room,omitempty"`
+	Member   *store.Member  `json:"member,omitempty"`
+	Message  *store.Message `json:"message,omitempty"`
+	Messages *int           `json:"messages,omitempty"`
+}
+
+type RoomHeader struct {
+	Version int `json:"version"`
+	// Origin identifies the exported room across environments; importing
+	// the same origin twice reuses the first import's room.
+	Origin     string    `json:"origin"`
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeq    int64     `json:"lastSeq"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// Attachments is the attachments manifest. chatd does not store
+	// attachments, so it is always empty.
+	Attachments []string `json:"attachments"`
+}
+
+// Result is what Import did.
+type Result struct {
+	RoomID      int64 `json:"roomId"`
+	RoomCreated bool  `json:"roomCreated"`
+	Members     int   `json:"members"`
+	Messages    int   `json:"messages"`
+	// Duplicates counts messages the room already had.
+	Duplicates int `json:"duplicates"`
+}
+
+const (
+	exportPage  = 200
+	importBatch = 500
+	// maxLine leaves room for a message at validate.MaxContent in any script.
+	maxLine = 1 << 20
+)
+
+// Export writes the room's archive to w. Each page of messages is flushed
+// when w is an http.Flusher, so large rooms stream.
+func Export(ctx context.Context, st store.Store, roomID int64, w io.Writer) error {
+	room, err := st.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	members, err := st.ListMembers(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(Line{Type: TypeRoom, Room: &RoomHeader{
+		Version:     Version,
+		Origin:      "room:" + strconv.FormatInt(room.ID, 10) + ":" + strconv.FormatInt(room.CreatedAt.UnixMicro(), 10),
+		ID:          room.ID,
+		Name:        room.Name,
+		CreatedAt:   room.CreatedAt,
+		LastSeq:     room.LastSeq,
+		ExportedAt:  time.Now().UTC(),
+		Attachments: []string{},
+	}})
+	if err != nil {
+		return err
+	}
+	for i := range members {
+		if err := enc.Encode(Line{Type: TypeMember, Member: &members[i]}); err != nil {
+			return err
+		}
+	}
+
+	var (
+		after int64
+		count int
+	)
+	for {
+		msgs, more, err := st.MessagesAfter(ctx, roomID, after, exportPage)
+		if err != nil {
+			return err
+		}
+		for i := range msgs {
+			if err := enc.Encode(Line{Type: TypeMessage, Message: &msgs[i]}); err != nil {
+				return err
+			}
+		}
+		count += len(msgs)
+		if !more || len(msgs) == 0 {
+			break
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		after = msgs[len(msgs)-1].Seq
+	}
+	if err := enc.Encode(Line{Type: TypeEnd, Messages: &count}); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Import reads an archive from r into st. Invalid lines, and an archive that
+// stops before its end line, fail the import with a validation error; what
+// was imported before stays.
+func Import(ctx context.Context, st store.Store, r io.Reader) (Result, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+
+	var (
+		res      Result
+		n        int
+		messages int
+		ended    bool
+		members  []store.Member
+		batch    []store.Message
+	)
+	flush := func() error {
+		if len(members) > 0 {
+			added, err := st.ImportMembers(ctx, res.RoomID, members)
+			if err != nil {
+				return err
+			}
+			res.Members += added
+			members = nil
+		}
+		if len(batch) > 0 {
+			added, err := st.ImportMessages(ctx, res.RoomID, batch)
+			if err != nil {
+				return err
+			}
+			res.Messages += added
+			res.Duplicates += len(batch) - added
+			batch = batch[:0]
+		}
+		return nil
+	}
+
+	for sc.Scan() {
+		n++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var l Line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return res, lineError(n, "invalid json")
+		}
+		if res.RoomID == 0 && l.Type != TypeRoom {
+			return res, lineError(n, "archive must start with a room line")
+		}
+		if ended {
+			return res, lineError(n, "line after the end line")
+		}
+		switch l.Type {
+		case TypeRoom:
+			if res.RoomID != 0 || l.Room == nil {
+				return res, lineError(n, "unexpected room line")
+			}
+			h := l.Room
+			if h.Version < 1 || h.Version > Version {
+				return res, lineError(n, "unsupported archive version "+strconv.Itoa(h.Version))
+			}
+			if h.Origin == "" || len(h.Origin) > 256 {
+				return res, lineError(n, "room origin required (<=256)")
+			}
+			name, err := validate.RoomName(h.Name)
+			if err != nil {
+				return res, lineError(n, err.Error())
+			}
+			room, created, err := st.ImportRoom(ctx, h.Origin, name, h.CreatedAt)
+			if err != nil {
+				return res, err
+			}
+			res.RoomID, res.RoomCreated = room.ID, created
+		case TypeMember:
+			m := l.Member
+			if m == nil || m.UserID == "" || len(m.UserID) > 128 {
+				return res, lineError(n, "member userId required (<=128)")
+			}
+			members = append(members, *m)
+		case TypeMessage:
+			m := l.Message
+			if m == nil {
+				return res, lineError(n, "message missing")
+			}
+			if m.ClientMsgID == "" || len(m.ClientMsgID) > validate.MaxClientMsgID {
+				return res, lineError(n, "message clientMsgId required (<=128)")
+			}
+			if m.Content == "" || len([]rune(m.Content)) > validate.MaxContent {
+				return res, lineError(n, "message content required (<=5000)")
+			}
+			if len(m.Author) > 128 {
+				return res, lineError(n, "message author too long (<=128)")
+			}
+			if m.Source == "" {
+				m.Source = "import"
+			}
+			if m.CreatedAt.IsZero() {
+				m.CreatedAt = time.Now()
+			}
+			batch = append(batch, *m)
+			messages++
+			if len(batch) >= importBatch {
+				if err := flush(); err != nil {
+					return res, err
+				}
+			}
+		case TypeEnd:
+			if l.Messages == nil || *l.Messages != messages {
+				return res, lineError(n, "end line does not match the "+strconv.Itoa(messages)+" message lines")
+			}
+			ended = true
+		default:
+			return res, lineError(n, "unknown line type "+strconv.Quote(l.Type))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return res, lineError(n+1, "line too long")
+		}
+		return res, err
+	}
+	if res.RoomID == 0 {
+		return res, apperr.Validation("", "archive is empty")
+	}
+	if !ended {
+		return res, apperr.Validation("", "archive is truncated: no end line")
+	}
+	return res, flush()
+}
+
+func lineError(n int, msg string) error {
+	return apperr.Validation("", fmt.Sprintf("line %d: %s", n, msg))
+}
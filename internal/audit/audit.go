@@ -0,0 +1,145 @@
+// Package audit records administrative and security-relevant actions in the
+// append-only audit log (store.Audit) and serves it on the admin API.
+//
+// Recording never fails the action being recorded: a write that fails is
+// logged and dropped.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/control"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// Actors other than user ids.
+const (
+	ActorAdmin   = "admin"   // admin API, authenticated by the admin token
+	ActorConsole = "console" // operator console on stdin
+)
+
+// Actions. Package control writes the admin.* ones itself, as literals, since
+// it cannot import this package.
+const (
+	ActionRoomCreate     = "room.create"
+	ActionRoomExport     = "room.export"
+	ActionRoomImport     = "room.import"
+	ActionPinAdd         = "pin.add"
+	ActionPinRemove      = "pin.remove"
+	ActionPinReorder     = "pin.reorder"
+	ActionRetentionSet   = "retention.set"
+	ActionRetentionPurge = "retention.purge"
+	ActionReviewResolve  = "review.resolve"
+	ActionReportResolve  = "report.resolve"
+	ActionStop           = "admin.stop"
+	ActionRestart        = "admin.restart"
+	ActionLogLevel       = "admin.log_level"
+	ActionAuthFailed     = "admin.auth_failed"
+)
+
+const (
+	TargetRoom    = "room"
+	TargetMessage = "message"
+)
+
+type Recorder struct {
+	st store.Audit
+}
+
+func New(st store.Audit) *Recorder {
+	return &Recorder{st: st}
+}
+
+// Record appends e. A nil Recorder records nothing, so callers need no
+// checks when auditing is not wired up.
+func (r *Recorder) Record(ctx context.Context, e store.AuditEvent) {
+	if r == nil {
+		return
+	}
+	// the action already happened; a client going away must not lose it
+	if _, err := r.st.AppendAudit(context.WithoutCancel(ctx), e); err != nil {
+		logging.FromContext(ctx).Error("audit write failed",
+			"action", e.Action, "actor", e.Actor, logging.Err(err))
+	}
+}
+
+func (r *Recorder) List(ctx context.Context, q store.AuditQuery) ([]store.AuditEvent, int64, error) {
+	return r.st.ListAudit(ctx, q)
+}
+
+// Details encodes v for AuditEvent.Details.
+func Details(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// Routes returns the admin endpoint of the log:
+//
+//	GET /admin/audit  ?actor=&action=&targetType=&targetId=&since=&until=&cursor=&limit=
+//
+// since and until are RFC 3339 times; cursor is the nextCursor of the
+// previous page.
+func (r *Recorder) Routes() []control.Route {
+	return []control.Route{
+		{Pattern: "GET /admin/audit", Handler: r.serveList},
+	}
+}
+
+func (r *Recorder) serveList(w http.ResponseWriter, req *http.Request) {
+	q, err := parseQuery(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, next, err := r.List(req.Context(), q)
+	if err != nil {
+		e := apperr.From(err)
+		if e.Code == apperr.CodeInternal {
+			logging.FromContext(req.Context()).Error("audit list failed", logging.Err(err))
+		}
+		http.Error(w, e.Message, e.Code.HTTPStatus())
+		return
+	}
+	control.WriteJSON(w, map[string]any{"items": items, "nextCursor": next})
+}
+
+func parseQuery(req *http.Request) (store.AuditQuery, error) {
+	v := req.URL.Query()
+	q := store.AuditQuery{
+		Actor:      v.Get("actor"),
+		Action:     v.Get("action"),
+		TargetType: v.Get("targetType"),
+		TargetID:   v.Get("targetId"),
+	}
+	var err error
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, apperr.Validation("since", "since must be an RFC 3339 time")
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, apperr.Validation("until", "until must be an RFC 3339 time")
+		}
+	}
+	if s := v.Get("cursor"); s != "" {
+		if q.Before, err = strconv.ParseInt(s, 10, 64); err != nil || q.Before <= 0 {
+			return q, apperr.Validation("cursor", "invalid cursor")
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit <= 0 || q.Limit > 200 {
+			return q, apperr.Validation("limit", "limit must be 1..200")
+		}
+	}
+	return q, nil
+}
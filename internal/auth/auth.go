@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// UserHeader carries the authenticated user id. chatd does not verify tokens
+// itself: the gateway in front of it authenticates against the IdP (Keycloak)
+// and forwards the subject in this header.
+const UserHeader = "X-User-ID"
+
+// MetadataKey is the gRPC metadata counterpart of UserHeader.
+const MetadataKey = "x-user-id"
+
+const maxUserIDLen = 128
+
+// UserID returns the caller's user id, or "" for anonymous requests.
+func UserID(r *http.Request) string {
+	return Normalize(r.Header.Get(UserHeader))
+}
+
+// Normalize applies the user id rules to a forwarded value from any transport.
+func Normalize(raw string) string {
+	id := strings.TrimSpace(raw)
+	if len(id) > maxUserIDLen {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,119 @@
+// Package clientip finds the address of the client behind reverse proxies.
+// Forwarding headers are believed only when the peer is a trusted proxy, and
+// X-Forwarded-For is read right to left: proxies append the address they saw,
+// so the entries to the left of the last untrusted hop are whatever the client
+// chose to send. This is the rule gin applies after SetTrustedProxies, so REST
+// and WebSocket agree on who a client is.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver holds the trusted proxies. A nil Resolver trusts none and always
+// answers with the peer address.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New parses proxies, each an IP or a CIDR ("10.0.0.0/8").
+func New(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, err
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// CIDRs returns the trusted proxies in the form gin's SetTrustedProxies takes.
+func (r *Resolver) CIDRs() []string {
+	if r == nil {
+		return nil
+	}
+	out := make([]string, len(r.trusted))
+	for i, p := range r.trusted {
+		out[i] = p.String()
+	}
+	return out
+}
+
+// IP returns the client address of req. Behind a trusted peer it is the
+// rightmost X-Forwarded-For entry that is not a trusted proxy (the leftmost
+// when all are), else X-Real-IP; otherwise the peer itself.
+func (r *Resolver) IP(req *http.Request) string {
+	peer := Peer(req)
+	if !r.trusts(peer) {
+		return peer
+	}
+	if ip, ok := r.forwardedFor(req.Header.Values("X-Forwarded-For")); ok {
+		return ip
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return peer
+}
+
+// Peer is the address of the immediate peer, without the port.
+func Peer(req *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (r *Resolver) forwardedFor(values []string) (string, bool) {
+	var hops []string
+	for _, v := range values {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// a malformed chain says nothing reliable
+			return "", false
+		}
+		ip = ip.Unmap()
+		if i == 0 || !r.trustsAddr(ip) {
+			return ip.String(), true
+		}
+	}
+	return "", false
+}
+
+func (r *Resolver) trusts(host string) bool {
+	ip, err := netip.ParseAddr(host)
+	return err == nil && r.trustsAddr(ip.Unmap())
+}
+
+func (r *Resolver) trustsAddr(ip netip.Addr) bool {
+	if r == nil {
+		return false
+	}
+	for _, p := range r.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
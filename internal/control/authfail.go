@@ -0,0 +1,74 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/yngus4862/chat/internal/store"
+)
+
+const (
+	authFailWindow   = time.Minute
+	authFailMaxHosts = 1024
+)
+
+// authFailures keeps failed admin logins from flooding the audit log. The
+// first failure from a host in a window is recorded as is; later ones are
+// counted and written as one admin.auth_failed summary when the window ends.
+// Past authFailMaxHosts hosts, new hosts share one summary with no address.
+type authFailures struct {
+	audit  AuditFunc
+	window time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]int // failures not yet recorded, by host
+}
+
+func newAuthFailures(audit AuditFunc, window time.Duration) *authFailures {
+	return &authFailures{audit: audit, window: window, hosts: make(map[string]int)}
+}
+
+// record writes ev unless its host already failed in the current window.
+func (f *authFailures) record(ctx context.Context, ev store.AuditEvent) {
+	host := ev.RemoteAddr
+	f.mu.Lock()
+	if _, ok := f.hosts[host]; !ok && len(f.hosts) >= authFailMaxHosts {
+		host = ""
+	}
+	if _, ok := f.hosts[host]; ok {
+		f.hosts[host]++
+		f.mu.Unlock()
+		return
+	}
+	first := host == ev.RemoteAddr
+	if first {
+		f.hosts[host] = 0
+	} else {
+		f.hosts[host] = 1
+	}
+	f.mu.Unlock()
+
+	time.AfterFunc(f.window, func() { f.flush(host) })
+	if first {
+		f.audit(ctx, ev)
+	}
+}
+
+func (f *authFailures) flush(host string) {
+	f.mu.Lock()
+	n := f.hosts[host]
+	delete(f.hosts, host)
+	f.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	details, _ := json.Marshal(map[string]any{"count": n, "window": f.window.String()})
+	f.audit(context.Background(), store.AuditEvent{
+		Actor:      "admin",
+		Action:     "admin.auth_failed",
+		RemoteAddr: host,
+		Details:    details,
+	})
+}
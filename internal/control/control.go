@@ -3,16 +3,25 @@ package control
 import (
 	"bufio"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/yngus4862/chat/internal/latency"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
+	"github.com/yngus4862/chat/internal/store"
 )
 
 type Status struct {
@@ -25,6 +34,18 @@ type Status struct {
 	GoVersion string    `json:"goVersion"`
 	OS        string    `json:"os"`
 	Arch      string    `json:"arch"`
+	LogLevel  string    `json:"logLevel"`
+
+	WS *ConnStats `json:"ws,omitempty"`
+}
+
+// ConnStats reports live WebSocket connections of this instance.
+type ConnStats struct {
+	Connections           int `json:"connections"`
+	Users                 int `json:"users"`
+	Rooms                 int `json:"rooms"`
+	MaxConnections        int `json:"maxConnections,omitempty"`
+	MaxConnectionsPerUser int `json:"maxConnectionsPerUser,omitempty"`
 }
 
 type Emitter struct {
@@ -71,6 +92,7 @@ func BuildStatus(startedAt time.Time, appHTTP, appWS, admin string) Status {
 		GoVersion: runtime.Version(),
 		OS:        runtime.GOOS,
 		Arch:      runtime.GOARCH,
+		LogLevel:  logging.Level(),
 	}
 }
 
@@ -84,7 +106,33 @@ func ReexecSelf() error {
 	return syscall.Exec(exe, args, env)
 }
 
-func StartAdminHTTP(ctx context.Context, addr string, token string, e *Emitter, statusFn func() Status) error {
+// Route is an extra admin endpoint served next to the built-in ones. Pattern
+// is an http.ServeMux pattern; the token check runs before Handler. Requests
+// to a route with an Action are written to the audit log with the response
+// status, the {roomId} path value as target and any AuditDetail the handler
+// added.
+type Route struct {
+	Pattern string
+	Handler http.HandlerFunc
+	Action  string
+}
+
+// AuditFunc writes an event to the audit log (audit.Recorder.Record).
+type AuditFunc func(ctx context.Context, e store.AuditEvent)
+
+type auditDetailsKey struct{}
+
+// AuditDetail adds key to the details of the audit event of r's route.
+func AuditDetail(r *http.Request, key string, v any) {
+	if d, ok := r.Context().Value(auditDetailsKey{}).(map[string]any); ok {
+		d[key] = v
+	}
+}
+
+// StartAdminHTTP serves the admin API until ctx is done. With a non-nil
+// tlsCfg it serves HTTPS; if that config verifies client certificates, callers
+// need both a valid certificate and the token. A nil audit records nothing.
+func StartAdminHTTP(ctx context.Context, addr string, token string, tlsCfg *tls.Config, e *Emitter, statusFn func() Status, audit AuditFunc, routes ...Route) error {
 	if strings.TrimSpace(addr) == "" {
 		return errors.New("admin addr is empty")
 	}
@@ -92,6 +140,58 @@ func StartAdminHTTP(ctx context.Context, addr string, token string, e *Emitter,
 		return errors.New("admin token is empty (refuse to start admin server)")
 	}
 
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           AdminHandler(token, e, statusFn, audit, routes...),
+		ReadHeaderTimeout: 3 * time.Second,
+		TLSConfig:         tlsCfg,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	var err error
+	if tlsCfg != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// AdminHandler is the admin API that StartAdminHTTP serves.
+func AdminHandler(token string, e *Emitter, statusFn func() Status, audit AuditFunc, routes ...Route) http.Handler {
+	if audit == nil {
+		audit = func(context.Context, store.AuditEvent) {}
+	}
+	event := func(r *http.Request, action, targetType, targetID string, details any) store.AuditEvent {
+		ev := store.AuditEvent{
+			Actor:      "admin",
+			Action:     action,
+			TargetType: targetType,
+			TargetID:   targetID,
+			RequestID:  r.Header.Get("X-Request-ID"),
+			RemoteAddr: remoteHost(r),
+			UserAgent:  r.UserAgent(),
+		}
+		if details != nil {
+			ev.Details, _ = json.Marshal(details)
+		}
+		return ev
+	}
+	record := func(r *http.Request, action, targetType, targetID string, details any) {
+		audit(r.Context(), event(r, action, targetType, targetID, details))
+	}
+	failures := newAuthFailures(audit, authFailWindow)
+	authFailed := func(r *http.Request, reason string) {
+		failures.record(r.Context(), event(r, "admin.auth_failed", "", "", map[string]string{"method": r.Method, "path": r.URL.Path, "reason": reason}))
+	}
+
 	mux := http.NewServeMux()
 
 	auth := func(w http.ResponseWriter, r *http.Request) bool {
@@ -99,11 +199,13 @@ func StartAdminHTTP(ctx context.Context, addr string, token string, e *Emitter,
 		const prefix = "Bearer "
 		if !strings.HasPrefix(ah, prefix) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			authFailed(r, "missing token")
 			return false
 		}
 		got := strings.TrimSpace(strings.TrimPrefix(ah, prefix))
-		if got != token {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
 			http.Error(w, "forbidden", http.StatusForbidden)
+			authFailed(r, "bad token")
 			return false
 		}
 		return true
@@ -113,7 +215,53 @@ func StartAdminHTTP(ctx context.Context, addr string, token string, e *Emitter,
 		if !auth(w, r) {
 			return
 		}
-		writeJSON(w, statusFn())
+		WriteJSON(w, statusFn())
+	})
+
+	// GET reports the log level; PUT {"level":"debug"} changes it until the
+	// next restart.
+	mux.HandleFunc("/admin/log-level", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			prev := logging.Level()
+			if err := logging.SetLevel(body.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("log level changed", "level", logging.Level())
+			record(r, "admin.log_level", "", "", map[string]string{"from": prev, "to": logging.Level()})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		WriteJSON(w, map[string]string{"level": logging.Level()})
+	})
+
+	// Prometheus scrapes with the same bearer token (authorization.credentials).
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		metrics.Handler().ServeHTTP(w, r)
+	})
+
+	// Delivery latency percentiles over the recent samples of each stage.
+	mux.HandleFunc("/admin/latency", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		WriteJSON(w, latency.Snapshot())
 	})
 
 	mux.HandleFunc("/admin/stop", func(w http.ResponseWriter, r *http.Request) {
@@ -124,6 +272,7 @@ func StartAdminHTTP(ctx context.Context, addr string, token string, e *Emitter,
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		record(r, "admin.stop", "", "", nil)
 		e.RequestStop()
 		w.WriteHeader(http.StatusAccepted)
 		_, _ = w.Write([]byte("stopping\n"))
@@ -137,30 +286,50 @@ func StartAdminHTTP(ctx context.Context, addr string, token string, e *Emitter,
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		record(r, "admin.restart", "", "", nil)
 		e.RequestRestart()
 		w.WriteHeader(http.StatusAccepted)
 		_, _ = w.Write([]byte("restarting\n"))
 	})
 
-	srv := &http.Server{
-		Addr:              addr,
-		Handler:           mux,
-		ReadHeaderTimeout: 3 * time.Second,
+	for _, rt := range routes {
+		mux.HandleFunc(rt.Pattern, func(w http.ResponseWriter, r *http.Request) {
+			if !auth(w, r) {
+				return
+			}
+			if rt.Action == "" {
+				rt.Handler(w, r)
+				return
+			}
+			details := map[string]any{}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			rt.Handler(sw, r.WithContext(context.WithValue(r.Context(), auditDetailsKey{}, details)))
+			details["status"] = sw.status
+			var targetType string
+			targetID := r.PathValue("roomId")
+			if targetID != "" {
+				targetType = "room"
+			}
+			record(r, rt.Action, targetType, targetID, details)
+		})
 	}
 
-	go func() {
-		<-ctx.Done()
-		_ = srv.Shutdown(context.Background())
-	}()
-
-	err := srv.ListenAndServe()
-	if err == http.ErrServerClosed {
-		return nil
-	}
-	return err
+	return mux
 }
 
-func StartConsole(ctx context.Context, in io.Reader, out io.Writer, e *Emitter, statusFn func() Status) {
+// StartConsole serves the operator console on in. Like the admin API it
+// audits stop, restart and log level changes; a nil audit records nothing.
+func StartConsole(ctx context.Context, in io.Reader, out io.Writer, e *Emitter, statusFn func() Status, audit AuditFunc) {
+	if audit == nil {
+		audit = func(context.Context, store.AuditEvent) {}
+	}
+	record := func(action string, details any) {
+		ev := store.AuditEvent{Actor: "console", Action: action}
+		if details != nil {
+			ev.Details, _ = json.Marshal(details)
+		}
+		audit(ctx, ev)
+	}
 	go func() {
 		sc := bufio.NewScanner(in)
 		fmt.Fprintln(out, "control console: type 'help'")
@@ -170,19 +339,31 @@ func StartConsole(ctx context.Context, in io.Reader, out io.Writer, e *Emitter,
 				return
 			default:
 			}
-			cmd := strings.TrimSpace(sc.Text())
+			cmd, arg, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
 			switch cmd {
 			case "":
 				continue
 			case "help":
-				fmt.Fprintln(out, "commands: status | stop | restart | help")
+				fmt.Fprintln(out, "commands: status | log-level [debug|info|warn|error] | stop | restart | help")
 			case "status":
 				b, _ := json.MarshalIndent(statusFn(), "", "  ")
 				fmt.Fprintln(out, string(b))
+			case "log-level":
+				if arg = strings.TrimSpace(arg); arg != "" {
+					prev := logging.Level()
+					if err := logging.SetLevel(arg); err != nil {
+						fmt.Fprintln(out, err)
+						continue
+					}
+					record("admin.log_level", map[string]string{"from": prev, "to": logging.Level()})
+				}
+				fmt.Fprintln(out, "log level:", logging.Level())
 			case "stop":
+				record("admin.stop", nil)
 				e.RequestStop()
 				fmt.Fprintln(out, "stop requested")
 			case "restart":
+				record("admin.restart", nil)
 				e.RequestRestart()
 				fmt.Fprintln(out, "restart requested")
 			default:
@@ -192,7 +373,33 @@ func StartConsole(ctx context.Context, in io.Reader, out io.Writer, e *Emitter,
 	}()
 }
 
-func writeJSON(w http.ResponseWriter, v any) {
+// WriteJSON writes v as the JSON response body.
+func WriteJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(v)
 }
+
+// statusWriter remembers the response status for the audit log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps streaming responses (room export) streaming.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yngus4862/chat/internal/tracing"
 )
 
 type Conn struct {
@@ -20,6 +21,7 @@ func Connect(ctx context.Context, dsn string) (*Conn, error) {
 	cfg.MinConns = 2
 	cfg.MaxConnIdleTime = 2 * time.Minute
 	cfg.MaxConnLifetime = 30 * time.Minute
+	cfg.ConnConfig.Tracer = tracing.QueryTracer{}
 
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
@@ -0,0 +1,113 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the metadata counterpart of the REST X-Request-ID header.
+const requestIDKey = "x-request-id"
+
+// begin keeps the caller's x-request-id or assigns one and returns it in the
+// response header, starts a server span continuing the caller's traceparent,
+// and tags the context's logger with both, as the REST middleware does.
+func begin(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if v := md.Get(requestIDKey); len(v) > 0 {
+		id = strings.TrimSpace(v[0])
+	}
+	if id == "" || len(id) > 128 {
+		id = logging.NewID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, mdCarrier(md))
+	ctx, span := tracing.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemGRPC,
+			attribute.String("chat.request_id", id),
+		),
+	)
+
+	l := logging.FromContext(ctx).With("request_id", id, "rpc", method)
+	if tid := tracing.TraceID(ctx); tid != "" {
+		l = l.With("trace_id", tid)
+	}
+	return logging.WithContext(ctx, l), span
+}
+
+func end(ctx context.Context, span trace.Span, start time.Time, err error) {
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	lvl := slog.LevelInfo
+	if code == codes.Internal || code == codes.Unknown {
+		lvl = slog.LevelError
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+	span.End()
+	logging.FromContext(ctx).LogAttrs(ctx, lvl, "rpc",
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("user_id", userID(ctx)),
+	)
+}
+
+func unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, span := begin(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	end(ctx, span, start, err)
+	return resp, err
+}
+
+func streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, span := begin(ss.Context(), info.FullMethod)
+	err := handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+	end(ctx, span, start, err)
+	return err
+}
+
+// loggedStream swaps in the context carrying the request logger and span.
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context { return s.ctx }
+
+// mdCarrier reads trace context from incoming metadata.
+type mdCarrier metadata.MD
+
+func (m mdCarrier) Get(key string) string {
+	if v := metadata.MD(m).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (m mdCarrier) Set(key, value string) { metadata.MD(m).Set(key, value) }
+
+func (m mdCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,84 @@
+package grpcapi
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	chatv1 "github.com/yngus4862/chat/proto/chat/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// restRoutes maps each RPC onto the REST route it mirrors, so RATE_LIMIT_ROUTES
+// overrides apply to both and a caller draws on one bucket whichever API it
+// uses. StreamRoom is charged once per stream, like opening the SSE route.
+var restRoutes = map[string][2]string{
+	chatv1.ChatService_CreateRoom_FullMethodName:   {"POST", "/v1/rooms"},
+	chatv1.ChatService_ListRooms_FullMethodName:    {"GET", "/v1/rooms"},
+	chatv1.ChatService_PostMessage_FullMethodName:  {"POST", "/v1/rooms/:roomId/messages"},
+	chatv1.ChatService_ListMessages_FullMethodName: {"GET", "/v1/rooms/:roomId/messages"},
+	chatv1.ChatService_StreamRoom_FullMethodName:   {"GET", "/v1/rooms/:roomId/events"},
+}
+
+// rateLimitUnary applies the REST budgets to unary calls, keyed by the
+// caller's x-user-id or peer address. Limiter errors fail open.
+func rateLimitUnary(l ratelimit.Limiter, budgets ratelimit.Budgets) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := allow(ctx, l, budgets, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// rateLimitStream admits streams against the same budgets; the stream itself
+// is then bounded by hub admission, not by tokens.
+func rateLimitStream(l ratelimit.Limiter, budgets ratelimit.Budgets) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := allow(ss.Context(), l, budgets, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func allow(ctx context.Context, l ratelimit.Limiter, budgets ratelimit.Budgets, method string) error {
+	route, ok := restRoutes[method]
+	if !ok {
+		return nil
+	}
+	b := budgets.For(route[0], route[1])
+	if !b.Enabled() {
+		return nil
+	}
+	key := route[0] + " " + route[1] + "|" + ratelimit.Subject(userID(ctx), peerIP(ctx))
+	ok, wait, err := l.Allow(ctx, key, b)
+	if err != nil {
+		logging.FromContext(ctx).Warn("rate limiter failed, allowing", logging.Err(err))
+	}
+	if !ok {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+		return toStatus(ctx, apperr.New(apperr.CodeRateLimited, "rate limited"))
+	}
+	return nil
+}
+
+// peerIP is the connecting address without its port. gRPC has no forwarding
+// header convention, so proxies in front of it should pass x-user-id instead.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
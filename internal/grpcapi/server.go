@@ -0,0 +1,369 @@
+// Package grpcapi serves the chat.v1 gRPC API. It mirrors the REST handlers
+// in internal/api: same store, same validation, same hub fan-out.
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/latency"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/validate"
+	"github.com/yngus4862/chat/internal/ws"
+	chatv1 "github.com/yngus4862/chat/proto/chat/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errInvalidRoomID = apperr.Validation("roomId", "invalid roomId")
+	errShuttingDown  = apperr.New(apperr.CodeUnavailable, "server shutting down")
+)
+
+// maxReplay bounds the StreamRoom catch-up, as for WS sinceSeq.
+const maxReplay = 1000
+
+type Server struct {
+	chatv1.UnimplementedChatServiceServer
+
+	Store     store.Store
+	Hub       *ws.Hub
+	Audit     *audit.Recorder
+	Moderator *moderation.Moderator
+}
+
+// NewServer returns a grpc.Server with the chat service registered. limiter
+// may be nil to disable rate limiting, and tlsCfg nil for a plaintext listener.
+func NewServer(st store.Store, hub *ws.Hub, rec *audit.Recorder, mod *moderation.Moderator, limiter ratelimit.Limiter, budgets ratelimit.Budgets, tlsCfg *tls.Config) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{unaryInterceptor}
+	stream := []grpc.StreamServerInterceptor{streamInterceptor}
+	if limiter != nil {
+		unary = append(unary, rateLimitUnary(limiter, budgets))
+		stream = append(stream, rateLimitStream(limiter, budgets))
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	g := grpc.NewServer(opts...)
+	chatv1.RegisterChatServiceServer(g, &Server{Store: st, Hub: hub, Audit: rec, Moderator: mod})
+	return g
+}
+
+func (s *Server) CreateRoom(ctx context.Context, req *chatv1.CreateRoomRequest) (*chatv1.Room, error) {
+	name, err := validate.RoomName(req.GetName())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	r, err := s.Store.CreateRoom(ctx, name, userID(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	e := store.AuditEvent{
+		Actor:      userID(ctx),
+		Action:     audit.ActionRoomCreate,
+		TargetType: audit.TargetRoom,
+		TargetID:   strconv.FormatInt(r.ID, 10),
+		Details:    audit.Details(map[string]string{"name": r.Name}),
+		RemoteAddr: peerIP(ctx),
+	}
+	s.Audit.Record(ctx, e)
+	return toRoom(r), nil
+}
+
+func (s *Server) ListRooms(ctx context.Context, req *chatv1.ListRoomsRequest) (*chatv1.ListRoomsResponse, error) {
+	sort, err := validate.RoomSort(req.GetSort())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	rooms, next, err := s.Store.ListRooms(ctx, store.ListRoomsParams{
+		Sort:   sort,
+		Cursor: req.GetCursor(),
+		Limit:  validate.Limit(int(req.GetLimit())),
+	})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	resp := &chatv1.ListRoomsResponse{NextCursor: next, HasMore: next != ""}
+	for _, r := range rooms {
+		resp.Items = append(resp.Items, toRoom(r))
+	}
+	return resp, nil
+}
+
+func (s *Server) PostMessage(ctx context.Context, req *chatv1.PostMessageRequest) (*chatv1.Message, error) {
+	ctx = latency.WithReceived(ctx, time.Now())
+	if req.GetRoomId() <= 0 {
+		return nil, toStatus(ctx, errInvalidRoomID)
+	}
+	content, err := validate.Content(req.GetContent())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	clientMsgID, err := validate.ClientMsgID(req.GetClientMsgId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	verdict, err := s.Moderator.Check(ctx, content)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	author := userID(ctx)
+	msg, err := s.Store.CreateMessage(ctx, req.GetRoomId(), author, verdict.Content, "grpc", clientMsgID)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	s.Moderator.Review(ctx, msg, author, verdict)
+	if s.Hub != nil {
+		s.Hub.BroadcastMessage(ctx, msg)
+	}
+	return toMessage(msg), nil
+}
+
+func (s *Server) ListMessages(ctx context.Context, req *chatv1.ListMessagesRequest) (*chatv1.ListMessagesResponse, error) {
+	if req.GetRoomId() <= 0 {
+		return nil, toStatus(ctx, errInvalidRoomID)
+	}
+	q := store.MessageQuery{
+		Before: req.GetBefore(),
+		After:  req.GetAfter(),
+		Around: req.GetAround(),
+		Limit:  validate.Limit(int(req.GetLimit())),
+	}
+	if err := validate.MessageQuery(q); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	page, err := s.Store.ListMessages(ctx, req.GetRoomId(), q)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	resp := &chatv1.ListMessagesResponse{
+		PrevCursor: page.PrevCursor,
+		NextCursor: page.NextCursor,
+		HasMore:    page.NextCursor > 0,
+	}
+	for _, m := range page.Items {
+		resp.Items = append(resp.Items, toMessage(m))
+	}
+	return resp, nil
+}
+
+// StreamRoom attaches to the hub like a WebSocket connection and forwards the
+// room's frames until the client goes away.
+func (s *Server) StreamRoom(req *chatv1.StreamRoomRequest, stream grpc.ServerStreamingServer[chatv1.RoomEvent]) error {
+	ctx := stream.Context()
+	roomID := req.GetRoomId()
+	if roomID <= 0 {
+		return toStatus(ctx, errInvalidRoomID)
+	}
+	if req.SinceSeq != nil && req.GetSinceSeq() < 0 {
+		return toStatus(ctx, apperr.Validation("sinceSeq", "invalid sinceSeq"))
+	}
+	exists, err := s.Store.RoomExists(ctx, roomID)
+	if err != nil {
+		return toStatus(ctx, err)
+	}
+	if !exists {
+		return toStatus(ctx, store.ErrRoomNotFound)
+	}
+	if s.Hub == nil {
+		return toStatus(ctx, apperr.New(apperr.CodeUnavailable, "realtime unavailable"))
+	}
+	sub, err := s.Hub.Subscribe(ctx, roomID, ws.SubscribeOptions{UserID: userID(ctx), IP: peerIP(ctx), Personal: true, Replay: req.SinceSeq != nil})
+	if err != nil {
+		return toStatus(ctx, err)
+	}
+	defer sub.Close()
+	// Flush the headers now so clients can tell the subscription is live.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	// Subscribed before replaying so nothing published meanwhile is missed.
+	// Those frames are held until the replay is sent; live messages the
+	// replay already covered are skipped.
+	var replayed int64
+	send := func(f ws.Frame) error {
+		ev, seq, ok := decodeFrame(f.Data)
+		if !ok || (ev.GetMessage() != nil && seq <= replayed) {
+			return nil
+		}
+		start := time.Now()
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+		f.Written(start)
+		return nil
+	}
+	if req.SinceSeq != nil {
+		if replayed, err = s.replay(stream, sub, roomID, req.GetSinceSeq()); err != nil {
+			return err
+		}
+		for _, f := range sub.EndReplay(replayed) {
+			if err := send(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.Done():
+			return toStatus(ctx, errShuttingDown)
+		case f := <-sub.Frames():
+			if err := send(f); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) replay(stream grpc.ServerStreamingServer[chatv1.RoomEvent], sub *ws.Subscriber, roomID, afterSeq int64) (int64, error) {
+	ctx := stream.Context()
+	for sent := 0; sent < maxReplay; {
+		msgs, more, err := s.Store.MessagesAfter(ctx, roomID, afterSeq, 200)
+		if err != nil {
+			return afterSeq, toStatus(ctx, err)
+		}
+		for _, m := range msgs {
+			if sub.Hides(m) {
+				afterSeq = m.Seq
+				continue
+			}
+			ev := &chatv1.RoomEvent{Payload: &chatv1.RoomEvent_Message{Message: toMessage(m)}}
+			if err := stream.Send(ev); err != nil {
+				return afterSeq, err
+			}
+			afterSeq = m.Seq
+		}
+		sent += len(msgs)
+		if !more {
+			break
+		}
+	}
+	return afterSeq, nil
+}
+
+// decodeFrame turns a hub frame (a bare message, or an event with a type)
+// into a RoomEvent. Error frames are WebSocket-only and are dropped.
+func decodeFrame(b []byte) (*chatv1.RoomEvent, int64, bool) {
+	var head struct {
+		Type   string          `json:"type"`
+		RoomID int64           `json:"roomId"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return nil, 0, false
+	}
+	switch head.Type {
+	case "":
+		var m store.Message
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, 0, false
+		}
+		return &chatv1.RoomEvent{Payload: &chatv1.RoomEvent_Message{Message: toMessage(m)}}, m.Seq, true
+	case ws.EventError:
+		return nil, 0, false
+	default:
+		ev := &chatv1.Event{Type: head.Type, RoomId: head.RoomID, DataJson: string(head.Data)}
+		return &chatv1.RoomEvent{Payload: &chatv1.RoomEvent_Event{Event: ev}}, 0, true
+	}
+}
+
+// grpcCodes maps the shared error codes onto gRPC status codes.
+var grpcCodes = map[apperr.Code]codes.Code{
+	apperr.CodeValidation:      codes.InvalidArgument,
+	apperr.CodeUnauthenticated: codes.Unauthenticated,
+	apperr.CodeForbidden:       codes.PermissionDenied,
+	apperr.CodeNotFound:        codes.NotFound,
+	apperr.CodeRoomNotFound:    codes.NotFound,
+	apperr.CodeMessageNotFound: codes.NotFound,
+	apperr.CodeConflict:        codes.AlreadyExists,
+	apperr.CodeRateLimited:     codes.ResourceExhausted,
+	apperr.CodeNotJoined:       codes.FailedPrecondition,
+	apperr.CodeRejected:        codes.FailedPrecondition,
+	apperr.CodeUnavailable:     codes.Unavailable,
+	apperr.CodeCanceled:        codes.Canceled,
+}
+
+// toStatus converts err to a gRPC status carrying the apperr code as an
+// ErrorInfo reason, so gRPC clients can switch on the same codes as REST.
+// Internal errors are logged with their cause under the call's logger.
+func toStatus(ctx context.Context, err error) error {
+	e := apperr.From(err)
+	c, ok := grpcCodes[e.Code]
+	if !ok {
+		c = codes.Internal
+		logging.FromContext(ctx).Error("rpc failed", logging.Err(err))
+	}
+	st := status.New(c, e.Message)
+	info := &errdetails.ErrorInfo{Reason: string(e.Code), Domain: "chat"}
+	if e.Field != "" {
+		info.Metadata = map[string]string{"field": e.Field}
+	}
+	if withInfo, derr := st.WithDetails(info); derr == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+func userID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(auth.MetadataKey); len(v) > 0 {
+		return auth.Normalize(v[0])
+	}
+	return ""
+}
+
+func toRoom(r store.Room) *chatv1.Room {
+	out := &chatv1.Room{
+		Id:        r.ID,
+		Name:      r.Name,
+		CreatedAt: timestamppb.New(r.CreatedAt),
+		LastSeq:   r.LastSeq,
+	}
+	if p := r.LastMessage; p != nil {
+		out.LastMessage = &chatv1.MessagePreview{
+			Id:        p.ID,
+			Content:   p.Content,
+			Source:    p.Source,
+			CreatedAt: timestamppb.New(p.CreatedAt),
+		}
+	}
+	return out
+}
+
+func toMessage(m store.Message) *chatv1.Message {
+	return &chatv1.Message{
+		Id:          m.ID,
+		RoomId:      m.RoomID,
+		Seq:         m.Seq,
+		Content:     m.Content,
+		Source:      m.Source,
+		ClientMsgId: m.ClientMsgID,
+		CreatedAt:   timestamppb.New(m.CreatedAt),
+		Author:      m.Author,
+	}
+}
@@ -15,7 +15,7 @@ type Result struct {
 	Error  string `json:"error,omitempty"`
 }
 
-func Ready(parent context.Context, st *store.Store, ps *ws.RedisPubSub) Result {
+func Ready(parent context.Context, st store.Store, ps *ws.RedisPubSub) Result {
 	ctx, cancel := context.WithTimeout(parent, 2*time.Second)
 	defer cancel()
 
@@ -0,0 +1,148 @@
+// Package latency measures how long a message takes from the moment chatd
+// receives it to the moment it is written to subscribers' sockets, stage by
+// stage. Samples go to Prometheus histograms and to a sliding window the admin
+// API summarizes at /admin/latency.
+package latency
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yngus4862/chat/internal/metrics"
+)
+
+// Stage names one leg of a message's trip.
+type Stage string
+
+const (
+	// Persist is receive → stored in Postgres.
+	Persist Stage = "persist"
+	// Publish is the Redis PUBLISH call.
+	Publish Stage = "publish"
+	// Broker is publish → received back from Redis on any instance. Across
+	// instances it includes their clock difference.
+	Broker Stage = "broker"
+	// Write is one socket (or SSE/gRPC stream) write.
+	Write Stage = "write"
+	// Deliver is receive → written to a subscriber: the server-side end to end.
+	Deliver Stage = "deliver"
+	// Client is receive → the client's ack timestamp. It includes the client's
+	// clock offset, so read it as a trend rather than an absolute.
+	Client Stage = "client"
+)
+
+// Stages lists every stage in trip order.
+var Stages = []Stage{Persist, Publish, Broker, Write, Deliver, Client}
+
+// window is how many recent samples per stage the summary covers.
+const window = 2048
+
+type ring struct {
+	mu      sync.Mutex
+	samples [window]time.Duration
+	next    int
+	filled  bool
+	count   uint64
+}
+
+var rings = func() map[Stage]*ring {
+	m := make(map[Stage]*ring, len(Stages))
+	for _, s := range Stages {
+		m[s] = &ring{}
+	}
+	return m
+}()
+
+// Observe records one sample. Negative durations (clock skew) are dropped.
+func Observe(stage Stage, d time.Duration) {
+	r, ok := rings[stage]
+	if !ok || d < 0 {
+		return
+	}
+	metrics.ObserveDelivery(string(stage), d)
+	r.mu.Lock()
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % window
+	if r.next == 0 {
+		r.filled = true
+	}
+	r.count++
+	r.mu.Unlock()
+}
+
+// Since records the time elapsed since start; a zero start is ignored.
+func Since(stage Stage, start time.Time) {
+	if !start.IsZero() {
+		Observe(stage, time.Since(start))
+	}
+}
+
+// Summary describes the recent samples of one stage in milliseconds.
+type Summary struct {
+	Count  uint64  `json:"count"`
+	Window int     `json:"window"`
+	P50    float64 `json:"p50Ms"`
+	P95    float64 `json:"p95Ms"`
+	P99    float64 `json:"p99Ms"`
+	Max    float64 `json:"maxMs"`
+}
+
+// Snapshot summarizes every stage that has samples.
+func Snapshot() map[Stage]Summary {
+	out := make(map[Stage]Summary, len(Stages))
+	for _, s := range Stages {
+		r := rings[s]
+		r.mu.Lock()
+		n := r.next
+		if r.filled {
+			n = window
+		}
+		vals := append([]time.Duration(nil), r.samples[:n]...)
+		count := r.count
+		r.mu.Unlock()
+		if n == 0 {
+			continue
+		}
+		sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+		out[s] = Summary{
+			Count:  count,
+			Window: n,
+			P50:    ms(quantile(vals, 0.50)),
+			P95:    ms(quantile(vals, 0.95)),
+			P99:    ms(quantile(vals, 0.99)),
+			Max:    ms(vals[n-1]),
+		}
+	}
+	return out
+}
+
+// quantile picks the nearest-rank quantile of sorted vals.
+func quantile(vals []time.Duration, q float64) time.Duration {
+	i := int(q*float64(len(vals))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(vals) {
+		i = len(vals) - 1
+	}
+	return vals[i]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type ctxKey struct{}
+
+// WithReceived stamps ctx with the time a message entered chatd.
+func WithReceived(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// ReceivedAt returns the stamp set by WithReceived, or the zero time.
+func ReceivedAt(ctx context.Context) time.Time {
+	t, _ := ctx.Value(ctxKey{}).(time.Time)
+	return t
+}
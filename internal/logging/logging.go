@@ -0,0 +1,89 @@
+// Package logging configures the process-wide slog logger and carries
+// request-scoped loggers through contexts, so one request id ties a REST call
+// to the DB write, Redis publish and WS delivery it caused.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// level is shared by every handler Setup installs, so SetLevel takes effect
+// without rebuilding loggers that were already derived with With.
+var level = new(slog.LevelVar)
+
+// Setup installs the default logger writing to stderr. format is "json"
+// (default) or "text"; lvl is one of debug, info, warn, error.
+func Setup(lvl, format string) error {
+	return SetupWriter(os.Stderr, lvl, format)
+}
+
+// SetupWriter is Setup with an explicit destination.
+func SetupWriter(w io.Writer, lvl, format string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (json|text)", format)
+	}
+	// Also routes the standard log package (used by libraries) through slog.
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// Level reports the current minimum level, e.g. "INFO".
+func Level() string {
+	return level.Level().String()
+}
+
+// SetLevel changes the minimum level at runtime.
+func SetLevel(lvl string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(lvl))); err != nil {
+		return fmt.Errorf("unknown log level %q (debug|info|warn|error)", lvl)
+	}
+	level.Set(l)
+	return nil
+}
+
+type ctxKey struct{}
+
+// WithContext returns a copy of ctx carrying l.
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// NewID returns a random 128-bit hex id for requests and connections.
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Err is the attribute every error is logged under.
+func Err(err error) slog.Attr {
+	return slog.Any("err", err)
+}
@@ -0,0 +1,182 @@
+// Package metrics holds chatd's Prometheus collectors. They live on a private
+// registry served by the admin listener at /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chat"
+
+// Registry is what /metrics exposes: the collectors below plus Go runtime and
+// process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "REST requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "REST request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	messagesPersisted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_persisted_total",
+		Help:      "Messages stored, by source (rest, ws, grpc, scheduled).",
+	}, []string{"source"})
+
+	messagesBroadcast = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_broadcast_total",
+		Help:      "Messages fanned out by this instance, by source.",
+	}, []string{"source"})
+
+	droppedFrames = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_dropped_frames_total",
+		Help:      "Frames not queued because the client was too far behind.",
+	})
+
+	deliveryStages = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delivery_stage_seconds",
+		Help:      "Message delivery latency by stage (persist, publish, broker, write, deliver, client).",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms .. ~4s
+	}, []string{"stage"})
+
+	messagesPurged = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_purged_total",
+		Help:      "Messages deleted by the retention purge.",
+	})
+
+	moderated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_moderated_total",
+		Help:      "Moderation filter hits by action (reject, mask, flag) and filter.",
+	}, []string{"action", "filter"})
+
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_pubsub_errors_total",
+		Help:      "Redis pub/sub failures by operation (publish, subscribe, decode).",
+	}, []string{"op"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		messagesPersisted, messagesBroadcast, messagesPurged, moderated,
+		droppedFrames, redisErrors, deliveryStages,
+	)
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTP records one REST request. route is the matched pattern, never
+// the raw path, so label cardinality stays bounded.
+func ObserveHTTP(method, route string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+func MessagePersisted(source string) { messagesPersisted.WithLabelValues(source).Inc() }
+
+func MessageBroadcast(source string) { messagesBroadcast.WithLabelValues(source).Inc() }
+
+func MessagesPurged(n int64) { messagesPurged.Add(float64(n)) }
+
+func MessageModerated(action, filter string) { moderated.WithLabelValues(action, filter).Inc() }
+
+func FramesDropped(n int) {
+	if n > 0 {
+		droppedFrames.Add(float64(n))
+	}
+}
+
+// ObserveDelivery records one delivery stage sample; see package latency.
+func ObserveDelivery(stage string, d time.Duration) {
+	deliveryStages.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+func RedisError(op string) { redisErrors.WithLabelValues(op).Inc() }
+
+// ConnStats mirrors ws.Stats without importing the hub.
+type ConnStats struct {
+	Connections, Users, Rooms int
+}
+
+// RegisterConnStats exposes the hub's live connection counts as gauges read
+// at scrape time.
+func RegisterConnStats(fn func() ConnStats) {
+	gauge := func(name, help string, pick func(ConnStats) int) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(pick(fn())) })
+	}
+	Registry.MustRegister(
+		gauge("ws_connections", "Open WebSocket, SSE, long-poll and gRPC stream subscriptions.", func(s ConnStats) int { return s.Connections }),
+		gauge("ws_users", "Distinct users with an open subscription.", func(s ConnStats) int { return s.Users }),
+		gauge("ws_rooms", "Rooms with at least one local subscriber.", func(s ConnStats) int { return s.Rooms }),
+	)
+}
+
+// RegisterPool exposes pgxpool statistics.
+func RegisterPool(p *pgxpool.Pool) {
+	Registry.MustRegister(&poolCollector{pool: p})
+}
+
+var (
+	poolTotal    = prometheus.NewDesc(namespace+"_db_pool_conns", "Connections in the pool by state.", []string{"state"}, nil)
+	poolMax      = prometheus.NewDesc(namespace+"_db_pool_max_conns", "Maximum pool size.", nil, nil)
+	poolAcquires = prometheus.NewDesc(namespace+"_db_pool_acquires_total", "Successful connection acquires.", nil, nil)
+	poolEmpty    = prometheus.NewDesc(namespace+"_db_pool_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolCanceled = prometheus.NewDesc(namespace+"_db_pool_canceled_acquires_total", "Acquires canceled by their context.", nil, nil)
+	poolWait     = prometheus.NewDesc(namespace+"_db_pool_acquire_wait_seconds_total", "Time spent waiting for a connection.", nil, nil)
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolTotal, poolMax, poolAcquires, poolEmpty, poolCanceled, poolWait} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmpty, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceled, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
@@ -0,0 +1,350 @@
+// Package migrate applies the embedded schema migrations. It keeps its state
+// in golang-migrate's schema_migrations table (one row: version, dirty), so
+// databases set up with the migrate CLI are picked up as they are, and it
+// holds the same Postgres advisory lock as the migrate CLI while migrating, so
+// instances starting together, or alongside the CLI, apply each migration
+// once.
+//
+// Databases created before migrations were embedded (chat_rooms and messages
+// from the old EnsureSchema or migrations/001_init.sql, without
+// schema_migrations) are brought to the 0001 layout and stamped with version
+// 1 instead of having 0001 run over them.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yngus4862/chat/internal/logging"
+)
+
+// lockKey is the advisory lock key golang-migrate's postgres driver holds
+// while migrating schema_migrations in schema of database: the CRC-32 of the
+// names joined by NULs, times its salt, in 32 bits.
+func lockKey(database, schema string) int64 {
+	const salt = 1486364155
+	sum := crc32.ChecksumIEEE([]byte(schema + "\x00" + "schema_migrations" + "\x00" + database))
+	return int64(sum * salt)
+}
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads NNNN_name.up.sql / NNNN_name.down.sql pairs from fsys, sorted by
+// version. Every version needs an up file; down files are optional.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[uint64]*Migration{}
+	for _, e := range entries {
+		m := fileRe.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		v, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[v]
+		if !ok {
+			mig = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", v, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// ErrDirty means a migration run by the migrate CLI failed partway; fix the
+// schema by hand and force the version with the CLI before continuing.
+var ErrDirty = errors.New("schema is dirty: a migration failed partway")
+
+// Runner applies migrations to one database.
+type Runner struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New loads the migrations in fsys; see Load.
+func New(pool *pgxpool.Pool, fsys fs.FS) (*Runner, error) {
+	ms, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{pool: pool, migrations: ms}, nil
+}
+
+// Latest is the newest version the runner knows.
+func (r *Runner) Latest() uint64 {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+// Version reports the applied version: 0 for an empty database, or one not
+// yet migrated at all. It only reads.
+func (r *Runner) Version(ctx context.Context) (version uint64, dirty bool, err error) {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Release()
+	return current(ctx, conn.Conn())
+}
+
+// Up applies every pending migration and returns the versions it applied.
+func (r *Runner) Up(ctx context.Context) ([]uint64, error) {
+	var applied []uint64
+	err := r.locked(ctx, func(conn *pgx.Conn) error {
+		v, dirty, err := current(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("version %d: %w", v, ErrDirty)
+		}
+		for _, m := range r.migrations {
+			if m.Version <= v {
+				continue
+			}
+			if err := apply(ctx, conn, m.Up, m.Version); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m.Version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the n most recent migrations and returns the versions it
+// reverted.
+func (r *Runner) Down(ctx context.Context, n int) ([]uint64, error) {
+	var reverted []uint64
+	err := r.locked(ctx, func(conn *pgx.Conn) error {
+		v, dirty, err := current(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("version %d: %w", v, ErrDirty)
+		}
+		for i := len(r.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			m := r.migrations[i]
+			if m.Version > v {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+			}
+			var prev uint64
+			if i > 0 {
+				prev = r.migrations[i-1].Version
+			}
+			if err := apply(ctx, conn, m.Down, prev); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m.Version)
+			v = prev
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status is one row of Runner.Status.
+type Status struct {
+	Version uint64 `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// Status lists every known migration and whether it is applied.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	v, _, err := r.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, len(r.migrations))
+	for i, m := range r.migrations {
+		out[i] = Status{Version: m.Version, Name: m.Name, Applied: m.Version <= v}
+	}
+	return out, nil
+}
+
+// Check fails unless the database is at the latest version. chatd runs it on
+// startup so an instance never serves against a schema it does not expect.
+func (r *Runner) Check(ctx context.Context) error {
+	v, dirty, err := r.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("version %d: %w", v, ErrDirty)
+	}
+	if latest := r.Latest(); v < latest {
+		return fmt.Errorf("schema is at version %d, need %d: run `chatd migrate up` or set DB_MIGRATE=auto", v, latest)
+	}
+	return nil
+}
+
+// locked runs fn on one connection holding the advisory lock.
+func (r *Runner) locked(ctx context.Context, fn func(*pgx.Conn) error) error {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	c := conn.Conn()
+	var database, schema string
+	if err := c.QueryRow(ctx, `SELECT current_database(), current_schema()`).Scan(&database, &schema); err != nil {
+		return err
+	}
+	key := lockKey(database, schema)
+	if _, err := c.Exec(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		return err
+	}
+	defer func() { _, _ = c.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key) }()
+	if err := ensureTable(ctx, c); err != nil {
+		return err
+	}
+	return fn(c)
+}
+
+// ErrUnversioned means the database has chat tables but no schema_migrations
+// and is not the legacy layout, so its version cannot be told; stamp it with
+// `migrate force <version>` from the migrate CLI.
+var ErrUnversioned = errors.New("schema has tables but no schema_migrations")
+
+// legacyUpgrade brings the legacy layout to 0001: 64-bit ids, and the source
+// and client_msg_id columns, backfilled so existing rows stay unique.
+const legacyUpgrade = `
+ALTER TABLE chat_rooms ALTER COLUMN id TYPE BIGINT;
+ALTER SEQUENCE IF EXISTS chat_rooms_id_seq AS BIGINT;
+ALTER TABLE messages
+  ALTER COLUMN id TYPE BIGINT,
+  ALTER COLUMN room_id TYPE BIGINT,
+  ADD COLUMN source VARCHAR(32) NOT NULL DEFAULT 'rest',
+  ADD COLUMN client_msg_id VARCHAR(128);
+ALTER SEQUENCE IF EXISTS messages_id_seq AS BIGINT;
+UPDATE messages SET client_msg_id = 'legacy-' || id;
+ALTER TABLE messages
+  ALTER COLUMN client_msg_id SET NOT NULL,
+  ADD UNIQUE (room_id, client_msg_id);
+CREATE INDEX IF NOT EXISTS idx_messages_room_id_id_desc ON messages(room_id, id DESC);
+`
+
+// ensureTable creates schema_migrations, first stamping a legacy database
+// with version 1 (see the package doc).
+func ensureTable(ctx context.Context, c *pgx.Conn) error {
+	var versioned, rooms, clientMsgID bool
+	err := c.QueryRow(ctx, `SELECT
+		to_regclass('schema_migrations') IS NOT NULL,
+		to_regclass('chat_rooms') IS NOT NULL,
+		EXISTS (SELECT 1 FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = 'messages' AND column_name = 'client_msg_id')`,
+	).Scan(&versioned, &rooms, &clientMsgID)
+	if err != nil || versioned {
+		return err
+	}
+	const create = `CREATE TABLE schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`
+	switch {
+	case !rooms:
+		_, err = c.Exec(ctx, create)
+		return err
+	case clientMsgID:
+		return ErrUnversioned
+	}
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	for _, q := range []string{create, legacyUpgrade, `INSERT INTO schema_migrations(version, dirty) VALUES(1, false)`} {
+		if _, err := tx.Exec(ctx, q); err != nil {
+			return fmt.Errorf("stamping legacy schema: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("legacy schema stamped", "version", 1)
+	return nil
+}
+
+// current reads the applied version, treating a missing schema_migrations
+// table as version 0.
+func current(ctx context.Context, c *pgx.Conn) (uint64, bool, error) {
+	var exists bool
+	if err := c.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || !exists {
+		return 0, false, err
+	}
+	var (
+		v     int64
+		dirty bool
+	)
+	err := c.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return uint64(v), dirty, err
+}
+
+// apply runs one migration file and records the resulting version in the same
+// transaction, so a failing migration leaves the schema at the previous
+// version rather than half applied.
+func apply(ctx context.Context, c *pgx.Conn, sql string, to uint64) error {
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if to > 0 {
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations(version, dirty) VALUES($1, false)`, int64(to)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
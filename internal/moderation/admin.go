@@ -0,0 +1,96 @@
+package moderation
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/control"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// Routes returns the admin endpoints of the review queue:
+//
+//	GET  /admin/moderation/reviews              ?status=pending|approved|rejected|all&cursor=&limit=
+//	POST /admin/moderation/reviews/{reviewId}   {"status":"approved"|"rejected"}
+//
+// The list defaults to pending reviews, newest first.
+func (m *Moderator) Routes() []control.Route {
+	return []control.Route{
+		{Pattern: "GET /admin/moderation/reviews", Handler: m.serveList},
+		{Pattern: "POST /admin/moderation/reviews/{reviewId}", Handler: m.serveResolve, Action: audit.ActionReviewResolve},
+	}
+}
+
+func (m *Moderator) serveList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "":
+		status = store.ReviewPending
+	case "all":
+		status = ""
+	case store.ReviewPending, store.ReviewApproved, store.ReviewRejected:
+	default:
+		http.Error(w, "status must be pending, approved, rejected or all", http.StatusBadRequest)
+		return
+	}
+	var before int64
+	if v := q.Get("cursor"); v != "" {
+		c, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || c <= 0 {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		before = c
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	items, next, err := m.queue.ListReviews(r.Context(), status, before, limit)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.WriteJSON(w, map[string]any{"items": items, "nextCursor": next})
+}
+
+func (m *Moderator) serveResolve(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("reviewId"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid reviewId", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if body.Status != store.ReviewApproved && body.Status != store.ReviewRejected {
+		http.Error(w, "status must be approved or rejected", http.StatusBadRequest)
+		return
+	}
+	control.AuditDetail(r, "reviewId", id)
+	control.AuditDetail(r, "status", body.Status)
+	rev, err := m.queue.ResolveReview(r.Context(), id, body.Status, audit.ActorAdmin)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.AuditDetail(r, "messageId", rev.MessageID)
+	slog.Info("moderation review resolved", "review_id", id, "message_id", rev.MessageID, "status", rev.Status)
+	control.WriteJSON(w, rev)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	e := apperr.From(err)
+	status := e.Code.HTTPStatus()
+	if status >= http.StatusInternalServerError {
+		slog.Error("moderation admin request failed", logging.Err(err))
+	}
+	http.Error(w, e.Message, status)
+}
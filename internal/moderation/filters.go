@@ -0,0 +1,166 @@
+package moderation
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pattern hits wherever Re matches. When Re has a capturing group, the first
+// group is the hit and the rest of the match only context, since RE2 has no
+// lookaround.
+type Pattern struct {
+	Name   string
+	Re     *regexp.Regexp
+	Action Action
+}
+
+func (p *Pattern) Check(content string) *Hit {
+	var spans [][2]int
+	for _, m := range p.Re.FindAllStringSubmatchIndex(content, -1) {
+		if len(m) >= 4 && m[2] >= 0 {
+			spans = append(spans, [2]int{m[2], m[3]})
+		} else {
+			spans = append(spans, [2]int{m[0], m[1]})
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	return &Hit{Filter: p.Name, Action: p.Action, Spans: spans}
+}
+
+// Words hits on any of words, case-insensitively and also inside longer
+// words: Korean attaches particles to the word they follow, so there is no
+// word boundary to anchor on. It returns nil when words is empty.
+func Words(action Action, words ...string) Filter {
+	var alts []string
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			alts = append(alts, regexp.QuoteMeta(w))
+		}
+	}
+	if len(alts) == 0 {
+		return nil
+	}
+	// longest first, so a word is not masked only up to a shorter one
+	sort.Slice(alts, func(i, j int) bool { return len(alts[i]) > len(alts[j]) })
+	return &Pattern{Name: "words", Re: regexp.MustCompile(`(?i)` + strings.Join(alts, "|")), Action: action}
+}
+
+// secretPatterns are credentials that are never meant to be pasted in chat.
+var secretPatterns = []struct{ name, re string }{
+	{"aws_access_key", `\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`},
+	{"github_token", `\b(gh[pousr]_[A-Za-z0-9]{36,255})\b`},
+	{"slack_token", `\b(xox[abposr]-[A-Za-z0-9-]{10,})`},
+	{"google_api_key", `\b(AIza[0-9A-Za-z_-]{35})`},
+	{"jwt", `\b(eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,})`},
+	{"private_key", `(?s)(-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----.*?(?:-----END (?:[A-Z]+ )?PRIVATE KEY-----|$))`},
+}
+
+// Secrets returns the detectors for common API keys and tokens, private
+// keys and Korean resident registration numbers.
+func Secrets(action Action) []Filter {
+	out := make([]Filter, 0, len(secretPatterns)+1)
+	for _, p := range secretPatterns {
+		out = append(out, &Pattern{Name: "secret:" + p.name, Re: regexp.MustCompile(p.re), Action: action})
+	}
+	return append(out, &residentNumber{action: action})
+}
+
+// rrnCandidate is the shape of a resident registration number: birth date
+// YYMMDD, an optional dash, then seven digits.
+var rrnCandidate = regexp.MustCompile(`[0-9]{6}-?[0-9]{7}`)
+
+// residentNumber finds Korean resident registration numbers. Candidates
+// must stand alone (no digit on either side), carry a valid month and day
+// and a gender digit of 1-8. Numbers issued since October 2020 have no
+// checksum, so none is verified.
+type residentNumber struct {
+	action Action
+}
+
+func (r *residentNumber) Check(content string) *Hit {
+	var spans [][2]int
+	for _, m := range rrnCandidate.FindAllStringIndex(content, -1) {
+		start, end := m[0], m[1]
+		if start > 0 && isDigit(content[start-1]) || end < len(content) && isDigit(content[end]) {
+			continue
+		}
+		n := strings.Replace(content[start:end], "-", "", 1)
+		month := int(n[2]-'0')*10 + int(n[3]-'0')
+		day := int(n[4]-'0')*10 + int(n[5]-'0')
+		if month < 1 || month > 12 || day < 1 || day > 31 || n[6] < '1' || n[6] > '8' {
+			continue
+		}
+		spans = append(spans, [2]int{start, end})
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	return &Hit{Filter: "pii:resident_number", Action: r.action, Spans: spans}
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// Length hits on oversized pastes: more than MaxRunes characters or MaxLines
+// lines; a zero limit is not checked. There is nothing to mask, so Action
+// must be Reject or Flag.
+type Length struct {
+	MaxRunes int
+	MaxLines int
+	Action   Action
+}
+
+func (l *Length) Check(content string) *Hit {
+	if l.MaxRunes > 0 && utf8.RuneCountInString(content) > l.MaxRunes ||
+		l.MaxLines > 0 && strings.Count(content, "\n")+1 > l.MaxLines {
+		return &Hit{Filter: "length", Action: l.Action}
+	}
+	return nil
+}
+
+// Options configures the built-in chain (MODERATION_* settings).
+type Options struct {
+	Words       []string
+	WordsAction string
+	// SecretsAction applies to Secrets; "off" disables them.
+	SecretsAction string
+	MaxRunes      int
+	MaxLines      int
+	LengthAction  string
+}
+
+// Filters builds the built-in chain: length, then secrets, then words.
+func Filters(o Options) ([]Filter, error) {
+	var out []Filter
+	if o.MaxRunes > 0 || o.MaxLines > 0 {
+		a, err := ParseAction(o.LengthAction)
+		if err != nil {
+			return nil, err
+		}
+		if a == Mask {
+			return nil, errors.New("length rules cannot mask (reject|flag)")
+		}
+		out = append(out, &Length{MaxRunes: o.MaxRunes, MaxLines: o.MaxLines, Action: a})
+	}
+	if o.SecretsAction != "off" {
+		a, err := ParseAction(o.SecretsAction)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Secrets(a)...)
+	}
+	if len(o.Words) > 0 {
+		a, err := ParseAction(o.WordsAction)
+		if err != nil {
+			return nil, err
+		}
+		if w := Words(a, o.Words...); w != nil {
+			out = append(out, w)
+		}
+	}
+	return out, nil
+}
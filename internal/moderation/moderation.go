@@ -0,0 +1,143 @@
+// Package moderation checks message content before it is stored. A Moderator
+// runs a chain of filters; every hit carries one of three actions:
+//
+//   - reject: the message is refused with a content_rejected error
+//   - mask:   the matched text is replaced with '*' and the message stored
+//   - flag:   the message is stored and queued for admin review
+//
+// A single reject refuses the message whatever else matched; masks and flags
+// combine. Every entry point (REST, WebSocket, gRPC, scheduled messages)
+// calls Check before CreateMessage and Review after it.
+//
+// Admins also work through the reports users file against messages
+// (ReportRoutes).
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+type Action string
+
+const (
+	Reject Action = "reject"
+	Mask   Action = "mask"
+	Flag   Action = "flag"
+)
+
+// ParseAction accepts "reject", "mask" or "flag".
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(strings.TrimSpace(s))); a {
+	case Reject, Mask, Flag:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown moderation action %q (reject|mask|flag)", s)
+}
+
+// Hit is what a filter found. Spans are the byte ranges [start, end) of the
+// content a mask replaces.
+type Hit struct {
+	Filter string
+	Action Action
+	Spans  [][2]int
+}
+
+// Filter is one check of the chain; Check returns nil when content passes.
+type Filter interface {
+	Check(content string) *Hit
+}
+
+type Moderator struct {
+	filters []Filter
+	queue   store.Moderation
+}
+
+// New returns a Moderator running filters in order and queueing flagged
+// messages in queue.
+func New(queue store.Moderation, filters ...Filter) *Moderator {
+	return &Moderator{filters: filters, queue: queue}
+}
+
+// Verdict is the outcome for content that may be stored.
+type Verdict struct {
+	// Content is what to store: the original with mask hits replaced.
+	Content string
+	// Flags names the filters that flagged the message for review.
+	Flags []string
+}
+
+// Check runs the filters over content. A nil Moderator passes everything.
+func (m *Moderator) Check(ctx context.Context, content string) (Verdict, error) {
+	v := Verdict{Content: content}
+	if m == nil {
+		return v, nil
+	}
+	var masks [][2]int
+	for _, f := range m.filters {
+		hit := f.Check(content)
+		if hit == nil {
+			continue
+		}
+		metrics.MessageModerated(string(hit.Action), hit.Filter)
+		switch hit.Action {
+		case Reject:
+			// the content itself stays out of the logs
+			logging.FromContext(ctx).Info("message rejected by moderation", "filter", hit.Filter)
+			return Verdict{}, apperr.New(apperr.CodeRejected, "message rejected by moderation ("+hit.Filter+")")
+		case Mask:
+			masks = append(masks, hit.Spans...)
+		case Flag:
+			v.Flags = append(v.Flags, hit.Filter)
+		}
+	}
+	if len(masks) > 0 {
+		v.Content = mask(content, masks)
+	}
+	return v, nil
+}
+
+// Review queues msg for review when v flagged it. The message is already
+// stored, so a failure is only logged.
+func (m *Moderator) Review(ctx context.Context, msg store.Message, userID string, v Verdict) {
+	if m == nil || len(v.Flags) == 0 {
+		return
+	}
+	_, err := m.queue.FlagMessage(context.WithoutCancel(ctx), store.Review{
+		MessageID: msg.ID,
+		RoomID:    msg.RoomID,
+		UserID:    userID,
+		Reasons:   v.Flags,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("moderation flag failed", "message_id", msg.ID, logging.Err(err))
+		return
+	}
+	logging.FromContext(ctx).Info("message flagged for review", "message_id", msg.ID, "filters", v.Flags)
+}
+
+// mask replaces every rune that starts inside one of spans with '*'.
+func mask(s string, spans [][2]int) string {
+	hidden := make([]bool, len(s))
+	for _, sp := range spans {
+		for i := max(sp[0], 0); i < min(sp[1], len(s)); i++ {
+			hidden[i] = true
+		}
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i, r := range s {
+		if hidden[i] {
+			b.WriteByte('*')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
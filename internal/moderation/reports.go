@@ -0,0 +1,90 @@
+package moderation
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/control"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// ReportRoutes returns the admin endpoints of user reports:
+//
+//	GET  /admin/reports              ?status=open|resolved|dismissed|all&cursor=&limit=
+//	POST /admin/reports/{reportId}   {"status":"resolved"|"dismissed"}
+//
+// The list defaults to open reports, newest first.
+func ReportRoutes(st store.Reports) []control.Route {
+	rs := &reports{st: st}
+	return []control.Route{
+		{Pattern: "GET /admin/reports", Handler: rs.serveList},
+		{Pattern: "POST /admin/reports/{reportId}", Handler: rs.serveResolve, Action: audit.ActionReportResolve},
+	}
+}
+
+type reports struct {
+	st store.Reports
+}
+
+func (rs *reports) serveList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "":
+		status = store.ReportOpen
+	case "all":
+		status = ""
+	case store.ReportOpen, store.ReportResolved, store.ReportDismissed:
+	default:
+		http.Error(w, "status must be open, resolved, dismissed or all", http.StatusBadRequest)
+		return
+	}
+	var before int64
+	if v := q.Get("cursor"); v != "" {
+		c, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || c <= 0 {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		before = c
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	items, next, err := rs.st.ListReports(r.Context(), status, before, limit)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.WriteJSON(w, map[string]any{"items": items, "nextCursor": next})
+}
+
+func (rs *reports) serveResolve(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("reportId"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid reportId", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if body.Status != store.ReportResolved && body.Status != store.ReportDismissed {
+		http.Error(w, "status must be resolved or dismissed", http.StatusBadRequest)
+		return
+	}
+	control.AuditDetail(r, "reportId", id)
+	control.AuditDetail(r, "status", body.Status)
+	rep, err := rs.st.ResolveReport(r.Context(), id, body.Status, audit.ActorAdmin)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.AuditDetail(r, "messageId", rep.MessageID)
+	slog.Info("report closed", "report_id", id, "message_id", rep.MessageID, "status", rep.Status)
+	control.WriteJSON(w, rep)
+}
@@ -0,0 +1,36 @@
+package push
+
+import (
+	"context"
+
+	"github.com/yngus4862/chat/internal/logging"
+)
+
+// Notification is what a user sees on a device while the app is offline.
+type Notification struct {
+	Title string         `json:"title"`
+	Body  string         `json:"body"`
+	Data  map[string]any `json:"data,omitempty"`
+}
+
+// Notifier hands notifications to the platform push providers (FCM/APNs).
+type Notifier interface {
+	Notify(ctx context.Context, userID string, n Notification) error
+}
+
+// LogNotifier only logs that a notification would have been sent. Push is
+// stubbed: it is the only Notifier until device tokens and provider
+// credentials are wired up. The title and body are user content, so only the
+// IDs from Data and the body length are logged.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, userID string, n Notification) error {
+	args := []any{"user_id", userID, "body_len", len([]rune(n.Body))}
+	for _, k := range []string{"type", "roomId", "messageId"} {
+		if v, ok := n.Data[k]; ok {
+			args = append(args, k, v)
+		}
+	}
+	logging.FromContext(ctx).Info("push notification (not delivered)", args...)
+	return nil
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Memory keeps buckets in process. Each chatd instance enforces its own budget.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Duration // time to refill from empty, used to expire idle buckets
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// NewMemoryWithClock is NewMemory with an injectable clock for tests.
+func NewMemoryWithClock(now func() time.Time) *Memory {
+	m := NewMemory()
+	m.now = now
+	return m
+}
+
+func (m *Memory) Allow(_ context.Context, key string, b Budget) (bool, time.Duration, error) {
+	if !b.Enabled() {
+		return true, 0, nil
+	}
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+	bk, ok := m.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(b.Burst), last: now}
+		m.buckets[key] = bk
+	}
+	bk.full = time.Duration(float64(b.Burst) / b.Rate * float64(time.Second))
+	if elapsed := now.Sub(bk.last); elapsed > 0 {
+		bk.tokens = math.Min(float64(b.Burst), bk.tokens+elapsed.Seconds()*b.Rate)
+		bk.last = now
+	}
+	if bk.tokens >= 1 {
+		bk.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - bk.tokens) / b.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep drops buckets idle long enough to have refilled; they would start
+// full anyway. Runs at most once a minute.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for k, bk := range m.buckets {
+		if now.Sub(bk.last) > bk.full {
+			delete(m.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Budget is a token bucket: Rate tokens are added per second up to Burst.
+type Budget struct {
+	Rate  float64
+	Burst int
+}
+
+func (b Budget) Enabled() bool { return b.Rate > 0 && b.Burst > 0 }
+
+// Limiter takes one token for key from a bucket sized by b. When the bucket is
+// empty it returns false and how long until the next token is available.
+type Limiter interface {
+	Allow(ctx context.Context, key string, b Budget) (bool, time.Duration, error)
+}
+
+// ParseBudget parses "rate:burst", e.g. "5:10" or "0.5:3". An empty string or
+// "off" disables limiting.
+func ParseBudget(s string) (Budget, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Budget{}, nil
+	}
+	rate, burst, ok := strings.Cut(s, ":")
+	if !ok {
+		return Budget{}, fmt.Errorf("budget %q: want rate:burst", s)
+	}
+	r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil || r < 0 {
+		return Budget{}, fmt.Errorf("budget %q: invalid rate", s)
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(burst))
+	if err != nil || b < 0 {
+		return Budget{}, fmt.Errorf("budget %q: invalid burst", s)
+	}
+	return Budget{Rate: r, Burst: b}, nil
+}
+
+// Budgets maps routes ("METHOD /path" as registered on the router) to budgets,
+// falling back to Default.
+type Budgets struct {
+	Default Budget
+	Routes  map[string]Budget
+}
+
+// ParseRoutes parses a comma separated list of "METHOD /path=rate:burst".
+func ParseRoutes(s string) (map[string]Budget, error) {
+	out := make(map[string]Budget)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		route, budget, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("route budget %q: want METHOD /path=rate:burst", item)
+		}
+		b, err := ParseBudget(budget)
+		if err != nil {
+			return nil, err
+		}
+		out[strings.Join(strings.Fields(route), " ")] = b
+	}
+	return out, nil
+}
+
+func (b Budgets) For(method, route string) Budget {
+	if rb, ok := b.Routes[method+" "+route]; ok {
+		return rb
+	}
+	return b.Default
+}
+
+// Subject identifies who is limited: the user when known, else the client IP.
+func Subject(userID, ip string) string {
+	if userID != "" {
+		return "u:" + userID
+	}
+	return "ip:" + ip
+}
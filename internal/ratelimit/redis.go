@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucket refills and takes a token atomically. It reads the clock from
+// Redis TIME so instances with skewed clocks still agree on a bucket. The
+// bucket hash expires once it would be full again, so idle keys cost nothing.
+var tokenBucket = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+// Redis shares buckets between chatd instances so a budget holds cluster-wide.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client, prefix: "ratelimit:"}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, b Budget) (bool, time.Duration, error) {
+	if !b.Enabled() {
+		return true, 0, nil
+	}
+	res, err := tokenBucket.Run(ctx, r.client, []string{r.prefix + key},
+		b.Rate, b.Burst).Int64Slice()
+	if err != nil {
+		return true, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func (r *Redis) Close() error { return r.client.Close() }
@@ -0,0 +1,149 @@
+package retention
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/control"
+	"github.com/yngus4862/chat/internal/logging"
+)
+
+// maxDays caps a room's retention at 100 years.
+const maxDays = 36500
+
+// Routes returns the admin endpoints of the purger:
+//
+//	GET  /admin/retention/report          dry run
+//	POST /admin/retention/purge           purge now
+//	GET  /admin/retention/purges          audit trail (?roomId=&limit=)
+//	GET  /admin/retention/rooms/{roomId}  room policy
+//	PUT  /admin/retention/rooms/{roomId}  {"days":30|null,"legalHold":true}
+func (p *Purger) Routes() []control.Route {
+	return []control.Route{
+		{Pattern: "GET /admin/retention/report", Handler: p.serveReport},
+		{Pattern: "POST /admin/retention/purge", Handler: p.servePurge, Action: audit.ActionRetentionPurge},
+		{Pattern: "GET /admin/retention/purges", Handler: p.servePurges},
+		{Pattern: "GET /admin/retention/rooms/{roomId}", Handler: p.serveRoom},
+		{Pattern: "PUT /admin/retention/rooms/{roomId}", Handler: p.serveSetRoom, Action: audit.ActionRetentionSet},
+	}
+}
+
+func (p *Purger) serveReport(w http.ResponseWriter, r *http.Request) {
+	rep, err := p.DryRun(r.Context())
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.WriteJSON(w, rep)
+}
+
+func (p *Purger) servePurge(w http.ResponseWriter, r *http.Request) {
+	rep, err := p.Purge(r.Context())
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	slog.Info("retention purge requested", "messages", rep.Messages, "rooms", len(rep.Rooms))
+	control.AuditDetail(r, "messages", rep.Messages)
+	control.WriteJSON(w, rep)
+}
+
+func (p *Purger) servePurges(w http.ResponseWriter, r *http.Request) {
+	var roomID int64
+	if v := r.URL.Query().Get("roomId"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid roomId", http.StatusBadRequest)
+			return
+		}
+		roomID = id
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	items, err := p.st.ListPurges(r.Context(), roomID, limit)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.WriteJSON(w, map[string]any{"items": items})
+}
+
+func (p *Purger) serveRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := roomIDParam(w, r)
+	if !ok {
+		return
+	}
+	pol, err := p.st.GetRetention(r.Context(), roomID)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	control.WriteJSON(w, pol)
+}
+
+// serveSetRoom changes only the fields present in the body; "days": null
+// returns the room to the global default.
+func (p *Purger) serveSetRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := roomIDParam(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Days      json.RawMessage `json:"days"`
+		LegalHold *bool           `json:"legalHold"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	pol, err := p.st.GetRetention(r.Context(), roomID)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	switch {
+	case body.Days == nil:
+	case bytes.Equal(body.Days, []byte("null")):
+		pol.Days = nil
+	default:
+		var days int
+		if err := json.Unmarshal(body.Days, &days); err != nil || days < 0 || days > maxDays {
+			http.Error(w, "days must be null or 0.."+strconv.Itoa(maxDays), http.StatusBadRequest)
+			return
+		}
+		pol.Days = &days
+	}
+	if body.LegalHold != nil {
+		pol.LegalHold = *body.LegalHold
+	}
+	if pol, err = p.st.SetRetention(r.Context(), pol); err != nil {
+		httpError(w, err)
+		return
+	}
+	slog.Info("room retention changed", "room_id", roomID, "days", pol.Days, "legal_hold", pol.LegalHold)
+	control.AuditDetail(r, "days", pol.Days)
+	control.AuditDetail(r, "legalHold", pol.LegalHold)
+	control.WriteJSON(w, pol)
+}
+
+func roomIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("roomId"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid roomId", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	e := apperr.From(err)
+	status := e.Code.HTTPStatus()
+	if status >= http.StatusInternalServerError {
+		slog.Error("retention admin request failed", logging.Err(err))
+	}
+	http.Error(w, e.Message, status)
+}
@@ -0,0 +1,170 @@
+// Package retention deletes messages older than their room's retention period.
+// The period is RETENTION_DAYS unless the room sets its own; rooms under legal
+// hold are skipped. Deletes run in small batches, each its own statement, so
+// no lock is held for longer than one batch, and every room purge is written
+// to the purge audit trail.
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+type Options struct {
+	// DefaultDays applies to rooms without their own period; 0 keeps them
+	// forever.
+	DefaultDays int
+	// Interval between background runs; 0 disables the background job (the
+	// admin API can still run a purge).
+	Interval time.Duration
+	// BatchSize is how many messages one delete statement removes.
+	BatchSize int
+	// BatchPause is the wait between batches, leaving room for other writers.
+	BatchPause time.Duration
+}
+
+// Purger applies retention policies. Running it on several instances is safe:
+// a message is deleted, and counted, by whichever gets to it first.
+type Purger struct {
+	st   store.Retention
+	opts Options
+	mu   sync.Mutex // one run at a time per instance
+}
+
+func New(st store.Retention, opts Options) *Purger {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	return &Purger{st: st, opts: opts}
+}
+
+// Report is the result of a run. In a dry run Messages counts what a purge
+// would delete right now.
+type Report struct {
+	DryRun      bool         `json:"dryRun"`
+	DefaultDays int          `json:"defaultDays"`
+	StartedAt   time.Time    `json:"startedAt"`
+	Rooms       []RoomReport `json:"rooms"`
+	Messages    int64        `json:"messages"`
+}
+
+// RoomReport covers one room with a retention period and messages past it.
+// For a room under legal hold Messages is what the hold keeps; those are not
+// part of Report.Messages.
+type RoomReport struct {
+	RoomID    int64     `json:"roomId"`
+	Days      int       `json:"days"`
+	Cutoff    time.Time `json:"cutoff"`
+	Messages  int64     `json:"messages"`
+	LegalHold bool      `json:"legalHold,omitempty"`
+}
+
+// Run purges every Interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	if p.opts.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(p.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := p.Purge(ctx); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Error("retention purge failed", logging.Err(err))
+			}
+		}
+	}
+}
+
+// Purge deletes expired messages of every room.
+func (p *Purger) Purge(ctx context.Context) (Report, error) {
+	return p.run(ctx, false)
+}
+
+// DryRun reports what Purge would delete without deleting anything.
+func (p *Purger) DryRun(ctx context.Context) (Report, error) {
+	return p.run(ctx, true)
+}
+
+func (p *Purger) run(ctx context.Context, dryRun bool) (Report, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rep := Report{DryRun: dryRun, DefaultDays: p.opts.DefaultDays, StartedAt: time.Now().UTC(), Rooms: []RoomReport{}}
+	policies, err := p.st.ListRetention(ctx)
+	if err != nil {
+		return rep, err
+	}
+	for _, pol := range policies {
+		days := pol.EffectiveDays(p.opts.DefaultDays)
+		if days <= 0 {
+			continue
+		}
+		rr := RoomReport{
+			RoomID:    pol.RoomID,
+			Days:      days,
+			Cutoff:    rep.StartedAt.Add(-time.Duration(days) * 24 * time.Hour),
+			LegalHold: pol.LegalHold,
+		}
+		if dryRun || pol.LegalHold {
+			rr.Messages, err = p.st.CountMessagesBefore(ctx, rr.RoomID, rr.Cutoff)
+		} else {
+			rr.Messages, err = p.purgeRoom(ctx, rr.RoomID, rr.Cutoff)
+		}
+		if err != nil {
+			return rep, err
+		}
+		if rr.Messages == 0 {
+			continue
+		}
+		rep.Rooms = append(rep.Rooms, rr)
+		if !pol.LegalHold {
+			rep.Messages += rr.Messages
+		}
+	}
+	if !dryRun && rep.Messages > 0 {
+		logging.FromContext(ctx).Info("retention purge done", "messages", rep.Messages, "rooms", len(rep.Rooms))
+	}
+	return rep, nil
+}
+
+// purgeRoom deletes the room's messages before cutoff batch by batch and
+// records the total in the audit trail, also when a later batch fails.
+func (p *Purger) purgeRoom(ctx context.Context, roomID int64, cutoff time.Time) (int64, error) {
+	var total int64
+	var err error
+	for {
+		var n int64
+		n, err = p.st.PurgeMessages(ctx, roomID, cutoff, p.opts.BatchSize)
+		total += n
+		if err != nil || n < int64(p.opts.BatchSize) {
+			break
+		}
+		if p.opts.BatchPause > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(p.opts.BatchPause):
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if total > 0 {
+		metrics.MessagesPurged(total)
+		logging.FromContext(ctx).Info("retention purged room", "room_id", roomID, "messages", total, "cutoff", cutoff)
+		// the audit record must not be lost to a cancelled ctx
+		if _, rerr := p.st.RecordPurge(context.WithoutCancel(ctx), store.PurgeRecord{RoomID: roomID, Cutoff: cutoff, Deleted: total}); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return total, err
+}
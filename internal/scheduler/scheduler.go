@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/push"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/tracing"
+	"github.com/yngus4862/chat/internal/ws"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxAttempts is how often a scheduled item is tried before it is marked failed.
+const maxAttempts = 5
+
+// Scheduler posts scheduled messages and fires reminders when they come due.
+// Any number of chatd instances may run one; rows are claimed with a lease so
+// each item is handled by one instance at a time.
+type Scheduler struct {
+	st       store.Store
+	hub      *ws.Hub
+	mod      *moderation.Moderator
+	push     push.Notifier
+	owner    string
+	interval time.Duration
+	lease    time.Duration
+	batch    int
+}
+
+// New returns a Scheduler; mod may be nil to post scheduled messages
+// unchecked.
+func New(st store.Store, hub *ws.Hub, mod *moderation.Moderator, notifier push.Notifier, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	host, _ := os.Hostname()
+	return &Scheduler{
+		st:       st,
+		hub:      hub,
+		mod:      mod,
+		push:     notifier,
+		owner:    fmt.Sprintf("%s/%d", host, os.Getpid()),
+		interval: interval,
+		lease:    time.Minute,
+		batch:    50,
+	}
+}
+
+// Run polls for due items until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce claims and handles every item due now.
+func (s *Scheduler) RunOnce(ctx context.Context) {
+	for {
+		items, err := s.st.ClaimDueScheduled(ctx, s.owner, s.lease, s.batch)
+		if err != nil {
+			logging.FromContext(ctx).Error("scheduler claim failed", logging.Err(err))
+			return
+		}
+		for _, sm := range items {
+			s.process(ctx, sm)
+		}
+		if len(items) < s.batch {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) process(ctx context.Context, sm store.ScheduledMessage) {
+	ctx, span := tracing.Start(ctx, "scheduler "+sm.Kind, trace.WithAttributes(
+		attribute.Int64("chat.scheduled_id", sm.ID), tracing.RoomID(sm.RoomID),
+	))
+	defer span.End()
+	l := logging.FromContext(ctx).With("scheduled_id", sm.ID, "kind", sm.Kind)
+	ctx = logging.WithContext(ctx, l)
+	var (
+		sentID int64
+		err    error
+	)
+	switch sm.Kind {
+	case store.ScheduledKindReminder:
+		err = s.remind(ctx, sm)
+	default:
+		sentID, err = s.send(ctx, sm)
+	}
+	if err == nil {
+		if err := s.st.MarkScheduledSent(ctx, sm.ID, s.owner, sentID); err != nil {
+			l.Error("scheduler mark sent failed", logging.Err(err))
+		}
+		return
+	}
+
+	final := sm.Attempts >= maxAttempts ||
+		errors.Is(err, store.ErrRoomNotFound) || errors.Is(err, store.ErrMessageNotFound) ||
+		apperr.From(err).Code == apperr.CodeRejected
+	span.RecordError(err)
+	l.Warn("scheduled item failed", "attempt", sm.Attempts, "final", final, logging.Err(err))
+	if err := s.st.MarkScheduledFailed(ctx, sm.ID, s.owner, err.Error(), final); err != nil {
+		l.Error("scheduler mark failed failed", logging.Err(err))
+	}
+}
+
+// send posts the message through the same path as REST. The clientMsgId is
+// derived from the schedule id, so a retry after a crash between insert and
+// MarkScheduledSent returns the already stored message instead of a duplicate.
+// The content is moderated again: filters may have changed since it was
+// scheduled, and flags only take effect once the message exists.
+func (s *Scheduler) send(ctx context.Context, sm store.ScheduledMessage) (int64, error) {
+	verdict, err := s.mod.Check(ctx, sm.Content)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := s.st.CreateMessage(ctx, sm.RoomID, sm.UserID, verdict.Content, "scheduled", "sched-"+strconv.FormatInt(sm.ID, 10))
+	if err != nil {
+		return 0, err
+	}
+	s.mod.Review(ctx, msg, sm.UserID, verdict)
+	if s.hub != nil {
+		s.hub.BroadcastMessage(ctx, msg)
+	}
+	return msg.ID, nil
+}
+
+// remind sends the reminder to the user's live connections, falling back to a
+// push notification when the user is not connected anywhere. A reminder of a
+// message by a user the owner has since blocked is dropped, push included.
+func (s *Scheduler) remind(ctx context.Context, sm store.ScheduledMessage) error {
+	msg, err := s.st.GetMessage(ctx, sm.MessageID)
+	if err != nil {
+		return err
+	}
+	blocks, err := store.LoadBlockSet(ctx, s.st, sm.UserID)
+	if err != nil {
+		return err
+	}
+	if blocks.Hides(msg) {
+		logging.FromContext(ctx).Debug("reminder of blocked author dropped", "author", msg.Author)
+		return nil
+	}
+	ev := ws.Event{
+		Type:   ws.EventReminder,
+		RoomID: sm.RoomID,
+		Data: map[string]any{
+			"reminderId": sm.ID,
+			"note":       sm.Content,
+			"message":    msg,
+		},
+	}
+	if s.hub != nil && s.hub.SendToUser(ctx, sm.UserID, ev) {
+		return nil
+	}
+	if s.push == nil {
+		return nil
+	}
+	body := sm.Content
+	if body == "" {
+		body = msg.Content
+	}
+	return s.push.Notify(ctx, sm.UserID, push.Notification{
+		Title: "Reminder",
+		Body:  body,
+		Data: map[string]any{
+			"type":      ws.EventReminder,
+			"roomId":    sm.RoomID,
+			"messageId": sm.MessageID,
+			"seq":       msg.Seq,
+		},
+	})
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type Member struct {
+	UserID   string    `json:"userId"`
+	IsAdmin  bool      `json:"isAdmin"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+func (s *Postgres) GetRoom(ctx context.Context, roomID int64) (Room, error) {
+	r, err := scanRoom(s.pool.QueryRow(ctx, roomSelect+` WHERE r.id=$1`, roomID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Room{}, ErrRoomNotFound
+	}
+	return r, err
+}
+
+func (s *Postgres) ListMembers(ctx context.Context, roomID int64) ([]Member, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT user_id, is_admin, joined_at FROM room_members WHERE room_id=$1 ORDER BY joined_at, user_id`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Member, 0)
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.IsAdmin, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *Postgres) ImportRoom(ctx context.Context, origin, name string, createdAt time.Time) (Room, bool, error) {
+	var (
+		r       Room
+		created bool
+	)
+	err := s.pool.QueryRow(ctx,
+		`WITH ins AS (
+			INSERT INTO chat_rooms(name, created_at, origin) VALUES($1, $2, $3)
+			 ON CONFLICT (origin) DO NOTHING
+			 RETURNING id, name, created_at
+		 )
+		 SELECT id, name, created_at, true FROM ins
+		 UNION ALL
+		 SELECT id, name, created_at, false FROM chat_rooms WHERE origin=$3
+		 LIMIT 1`,
+		name, createdAt, origin,
+	).Scan(&r.ID, &r.Name, &r.CreatedAt, &created)
+	return r, created, err
+}
+
+func (s *Postgres) ImportMembers(ctx context.Context, roomID int64, members []Member) (int, error) {
+	users := make([]string, len(members))
+	admins := make([]bool, len(members))
+	joined := make([]time.Time, len(members))
+	for i, m := range members {
+		users[i], admins[i], joined[i] = m.UserID, m.IsAdmin, m.JoinedAt
+	}
+	tag, err := s.pool.Exec(ctx,
+		`INSERT INTO room_members(room_id, user_id, is_admin, joined_at)
+			 SELECT $1, u, a, j FROM unnest($2::text[], $3::bool[], $4::timestamptz[]) AS t(u, a, j)
+			 ON CONFLICT (room_id, user_id) DO NOTHING`,
+		roomID, users, admins, joined,
+	)
+	if isForeignKeyViolation(err) {
+		return 0, ErrRoomNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ImportMessages holds the room lock for the whole batch, like CreateMessage
+// does for one message, so imported messages get consecutive seqs.
+func (s *Postgres) ImportMessages(ctx context.Context, roomID int64, msgs []Message) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var seq int64
+	err = tx.QueryRow(ctx, `SELECT last_seq FROM chat_rooms WHERE id=$1 FOR UPDATE`, roomID).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrRoomNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var last Message
+	n := 0
+	for _, m := range msgs {
+		m, err = scanMessage(tx.QueryRow(ctx,
+			`INSERT INTO messages(room_id, seq, author, content, source, client_msg_id, created_at)
+				 VALUES($1, $2, $3, $4, $5, $6, $7)
+				 ON CONFLICT (room_id, client_msg_id) DO NOTHING
+				 RETURNING `+messageColumns,
+			roomID, seq+1, m.Author, m.Content, m.Source, m.ClientMsgID, m.CreatedAt,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue // already imported
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 || !m.CreatedAt.Before(last.CreatedAt) {
+			last = m
+		}
+		seq = m.Seq
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE chat_rooms SET last_seq=$2,
+		        last_message_id = CASE WHEN last_message_at IS NULL OR last_message_at <= $4 THEN $3 ELSE last_message_id END,
+		        last_message_at = GREATEST(last_message_at, $4)
+		  WHERE id=$1`,
+		roomID, seq, last.ID, last.CreatedAt,
+	); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// AuditEvent is one entry of the audit log. Actor is a user id, or "admin" or
+// "console" for actions taken through the admin API or the operator console.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	At         time.Time       `json:"at"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType,omitempty"`
+	TargetID   string          `json:"targetId,omitempty"`
+	RequestID  string          `json:"requestId,omitempty"`
+	RemoteAddr string          `json:"remoteAddr,omitempty"`
+	UserAgent  string          `json:"userAgent,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// AuditQuery filters the audit log; zero fields match everything. Before is
+// the id of the last event of the previous page.
+type AuditQuery struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	Before     int64
+	Limit      int
+}
+
+// auditFilter renders q as a WHERE clause with placeholders from ph, which
+// maps an argument number to its placeholder.
+func auditFilter(q AuditQuery, ph func(n int) string, at func(time.Time) any) (string, []any) {
+	where := `WHERE id < ` + ph(1)
+	limit, before := bookmarkPage(q.Limit, q.Before)
+	args := []any{before}
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where += ` AND ` + cond + ph(len(args))
+	}
+	if q.Actor != "" {
+		add(`actor = `, q.Actor)
+	}
+	if q.Action != "" {
+		add(`action = `, q.Action)
+	}
+	if q.TargetType != "" {
+		add(`target_type = `, q.TargetType)
+	}
+	if q.TargetID != "" {
+		add(`target_id = `, q.TargetID)
+	}
+	if !q.Since.IsZero() {
+		add(`at >= `, at(q.Since))
+	}
+	if !q.Until.IsZero() {
+		add(`at < `, at(q.Until))
+	}
+	args = append(args, limit+1)
+	return where + ` ORDER BY id DESC LIMIT ` + ph(len(args)), args
+}
+
+// auditPage trims a limit+1 read to one page and returns the next cursor.
+func auditPage(out []AuditEvent, limit int) ([]AuditEvent, int64) {
+	limit, _ = bookmarkPage(limit, 0)
+	if len(out) <= limit {
+		return out, 0
+	}
+	out = out[:limit]
+	return out, out[limit-1].ID
+}
+
+const auditColumns = `id, at, actor, action, target_type, target_id, request_id, remote_addr, user_agent, details`
+
+func (s *Postgres) AppendAudit(ctx context.Context, e AuditEvent) (AuditEvent, error) {
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO audit_events(actor, action, target_type, target_id, request_id, remote_addr, user_agent, details)
+			 VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+			 RETURNING id, at`,
+		e.Actor, e.Action, e.TargetType, e.TargetID, e.RequestID, e.RemoteAddr, e.UserAgent, nullJSON(e.Details),
+	).Scan(&e.ID, &e.At)
+	return e, err
+}
+
+func (s *Postgres) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, int64, error) {
+	where, args := auditFilter(q,
+		func(n int) string { return `$` + strconv.Itoa(n) },
+		func(t time.Time) any { return t },
+	)
+	rows, err := s.pool.Query(ctx, `SELECT `+auditColumns+` FROM audit_events `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]AuditEvent, 0)
+	for rows.Next() {
+		var (
+			e       AuditEvent
+			details []byte
+		)
+		if err := rows.Scan(&e.ID, &e.At, &e.Actor, &e.Action, &e.TargetType, &e.TargetID,
+			&e.RequestID, &e.RemoteAddr, &e.UserAgent, &details); err != nil {
+			return nil, 0, err
+		}
+		e.Details = details
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	out, next := auditPage(out, q.Limit)
+	return out, next, nil
+}
+
+// nullJSON stores empty details as NULL.
+func nullJSON(b json.RawMessage) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
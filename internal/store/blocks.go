@@ -0,0 +1,215 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/yngus4862/chat/internal/apperr"
+)
+
+// Report states. An open report is closed once, as resolved (the admins
+// acted on it) or dismissed (nothing to act on).
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+var (
+	ErrBlockNotFound  = apperr.New(apperr.CodeNotFound, "block not found")
+	ErrReportNotFound = apperr.New(apperr.CodeNotFound, "report not found")
+	ErrReportResolved = apperr.New(apperr.CodeConflict, "report already closed")
+)
+
+// Block is a user another user blocked.
+type Block struct {
+	UserID    string    `json:"userId"`
+	BlockedAt time.Time `json:"blockedAt"`
+}
+
+// Report is a message a user reported to the admins. Content is the message
+// as stored, read along with the report.
+type Report struct {
+	ID         int64      `json:"id"`
+	MessageID  int64      `json:"messageId"`
+	RoomID     int64      `json:"roomId"`
+	Reporter   string     `json:"reporter"`
+	Reason     string     `json:"reason"`
+	Note       string     `json:"note,omitempty"`
+	Content    string     `json:"content"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ResolvedBy string     `json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// BlockSet is the users one user blocked.
+type BlockSet map[string]bool
+
+// Hides reports whether m was posted by a blocked user. Anonymous messages
+// are never hidden.
+func (b BlockSet) Hides(m Message) bool {
+	return m.Author != "" && b[m.Author]
+}
+
+// LoadBlockSet reads the users userID blocked; anonymous users block no one.
+func LoadBlockSet(ctx context.Context, st Blocks, userID string) (BlockSet, error) {
+	if userID == "" {
+		return BlockSet{}, nil
+	}
+	blocks, err := st.ListBlocks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	set := make(BlockSet, len(blocks))
+	for _, b := range blocks {
+		set[b.UserID] = true
+	}
+	return set, nil
+}
+
+const reportSelect = `SELECT p.id, p.message_id, p.room_id, p.reporter, p.reason, p.note, m.content, p.status,
+		p.created_at, COALESCE(p.resolved_by, ''), p.resolved_at
+	  FROM message_reports p JOIN messages m ON m.id = p.message_id`
+
+func (s *Postgres) BlockUser(ctx context.Context, userID, blockedID string) (Block, bool, error) {
+	b := Block{UserID: blockedID}
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO user_blocks(user_id, blocked_id) VALUES ($1, $2)
+			 ON CONFLICT (user_id, blocked_id) DO NOTHING
+			 RETURNING created_at`,
+		userID, blockedID,
+	).Scan(&b.BlockedAt)
+	if err == nil {
+		return b, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return Block{}, false, err
+	}
+	err = s.pool.QueryRow(ctx,
+		`SELECT created_at FROM user_blocks WHERE user_id=$1 AND blocked_id=$2`,
+		userID, blockedID,
+	).Scan(&b.BlockedAt)
+	return b, false, err
+}
+
+func (s *Postgres) UnblockUser(ctx context.Context, userID, blockedID string) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM user_blocks WHERE user_id=$1 AND blocked_id=$2`,
+		userID, blockedID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+func (s *Postgres) ListBlocks(ctx context.Context, userID string) ([]Block, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT blocked_id, created_at FROM user_blocks WHERE user_id=$1 ORDER BY created_at DESC, blocked_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Block, 0)
+	for rows.Next() {
+		var b Block
+		if err := rows.Scan(&b.UserID, &b.BlockedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+func (s *Postgres) ReportMessage(ctx context.Context, r Report) (Report, bool, error) {
+	var id int64
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO message_reports(message_id, room_id, reporter, reason, note)
+			 SELECT id, room_id, $3, $4, $5 FROM messages WHERE id=$1 AND room_id=$2
+			 ON CONFLICT (message_id, reporter) DO NOTHING
+			 RETURNING id`,
+		r.MessageID, r.RoomID, r.Reporter, r.Reason, r.Note,
+	).Scan(&id)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return Report{}, false, err
+	}
+	created := err == nil
+	r, err = scanReport(s.pool.QueryRow(ctx,
+		reportSelect+` WHERE p.message_id=$1 AND p.room_id=$2 AND p.reporter=$3`,
+		r.MessageID, r.RoomID, r.Reporter,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Report{}, false, ErrMessageNotFound
+	}
+	return r, created, err
+}
+
+func (s *Postgres) ListReports(ctx context.Context, status string, before int64, limit int) ([]Report, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	rows, err := s.pool.Query(ctx,
+		reportSelect+` WHERE p.id < $1 AND ($2::text = '' OR p.status = $2) ORDER BY p.id DESC LIMIT $3`,
+		before, status, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Report, 0)
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	out, next := reportPage(out, limit)
+	return out, next, nil
+}
+
+func (s *Postgres) ResolveReport(ctx context.Context, id int64, status, resolver string) (Report, error) {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE message_reports SET status=$2, resolved_by=$3, resolved_at=now()
+		  WHERE id=$1 AND status='open'`,
+		id, status, resolver,
+	)
+	if err != nil {
+		return Report{}, err
+	}
+	r, err := scanReport(s.pool.QueryRow(ctx, reportSelect+` WHERE p.id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Report{}, ErrReportNotFound
+	}
+	if err == nil && tag.RowsAffected() == 0 {
+		return r, ErrReportResolved
+	}
+	return r, err
+}
+
+func scanReport(row pgx.Row) (Report, error) {
+	var r Report
+	err := row.Scan(&r.ID, &r.MessageID, &r.RoomID, &r.Reporter, &r.Reason, &r.Note, &r.Content, &r.Status,
+		&r.CreatedAt, &r.ResolvedBy, &r.ResolvedAt)
+	return r, err
+}
+
+// reportPage trims a limit+1 read to one page and returns the next cursor.
+func reportPage(out []Report, limit int) ([]Report, int64) {
+	if len(out) <= limit {
+		return out, 0
+	}
+	out = out[:limit]
+	return out, out[limit-1].ID
+}
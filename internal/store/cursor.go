@@ -0,0 +1,96 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/yngus4862/chat/internal/apperr"
+)
+
+var ErrInvalidCursor = apperr.Validation("cursor", "invalid cursor")
+
+// roomCursor is the position of the last room on a page. Key holds the sort
+// column value (activity time in unix micros, or the room name) and ID breaks ties.
+type roomCursor struct {
+	Sort RoomSort `json:"s"`
+	Key  string   `json:"k,omitempty"`
+	ID   int64    `json:"id"`
+}
+
+func encodeRoomCursor(c roomCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeRoomCursor(s string, sort RoomSort) (roomCursor, error) {
+	var c roomCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != sort || c.ID <= 0 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// messageCursorVersion is the format of message cursors. Version 1 was a
+// bare message id and is no longer accepted.
+const messageCursorVersion = 2
+
+// ErrLegacyCursor rejects a message id passed as cursor, the format before
+// cursors became opaque.
+var ErrLegacyCursor = apperr.Validation("cursor", "message ids are no longer accepted as cursor; pass nextCursor or prevCursor from the last page")
+
+// messageCursor continues a message page: Before (older) or After (newer) a
+// seq.
+type messageCursor struct {
+	V      int   `json:"v"`
+	Before int64 `json:"b,omitempty"`
+	After  int64 `json:"a,omitempty"`
+}
+
+// EncodeMessageCursor returns the opaque cursor for the page before or after
+// a seq; exactly one of them is non-zero.
+func EncodeMessageCursor(before, after int64) string {
+	b, _ := json.Marshal(messageCursor{V: messageCursorVersion, Before: before, After: after})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeMessageCursor reads a cursor from EncodeMessageCursor into the
+// before or after seq it continues from.
+func DecodeMessageCursor(s string) (before, after int64, err error) {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return 0, 0, ErrLegacyCursor
+	}
+	var c messageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	if c.V != messageCursorVersion || c.Before < 0 || c.After < 0 || (c.Before > 0) == (c.After > 0) {
+		return 0, 0, ErrInvalidCursor
+	}
+	return c.Before, c.After, nil
+}
+
+// formatMicros and parseMicros encode the activity time held in a cursor Key.
+func formatMicros(t time.Time) string {
+	return strconv.FormatInt(t.UnixMicro(), 10)
+}
+
+func parseMicros(s string) (time.Time, error) {
+	micros, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidCursor
+	}
+	return time.UnixMicro(micros).UTC(), nil
+}
@@ -0,0 +1,966 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a Store kept in process memory. It exists for tests and local
+// experiments: nothing survives a restart and instances do not share state.
+type Memory struct {
+	mu sync.Mutex
+
+	lastID    int64 // ids of every table come from this one counter
+	rooms     map[int64]*Room
+	members   map[int64]map[string]*Member
+	messages  map[int64]*Message
+	byRoom    map[int64][]*Message // seq order
+	pins      map[int64][]*Pin     // room -> pins
+	bookmarks map[string][]*Bookmark
+	scheduled map[int64]*memScheduled
+	origins   map[string]int64        // imported rooms by origin
+	retention map[int64]RoomRetention // rooms with a policy other than the default
+	purges    []*PurgeRecord
+	audit     []AuditEvent                    // id order
+	reviews   []*Review                       // id order; Content is read from messages
+	blocks    map[string]map[string]time.Time // blocker -> blocked -> when
+	reports   []*Report                       // id order; Content is read from messages
+}
+
+type memScheduled struct {
+	ScheduledMessage
+	lockedBy    string
+	lockedUntil time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		rooms:     map[int64]*Room{},
+		members:   map[int64]map[string]*Member{},
+		origins:   map[string]int64{},
+		messages:  map[int64]*Message{},
+		byRoom:    map[int64][]*Message{},
+		pins:      map[int64][]*Pin{},
+		bookmarks: map[string][]*Bookmark{},
+		scheduled: map[int64]*memScheduled{},
+		retention: map[int64]RoomRetention{},
+		blocks:    map[string]map[string]time.Time{},
+	}
+}
+
+// now is truncated to what Postgres stores, so round trips compare equal.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (s *Memory) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *Memory) Ping(context.Context) error { return nil }
+
+func (s *Memory) CreateRoom(_ context.Context, name, createdBy string) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Room{ID: s.nextID(), Name: name, CreatedAt: now()}
+	s.rooms[r.ID] = r
+	s.members[r.ID] = map[string]*Member{}
+	if createdBy != "" {
+		s.members[r.ID][createdBy] = &Member{UserID: createdBy, IsAdmin: true, JoinedAt: r.CreatedAt}
+	}
+	return Room{ID: r.ID, Name: r.Name, CreatedAt: r.CreatedAt}, nil
+}
+
+func (s *Memory) RoomExists(_ context.Context, roomID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.rooms[roomID]
+	return ok, nil
+}
+
+func (s *Memory) IsRoomAdmin(_ context.Context, roomID int64, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[roomID][userID]
+	return ok && m.IsAdmin, nil
+}
+
+func (s *Memory) ListRooms(_ context.Context, p ListRoomsParams) ([]Room, string, error) {
+	order, limit := roomListParams(p)
+	var cur roomCursor
+	var curAt time.Time
+	if p.Cursor != "" {
+		var err error
+		if cur, err = decodeRoomCursor(p.Cursor, order); err != nil {
+			return nil, "", err
+		}
+		if order == RoomSortActivity {
+			if curAt, err = parseMicros(cur.Key); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	all := make([]Room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		all = append(all, s.roomWithPreview(r))
+	}
+	s.mu.Unlock()
+
+	// less reports whether a sorts before b; after reports whether r comes
+	// after the cursor position.
+	var less func(a, b Room) bool
+	var after func(r Room) bool
+	switch order {
+	case RoomSortCreated:
+		less = func(a, b Room) bool { return a.ID > b.ID }
+		after = func(r Room) bool { return r.ID < cur.ID }
+	case RoomSortName:
+		less = func(a, b Room) bool { return a.Name < b.Name || a.Name == b.Name && a.ID < b.ID }
+		after = func(r Room) bool { return r.Name > cur.Key || r.Name == cur.Key && r.ID > cur.ID }
+	default:
+		less = func(a, b Room) bool {
+			at, bt := a.activity(), b.activity()
+			return at.After(bt) || at.Equal(bt) && a.ID > b.ID
+		}
+		after = func(r Room) bool {
+			at := r.activity()
+			return at.Before(curAt) || at.Equal(curAt) && r.ID < cur.ID
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return less(all[i], all[j]) })
+
+	out := make([]Room, 0, limit+1)
+	for _, r := range all {
+		if p.Cursor != "" && !after(r) {
+			continue
+		}
+		out = append(out, r)
+		if len(out) > limit {
+			break
+		}
+	}
+	out, next := roomPage(out, order, limit)
+	return out, next, nil
+}
+
+// roomWithPreview copies r and attaches its last message preview. s.mu must
+// be held.
+func (s *Memory) roomWithPreview(r *Room) Room {
+	out := *r
+	if m, ok := s.messages[r.LastMessageID]; ok {
+		content := m.Content
+		if rs := []rune(content); len(rs) > previewLen {
+			content = string(rs[:previewLen])
+		}
+		out.LastMessage = &MessagePreview{ID: m.ID, Content: content, Source: m.Source, CreatedAt: m.CreatedAt}
+	}
+	return out
+}
+
+func (s *Memory) GetRoom(_ context.Context, roomID int64) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.rooms[roomID]
+	if !ok {
+		return Room{}, ErrRoomNotFound
+	}
+	return s.roomWithPreview(r), nil
+}
+
+func (s *Memory) CreateMessage(ctx context.Context, roomID int64, author, content, source, clientMsgID string) (Message, error) {
+	if clientMsgID == "" {
+		clientMsgID = newClientMsgID()
+	}
+	s.mu.Lock()
+	r, ok := s.rooms[roomID]
+	if !ok {
+		s.mu.Unlock()
+		return Message{}, ErrRoomNotFound
+	}
+	for _, m := range s.byRoom[roomID] {
+		if m.ClientMsgID == clientMsgID {
+			dup := *m
+			s.mu.Unlock()
+			deduplicated(ctx, dup)
+			return dup, nil
+		}
+	}
+	r.LastSeq++
+	m := &Message{
+		ID:          s.nextID(),
+		RoomID:      roomID,
+		Seq:         r.LastSeq,
+		Author:      author,
+		Content:     content,
+		Source:      source,
+		ClientMsgID: clientMsgID,
+		CreatedAt:   now(),
+	}
+	s.messages[m.ID] = m
+	s.byRoom[roomID] = append(s.byRoom[roomID], m)
+	at := m.CreatedAt
+	r.LastMessageID, r.LastMessageAt = m.ID, &at
+	out := *m
+	s.mu.Unlock()
+
+	stored(ctx, out)
+	return out, nil
+}
+
+func (s *Memory) GetMessage(_ context.Context, id int64) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[id]
+	if !ok {
+		return Message{}, ErrMessageNotFound
+	}
+	return *m, nil
+}
+
+func (s *Memory) ListMessages(ctx context.Context, roomID int64, q MessageQuery) (MessagePage, error) {
+	return pageMessages(ctx, s, roomID, q)
+}
+
+func (s *Memory) MessagesAfter(ctx context.Context, roomID, afterSeq int64, limit int) ([]Message, bool, error) {
+	return s.messageWindow(ctx, roomID, ">", afterSeq, afterLimit(limit))
+}
+
+func (s *Memory) messageWindow(_ context.Context, roomID int64, op string, pivot int64, limit int) ([]Message, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.byRoom[roomID]
+	out := make([]Message, 0, limit+1)
+	if op == ">" {
+		for _, m := range msgs {
+			if m.Seq > pivot {
+				out = append(out, *m)
+				if len(out) > limit {
+					break
+				}
+			}
+		}
+	} else {
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if m := msgs[i]; m.Seq < pivot || op == "<=" && m.Seq == pivot {
+				out = append(out, *m)
+				if len(out) > limit {
+					break
+				}
+			}
+		}
+	}
+	if len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
+}
+
+func (s *Memory) messageExists(ctx context.Context, roomID int64, op string, pivot int64) (bool, error) {
+	out, _, err := s.messageWindow(ctx, roomID, op, pivot, 1)
+	return len(out) > 0, err
+}
+
+func (s *Memory) ListPins(_ context.Context, roomID int64) ([]Pin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listPins(roomID), nil
+}
+
+// listPins copies the room's pins in order. s.mu must be held.
+func (s *Memory) listPins(roomID int64) []Pin {
+	pins := s.pins[roomID]
+	sort.SliceStable(pins, func(i, j int) bool {
+		a, b := pins[i], pins[j]
+		return a.Position < b.Position || a.Position == b.Position && a.PinnedAt.Before(b.PinnedAt)
+	})
+	out := make([]Pin, 0, len(pins))
+	for _, p := range pins {
+		out = append(out, s.pinCopy(p))
+	}
+	return out
+}
+
+func (s *Memory) pinCopy(p *Pin) Pin {
+	out := *p
+	m := *s.messages[p.MessageID]
+	out.Message = &m
+	return out
+}
+
+func (s *Memory) AddPin(_ context.Context, roomID, messageID int64, pinnedBy string) (Pin, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return Pin{}, false, ErrRoomNotFound
+	}
+	if m, ok := s.messages[messageID]; !ok || m.RoomID != roomID {
+		return Pin{}, false, ErrMessageNotFound
+	}
+	maxPos := 0
+	for _, p := range s.pins[roomID] {
+		if p.MessageID == messageID {
+			return s.pinCopy(p), false, nil
+		}
+		if p.Position > maxPos {
+			maxPos = p.Position
+		}
+	}
+	if len(s.pins[roomID]) >= MaxPinsPerRoom {
+		return Pin{}, false, ErrPinLimit
+	}
+	p := &Pin{RoomID: roomID, MessageID: messageID, Position: maxPos + 1, PinnedBy: pinnedBy, PinnedAt: now()}
+	s.pins[roomID] = append(s.pins[roomID], p)
+	return s.pinCopy(p), true, nil
+}
+
+func (s *Memory) RemovePin(_ context.Context, roomID, messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := s.pins[roomID]
+	for i, p := range pins {
+		if p.MessageID == messageID {
+			s.pins[roomID] = append(pins[:i], pins[i+1:]...)
+			return nil
+		}
+	}
+	return ErrPinNotFound
+}
+
+func (s *Memory) ReorderPins(_ context.Context, roomID int64, messageIDs []int64) ([]Pin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return nil, ErrRoomNotFound
+	}
+	pins := s.pins[roomID]
+	pos := make(map[int64]int, len(messageIDs))
+	for i, id := range messageIDs {
+		pos[id] = i + 1
+	}
+	if len(pos) != len(messageIDs) || len(messageIDs) != len(pins) {
+		return nil, ErrPinOrderInvalid
+	}
+	for _, p := range pins {
+		if _, ok := pos[p.MessageID]; !ok {
+			return nil, ErrPinOrderInvalid
+		}
+	}
+	for _, p := range pins {
+		p.Position = pos[p.MessageID]
+	}
+	return s.listPins(roomID), nil
+}
+
+func (s *Memory) PutBookmark(_ context.Context, userID string, messageID int64, note string) (Bookmark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[messageID]
+	if !ok {
+		return Bookmark{}, ErrMessageNotFound
+	}
+	for _, b := range s.bookmarks[userID] {
+		if b.MessageID == messageID {
+			b.Note = note
+			return Bookmark{ID: b.ID, MessageID: b.MessageID, Note: b.Note, CreatedAt: b.CreatedAt}, nil
+		}
+	}
+	b := &Bookmark{ID: s.nextID(), MessageID: m.ID, Note: note, CreatedAt: now()}
+	s.bookmarks[userID] = append(s.bookmarks[userID], b)
+	return *b, nil
+}
+
+func (s *Memory) DeleteBookmark(_ context.Context, userID string, messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bs := s.bookmarks[userID]
+	for i, b := range bs {
+		if b.MessageID == messageID {
+			s.bookmarks[userID] = append(bs[:i], bs[i+1:]...)
+			return nil
+		}
+	}
+	return ErrBookmarkNotFound
+}
+
+func (s *Memory) ListBookmarks(_ context.Context, userID string, before int64, limit int) ([]Bookmark, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bs := s.bookmarks[userID] // id order
+	out := make([]Bookmark, 0, limit+1)
+	for i := len(bs) - 1; i >= 0 && len(out) <= limit; i-- {
+		if bs[i].ID >= before {
+			continue
+		}
+		b := *bs[i]
+		m := *s.messages[b.MessageID]
+		b.Message = &m
+		out = append(out, b)
+	}
+	if len(out) > limit {
+		out = out[:limit]
+		return out, out[limit-1].ID, nil
+	}
+	return out, 0, nil
+}
+
+func (s *Memory) ScheduleMessage(_ context.Context, userID string, roomID int64, content string, sendAt time.Time) (ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return ScheduledMessage{}, ErrRoomNotFound
+	}
+	return s.schedule(ScheduledMessage{Kind: ScheduledKindMessage, UserID: userID, RoomID: roomID, Content: content, SendAt: sendAt}), nil
+}
+
+func (s *Memory) ScheduleReminder(_ context.Context, userID string, messageID int64, note string, remindAt time.Time) (ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[messageID]
+	if !ok {
+		return ScheduledMessage{}, ErrMessageNotFound
+	}
+	return s.schedule(ScheduledMessage{Kind: ScheduledKindReminder, UserID: userID, RoomID: m.RoomID, MessageID: messageID, Content: note, SendAt: remindAt}), nil
+}
+
+// schedule stores a new pending item. s.mu must be held.
+func (s *Memory) schedule(sm ScheduledMessage) ScheduledMessage {
+	sm.ID = s.nextID()
+	sm.SendAt = sm.SendAt.UTC().Truncate(time.Microsecond)
+	sm.Status = ScheduledPending
+	sm.CreatedAt = now()
+	s.scheduled[sm.ID] = &memScheduled{ScheduledMessage: sm}
+	return sm
+}
+
+// sortedScheduled lists items by send time, then id. s.mu must be held.
+func (s *Memory) sortedScheduled() []*memScheduled {
+	out := make([]*memScheduled, 0, len(s.scheduled))
+	for _, sm := range s.scheduled {
+		out = append(out, sm)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		return a.SendAt.Before(b.SendAt) || a.SendAt.Equal(b.SendAt) && a.ID < b.ID
+	})
+	return out
+}
+
+func (s *Memory) ListScheduled(_ context.Context, userID string) ([]ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScheduledMessage, 0)
+	for _, sm := range s.sortedScheduled() {
+		if sm.UserID == userID && sm.Status != ScheduledSent && len(out) < 500 {
+			out = append(out, sm.ScheduledMessage)
+		}
+	}
+	return out, nil
+}
+
+func (s *Memory) CancelScheduled(_ context.Context, userID string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sm, ok := s.scheduled[id]
+	if !ok || sm.UserID != userID || (sm.Status != ScheduledPending && sm.Status != ScheduledFailed) {
+		return ErrScheduledNotFound
+	}
+	delete(s.scheduled, id)
+	return nil
+}
+
+func (s *Memory) ClaimDueScheduled(_ context.Context, owner string, lease time.Duration, limit int) ([]ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := time.Now()
+	out := make([]ScheduledMessage, 0, limit)
+	for _, sm := range s.sortedScheduled() {
+		if len(out) >= limit {
+			break
+		}
+		due := sm.Status == ScheduledPending && !sm.SendAt.After(t)
+		expired := sm.Status == ScheduledProcessing && sm.lockedUntil.Before(t)
+		if !due && !expired {
+			continue
+		}
+		sm.Status = ScheduledProcessing
+		sm.lockedBy, sm.lockedUntil = owner, t.Add(lease)
+		sm.Attempts++
+		out = append(out, sm.ScheduledMessage)
+	}
+	return out, nil
+}
+
+func (s *Memory) MarkScheduledSent(_ context.Context, id int64, owner string, sentMessageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sm, ok := s.scheduled[id]; ok && sm.lockedBy == owner {
+		sm.Status, sm.SentMessageID = ScheduledSent, sentMessageID
+		sm.lockedBy, sm.lockedUntil = "", time.Time{}
+	}
+	return nil
+}
+
+func (s *Memory) MarkScheduledFailed(_ context.Context, id int64, owner, reason string, final bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sm, ok := s.scheduled[id]
+	if !ok || sm.lockedBy != owner {
+		return nil
+	}
+	sm.LastError = reason
+	if final {
+		sm.Status = ScheduledFailed
+		sm.lockedBy, sm.lockedUntil = "", time.Time{}
+	}
+	return nil
+}
+
+func (s *Memory) ListRetention(_ context.Context) ([]RoomRetention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]RoomRetention, 0, len(s.rooms))
+	for id := range s.rooms {
+		out = append(out, s.roomRetention(id))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].RoomID < out[j].RoomID })
+	return out, nil
+}
+
+// roomRetention returns the policy of a room. s.mu must be held.
+func (s *Memory) roomRetention(roomID int64) RoomRetention {
+	if r, ok := s.retention[roomID]; ok {
+		return r
+	}
+	return RoomRetention{RoomID: roomID}
+}
+
+func (s *Memory) GetRetention(_ context.Context, roomID int64) (RoomRetention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return RoomRetention{}, ErrRoomNotFound
+	}
+	return s.roomRetention(roomID), nil
+}
+
+func (s *Memory) SetRetention(_ context.Context, p RoomRetention) (RoomRetention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[p.RoomID]; !ok {
+		return RoomRetention{}, ErrRoomNotFound
+	}
+	if p.Days != nil {
+		days := *p.Days
+		p.Days = &days
+	}
+	s.retention[p.RoomID] = p
+	return p, nil
+}
+
+func (s *Memory) CountMessagesBefore(_ context.Context, roomID int64, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := s.openReported()
+	var n int64
+	for _, m := range s.byRoom[roomID] {
+		if m.CreatedAt.Before(before) && !held[m.ID] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// openReported returns the messages with an open report, which retention
+// keeps until the report is closed.
+func (s *Memory) openReported() map[int64]bool {
+	held := map[int64]bool{}
+	for _, r := range s.reports {
+		if r.Status == ReportOpen {
+			held[r.MessageID] = true
+		}
+	}
+	return held
+}
+
+func (s *Memory) PurgeMessages(_ context.Context, roomID int64, before time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retention[roomID].LegalHold {
+		return 0, nil
+	}
+	held := s.openReported()
+	gone := map[int64]bool{}
+	kept := make([]*Message, 0, len(s.byRoom[roomID]))
+	for _, m := range s.byRoom[roomID] {
+		if len(gone) < limit && m.CreatedAt.Before(before) && !held[m.ID] {
+			gone[m.ID] = true
+			delete(s.messages, m.ID)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	if len(gone) == 0 {
+		return 0, nil
+	}
+	s.byRoom[roomID] = kept
+	if r := s.rooms[roomID]; r != nil && gone[r.LastMessageID] {
+		r.LastMessageID = 0
+		if len(kept) > 0 {
+			r.LastMessageID = kept[len(kept)-1].ID
+		}
+	}
+
+	// the cascades of the SQL backends
+	pins := s.pins[roomID][:0]
+	for _, p := range s.pins[roomID] {
+		if !gone[p.MessageID] {
+			pins = append(pins, p)
+		}
+	}
+	s.pins[roomID] = pins
+	for user, bs := range s.bookmarks {
+		kept := bs[:0]
+		for _, b := range bs {
+			if !gone[b.MessageID] {
+				kept = append(kept, b)
+			}
+		}
+		s.bookmarks[user] = kept
+	}
+	for id, sm := range s.scheduled {
+		if gone[sm.MessageID] {
+			delete(s.scheduled, id)
+		}
+	}
+	reviews := s.reviews[:0]
+	for _, r := range s.reviews {
+		if !gone[r.MessageID] {
+			reviews = append(reviews, r)
+		}
+	}
+	s.reviews = reviews
+	reports := s.reports[:0]
+	for _, r := range s.reports {
+		if !gone[r.MessageID] {
+			reports = append(reports, r)
+		}
+	}
+	s.reports = reports
+	return int64(len(gone)), nil
+}
+
+func (s *Memory) RecordPurge(_ context.Context, p PurgeRecord) (PurgeRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.ID, p.PurgedAt = s.nextID(), now()
+	p.Cutoff = p.Cutoff.UTC().Truncate(time.Microsecond)
+	s.purges = append(s.purges, &p)
+	return p, nil
+}
+
+func (s *Memory) ListPurges(_ context.Context, roomID int64, limit int) ([]PurgeRecord, error) {
+	limit = purgeLimit(limit)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]PurgeRecord, 0)
+	for i := len(s.purges) - 1; i >= 0 && len(out) < limit; i-- {
+		if p := s.purges[i]; roomID == 0 || p.RoomID == roomID {
+			out = append(out, *p)
+		}
+	}
+	return out, nil
+}
+
+func (s *Memory) AppendAudit(_ context.Context, e AuditEvent) (AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID, e.At = s.nextID(), now()
+	if len(e.Details) == 0 {
+		e.Details = nil
+	}
+	s.audit = append(s.audit, e)
+	return e, nil
+}
+
+func (s *Memory) ListAudit(_ context.Context, q AuditQuery) ([]AuditEvent, int64, error) {
+	limit, before := bookmarkPage(q.Limit, q.Before)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]AuditEvent, 0)
+	for i := len(s.audit) - 1; i >= 0 && len(out) <= limit; i-- {
+		e := s.audit[i]
+		if e.ID >= before ||
+			q.Actor != "" && e.Actor != q.Actor ||
+			q.Action != "" && e.Action != q.Action ||
+			q.TargetType != "" && e.TargetType != q.TargetType ||
+			q.TargetID != "" && e.TargetID != q.TargetID ||
+			!q.Since.IsZero() && e.At.Before(q.Since) ||
+			!q.Until.IsZero() && !e.At.Before(q.Until) {
+			continue
+		}
+		out = append(out, e)
+	}
+	out, next := auditPage(out, limit)
+	return out, next, nil
+}
+
+func (s *Memory) FlagMessage(_ context.Context, r Review) (Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[r.MessageID]
+	if !ok {
+		return Review{}, ErrMessageNotFound
+	}
+	for _, old := range s.reviews {
+		if old.MessageID == r.MessageID {
+			return s.review(old), nil
+		}
+	}
+	stored := &Review{
+		ID:        s.nextID(),
+		MessageID: m.ID,
+		RoomID:    m.RoomID,
+		UserID:    r.UserID,
+		Reasons:   append([]string{}, r.Reasons...),
+		Status:    ReviewPending,
+		CreatedAt: now(),
+	}
+	s.reviews = append(s.reviews, stored)
+	return s.review(stored), nil
+}
+
+func (s *Memory) ListReviews(_ context.Context, status string, before int64, limit int) ([]Review, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Review, 0)
+	for i := len(s.reviews) - 1; i >= 0 && len(out) <= limit; i-- {
+		if r := s.reviews[i]; r.ID < before && (status == "" || r.Status == status) {
+			out = append(out, s.review(r))
+		}
+	}
+	out, next := reviewPage(out, limit)
+	return out, next, nil
+}
+
+func (s *Memory) ResolveReview(_ context.Context, id int64, status, reviewer string) (Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.reviews {
+		if r.ID != id {
+			continue
+		}
+		if r.Status != ReviewPending {
+			return s.review(r), ErrReviewResolved
+		}
+		at := now()
+		r.Status, r.ReviewedBy, r.ReviewedAt = status, reviewer, &at
+		return s.review(r), nil
+	}
+	return Review{}, ErrReviewNotFound
+}
+
+// review copies r with the message content, as the SQL backends join it.
+func (s *Memory) review(r *Review) Review {
+	out := *r
+	out.Reasons = append([]string{}, r.Reasons...)
+	if m, ok := s.messages[r.MessageID]; ok {
+		out.Content = m.Content
+	}
+	return out
+}
+
+func (s *Memory) BlockUser(_ context.Context, userID, blockedID string) (Block, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at, ok := s.blocks[userID][blockedID]; ok {
+		return Block{UserID: blockedID, BlockedAt: at}, false, nil
+	}
+	if s.blocks[userID] == nil {
+		s.blocks[userID] = map[string]time.Time{}
+	}
+	at := now()
+	s.blocks[userID][blockedID] = at
+	return Block{UserID: blockedID, BlockedAt: at}, true, nil
+}
+
+func (s *Memory) UnblockUser(_ context.Context, userID, blockedID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blocks[userID][blockedID]; !ok {
+		return ErrBlockNotFound
+	}
+	delete(s.blocks[userID], blockedID)
+	return nil
+}
+
+func (s *Memory) ListBlocks(_ context.Context, userID string) ([]Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Block, 0, len(s.blocks[userID]))
+	for id, at := range s.blocks[userID] {
+		out = append(out, Block{UserID: id, BlockedAt: at})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		return a.BlockedAt.After(b.BlockedAt) || a.BlockedAt.Equal(b.BlockedAt) && a.UserID < b.UserID
+	})
+	return out, nil
+}
+
+func (s *Memory) ReportMessage(_ context.Context, r Report) (Report, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[r.MessageID]
+	if !ok || m.RoomID != r.RoomID {
+		return Report{}, false, ErrMessageNotFound
+	}
+	for _, old := range s.reports {
+		if old.MessageID == r.MessageID && old.Reporter == r.Reporter {
+			return s.report(old), false, nil
+		}
+	}
+	stored := &Report{
+		ID:        s.nextID(),
+		MessageID: m.ID,
+		RoomID:    m.RoomID,
+		Reporter:  r.Reporter,
+		Reason:    r.Reason,
+		Note:      r.Note,
+		Status:    ReportOpen,
+		CreatedAt: now(),
+	}
+	s.reports = append(s.reports, stored)
+	return s.report(stored), true, nil
+}
+
+func (s *Memory) ListReports(_ context.Context, status string, before int64, limit int) ([]Report, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Report, 0)
+	for i := len(s.reports) - 1; i >= 0 && len(out) <= limit; i-- {
+		if r := s.reports[i]; r.ID < before && (status == "" || r.Status == status) {
+			out = append(out, s.report(r))
+		}
+	}
+	out, next := reportPage(out, limit)
+	return out, next, nil
+}
+
+func (s *Memory) ResolveReport(_ context.Context, id int64, status, resolver string) (Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.reports {
+		if r.ID != id {
+			continue
+		}
+		if r.Status != ReportOpen {
+			return s.report(r), ErrReportResolved
+		}
+		at := now()
+		r.Status, r.ResolvedBy, r.ResolvedAt = status, resolver, &at
+		return s.report(r), nil
+	}
+	return Report{}, ErrReportNotFound
+}
+
+// report copies r with the message content, as the SQL backends join it.
+func (s *Memory) report(r *Report) Report {
+	out := *r
+	if m, ok := s.messages[r.MessageID]; ok {
+		out.Content = m.Content
+	}
+	return out
+}
+
+func (s *Memory) ListMembers(_ context.Context, roomID int64) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Member, 0, len(s.members[roomID]))
+	for _, m := range s.members[roomID] {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		return a.JoinedAt.Before(b.JoinedAt) || a.JoinedAt.Equal(b.JoinedAt) && a.UserID < b.UserID
+	})
+	return out, nil
+}
+
+func (s *Memory) ImportRoom(_ context.Context, origin, name string, createdAt time.Time) (Room, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.origins[origin]; ok {
+		r := s.rooms[id]
+		return Room{ID: r.ID, Name: r.Name, CreatedAt: r.CreatedAt}, false, nil
+	}
+	r := &Room{ID: s.nextID(), Name: name, CreatedAt: createdAt.UTC().Truncate(time.Microsecond)}
+	s.rooms[r.ID] = r
+	s.members[r.ID] = map[string]*Member{}
+	s.origins[origin] = r.ID
+	return Room{ID: r.ID, Name: r.Name, CreatedAt: r.CreatedAt}, true, nil
+}
+
+func (s *Memory) ImportMembers(_ context.Context, roomID int64, members []Member) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return 0, ErrRoomNotFound
+	}
+	n := 0
+	for _, m := range members {
+		if _, ok := s.members[roomID][m.UserID]; ok {
+			continue
+		}
+		m.JoinedAt = m.JoinedAt.UTC().Truncate(time.Microsecond)
+		s.members[roomID][m.UserID] = &m
+		n++
+	}
+	return n, nil
+}
+
+func (s *Memory) ImportMessages(_ context.Context, roomID int64, msgs []Message) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.rooms[roomID]
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+	seen := make(map[string]bool, len(s.byRoom[roomID]))
+	for _, m := range s.byRoom[roomID] {
+		seen[m.ClientMsgID] = true
+	}
+	n := 0
+	for _, in := range msgs {
+		if seen[in.ClientMsgID] {
+			continue // already imported
+		}
+		seen[in.ClientMsgID] = true
+		r.LastSeq++
+		m := &Message{
+			ID:          s.nextID(),
+			RoomID:      roomID,
+			Seq:         r.LastSeq,
+			Author:      in.Author,
+			Content:     in.Content,
+			Source:      in.Source,
+			ClientMsgID: in.ClientMsgID,
+			CreatedAt:   in.CreatedAt.UTC().Truncate(time.Microsecond),
+		}
+		s.messages[m.ID] = m
+		s.byRoom[roomID] = append(s.byRoom[roomID], m)
+		if r.LastMessageAt == nil || !m.CreatedAt.Before(*r.LastMessageAt) {
+			at := m.CreatedAt
+			r.LastMessageID, r.LastMessageAt = m.ID, &at
+		}
+		n++
+	}
+	return n, nil
+}
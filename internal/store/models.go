@@ -3,16 +3,87 @@ package store
 import "time"
 
 type Room struct {
+	ID            int64           `json:"id"`
+	Name          string          `json:"name"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	LastSeq       int64           `json:"lastSeq"`
+	LastMessageID int64           `json:"lastMessageId,omitempty"`
+	LastMessageAt *time.Time      `json:"lastMessageAt,omitempty"`
+	LastMessage   *MessagePreview `json:"lastMessage,omitempty"`
+}
+
+// MessagePreview is the trimmed last message shown next to a room in listings.
+type MessagePreview struct {
 	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	Source    string    `json:"source"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+type RoomSort string
+
+const (
+	RoomSortActivity RoomSort = "activity"
+	RoomSortCreated  RoomSort = "created"
+	RoomSortName     RoomSort = "name"
+)
+
+func (s RoomSort) Valid() bool {
+	switch s {
+	case RoomSortActivity, RoomSortCreated, RoomSortName:
+		return true
+	}
+	return false
+}
+
+type ListRoomsParams struct {
+	Sort   RoomSort
+	Cursor string
+	Limit  int
+}
+
+// MessageQuery selects a window of a room's history by seq. At most one of
+// Before, After and Around is used; with none set the newest messages are returned.
+type MessageQuery struct {
+	Before int64
+	After  int64
+	Around int64
+	Limit  int
+}
+
+// MessagePage is a window of messages ordered newest first (seq descending). NextCursor continues
+// towards older messages (pass it as Before), PrevCursor towards newer ones (pass
+// it as After); each is 0 when there is nothing further in that direction.
+type MessagePage struct {
+	Items      []Message
+	NextCursor int64
+	PrevCursor int64
+}
+
 type Message struct {
 	ID          int64     `json:"id"`
 	RoomID      int64     `json:"roomId"`
+	Seq         int64     `json:"seq"`
+	Author      string    `json:"author,omitempty"`
 	Content     string    `json:"content"`
 	Source      string    `json:"source"`
 	ClientMsgID string    `json:"clientMsgId,omitempty"`
 	CreatedAt   time.Time `json:"createdAt"`
 }
+
+type Pin struct {
+	RoomID    int64     `json:"roomId"`
+	MessageID int64     `json:"messageId"`
+	Position  int       `json:"position"`
+	PinnedBy  string    `json:"pinnedBy"`
+	PinnedAt  time.Time `json:"pinnedAt"`
+	Message   *Message  `json:"message,omitempty"`
+}
+
+type Bookmark struct {
+	ID        int64     `json:"id"`
+	MessageID int64     `json:"messageId"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Message   *Message  `json:"message,omitempty"`
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/yngus4862/chat/internal/apperr"
+)
+
+// Review states. A pending review is resolved once, to approved (the message
+// is fine) or rejected (it breaks the rules).
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+var (
+	ErrReviewNotFound = apperr.New(apperr.CodeNotFound, "review not found")
+	ErrReviewResolved = apperr.New(apperr.CodeConflict, "review already resolved")
+)
+
+// Review is a flagged message in the moderation queue. Content is the
+// message as stored, read along with the review.
+type Review struct {
+	ID         int64      `json:"id"`
+	MessageID  int64      `json:"messageId"`
+	RoomID     int64      `json:"roomId"`
+	UserID     string     `json:"userId,omitempty"`
+	Content    string     `json:"content"`
+	Reasons    []string   `json:"reasons"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ReviewedBy string     `json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+}
+
+const reviewSelect = `SELECT v.id, v.message_id, v.room_id, v.user_id, m.content, v.reasons, v.status,
+		v.created_at, COALESCE(v.reviewed_by, ''), v.reviewed_at
+	  FROM message_reviews v JOIN messages m ON m.id = v.message_id`
+
+func (s *Postgres) FlagMessage(ctx context.Context, r Review) (Review, error) {
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO message_reviews(message_id, room_id, user_id, reasons)
+			 SELECT id, room_id, $2, $3 FROM messages WHERE id=$1
+			 ON CONFLICT (message_id) DO NOTHING`,
+		r.MessageID, r.UserID, strings.Join(r.Reasons, ","),
+	)
+	if err != nil {
+		return Review{}, err
+	}
+	r, err = scanReview(s.pool.QueryRow(ctx, reviewSelect+` WHERE v.message_id=$1`, r.MessageID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Review{}, ErrMessageNotFound
+	}
+	return r, err
+}
+
+func (s *Postgres) ListReviews(ctx context.Context, status string, before int64, limit int) ([]Review, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	rows, err := s.pool.Query(ctx,
+		reviewSelect+` WHERE v.id < $1 AND ($2::text = '' OR v.status = $2) ORDER BY v.id DESC LIMIT $3`,
+		before, status, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Review, 0)
+	for rows.Next() {
+		r, err := scanReview(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	out, next := reviewPage(out, limit)
+	return out, next, nil
+}
+
+func (s *Postgres) ResolveReview(ctx context.Context, id int64, status, reviewer string) (Review, error) {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE message_reviews SET status=$2, reviewed_by=$3, reviewed_at=now()
+		  WHERE id=$1 AND status='pending'`,
+		id, status, reviewer,
+	)
+	if err != nil {
+		return Review{}, err
+	}
+	r, err := scanReview(s.pool.QueryRow(ctx, reviewSelect+` WHERE v.id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Review{}, ErrReviewNotFound
+	}
+	if err == nil && tag.RowsAffected() == 0 {
+		return r, ErrReviewResolved
+	}
+	return r, err
+}
+
+func scanReview(row pgx.Row) (Review, error) {
+	var (
+		r       Review
+		reasons string
+	)
+	err := row.Scan(&r.ID, &r.MessageID, &r.RoomID, &r.UserID, &r.Content, &reasons, &r.Status,
+		&r.CreatedAt, &r.ReviewedBy, &r.ReviewedAt)
+	r.Reasons = splitReasons(reasons)
+	return r, err
+}
+
+func splitReasons(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// reviewPage trims a limit+1 read to one page and returns the next cursor.
+func reviewPage(out []Review, limit int) ([]Review, int64) {
+	if len(out) <= limit {
+		return out, 0
+	}
+	out = out[:limit]
+	return out, out[limit-1].ID
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/yngus4862/chat/internal/apperr"
+)
+
+// MaxPinsPerRoom keeps the pinned bar of a room short enough to be useful.
+const MaxPinsPerRoom = 20
+
+var (
+	ErrPinNotFound      = apperr.New(apperr.CodeNotFound, "pin not found")
+	ErrPinLimit         = apperr.New(apperr.CodeConflict, "pin limit reached (<="+strconv.Itoa(MaxPinsPerRoom)+")")
+	ErrPinOrderInvalid  = apperr.Validation("messageIds", "pin order must list every pinned message exactly once")
+	ErrBookmarkNotFound = apperr.New(apperr.CodeNotFound, "bookmark not found")
+)
+
+const joinedMessageColumns = `m.id, m.room_id, m.seq, m.author, m.content, m.source, m.client_msg_id, m.created_at`
+
+func (s *Postgres) ListPins(ctx context.Context, roomID int64) ([]Pin, error) {
+	return listPins(ctx, s.pool, roomID)
+}
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func listPins(ctx context.Context, q querier, roomID int64) ([]Pin, error) {
+	rows, err := q.Query(ctx,
+		`SELECT p.room_id, p.message_id, p.position, p.pinned_by, p.pinned_at, `+joinedMessageColumns+`
+			 FROM room_pins p
+			 JOIN messages m ON m.id = p.message_id
+			 WHERE p.room_id=$1
+			 ORDER BY p.position, p.pinned_at`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Pin, 0)
+	for rows.Next() {
+		var p Pin
+		var m Message
+		if err := rows.Scan(&p.RoomID, &p.MessageID, &p.Position, &p.PinnedBy, &p.PinnedAt,
+			&m.ID, &m.RoomID, &m.Seq, &m.Author, &m.Content, &m.Source, &m.ClientMsgID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.Message = &m
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Postgres) AddPin(ctx context.Context, roomID, messageID int64, pinnedBy string) (Pin, bool, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Pin{}, false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the room lock serializes concurrent pins so the limit holds
+	if err := lockRoom(ctx, tx, roomID); err != nil {
+		return Pin{}, false, err
+	}
+	m, err := scanMessage(tx.QueryRow(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE id=$1 AND room_id=$2`,
+		messageID, roomID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Pin{}, false, ErrMessageNotFound
+	}
+	if err != nil {
+		return Pin{}, false, err
+	}
+
+	p := Pin{RoomID: roomID, MessageID: messageID, Message: &m}
+	err = tx.QueryRow(ctx,
+		`SELECT position, pinned_by, pinned_at FROM room_pins WHERE room_id=$1 AND message_id=$2`,
+		roomID, messageID,
+	).Scan(&p.Position, &p.PinnedBy, &p.PinnedAt)
+	if err == nil {
+		return p, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return Pin{}, false, err
+	}
+
+	var count, maxPos int
+	if err := tx.QueryRow(ctx,
+		`SELECT count(*), COALESCE(max(position), 0) FROM room_pins WHERE room_id=$1`,
+		roomID,
+	).Scan(&count, &maxPos); err != nil {
+		return Pin{}, false, err
+	}
+	if count >= MaxPinsPerRoom {
+		return Pin{}, false, ErrPinLimit
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO room_pins(room_id, message_id, position, pinned_by)
+			 VALUES($1,$2,$3,$4)
+			 RETURNING position, pinned_by, pinned_at`,
+		roomID, messageID, maxPos+1, pinnedBy,
+	).Scan(&p.Position, &p.PinnedBy, &p.PinnedAt)
+	if err != nil {
+		return Pin{}, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Pin{}, false, err
+	}
+	return p, true, nil
+}
+
+func (s *Postgres) RemovePin(ctx context.Context, roomID, messageID int64) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM room_pins WHERE room_id=$1 AND message_id=$2`,
+		roomID, messageID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPinNotFound
+	}
+	return nil
+}
+
+func (s *Postgres) ReorderPins(ctx context.Context, roomID int64, messageIDs []int64) ([]Pin, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := lockRoom(ctx, tx, roomID); err != nil {
+		return nil, err
+	}
+	var pinned int
+	if err := tx.QueryRow(ctx,
+		`SELECT count(*) FROM room_pins WHERE room_id=$1`, roomID,
+	).Scan(&pinned); err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]struct{}, len(messageIDs))
+	for _, id := range messageIDs {
+		seen[id] = struct{}{}
+	}
+	if len(seen) != len(messageIDs) || len(messageIDs) != pinned {
+		return nil, ErrPinOrderInvalid
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE room_pins p SET position = o.pos
+			 FROM unnest($2::bigint[]) WITH ORDINALITY AS o(message_id, pos)
+			 WHERE p.room_id=$1 AND p.message_id = o.message_id`,
+		roomID, messageIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if int(tag.RowsAffected()) != pinned {
+		return nil, ErrPinOrderInvalid
+	}
+	pins, err := listPins(ctx, tx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+func lockRoom(ctx context.Context, tx pgx.Tx, roomID int64) error {
+	var id int64
+	err := tx.QueryRow(ctx, `SELECT id FROM chat_rooms WHERE id=$1 FOR UPDATE`, roomID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRoomNotFound
+	}
+	return err
+}
+
+func (s *Postgres) PutBookmark(ctx context.Context, userID string, messageID int64, note string) (Bookmark, error) {
+	var b Bookmark
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO user_bookmarks(user_id, message_id, note)
+			 SELECT $1, id, $3 FROM messages WHERE id=$2
+			 ON CONFLICT (user_id, message_id)
+			 DO UPDATE SET note = EXCLUDED.note
+			 RETURNING id, message_id, note, created_at`,
+		userID, messageID, note,
+	).Scan(&b.ID, &b.MessageID, &b.Note, &b.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Bookmark{}, ErrMessageNotFound
+	}
+	return b, err
+}
+
+func (s *Postgres) DeleteBookmark(ctx context.Context, userID string, messageID int64) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM user_bookmarks WHERE user_id=$1 AND message_id=$2`,
+		userID, messageID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrBookmarkNotFound
+	}
+	return nil
+}
+
+func (s *Postgres) ListBookmarks(ctx context.Context, userID string, before int64, limit int) ([]Bookmark, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	rows, err := s.pool.Query(ctx,
+		`SELECT b.id, b.message_id, b.note, b.created_at, `+joinedMessageColumns+`
+			 FROM user_bookmarks b
+			 JOIN messages m ON m.id = b.message_id
+			 WHERE b.user_id=$1 AND b.id < $2
+			 ORDER BY b.id DESC
+			 LIMIT $3`,
+		userID, before, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Bookmark, 0, limit+1)
+	for rows.Next() {
+		var b Bookmark
+		var m Message
+		if err := rows.Scan(&b.ID, &b.MessageID, &b.Note, &b.CreatedAt,
+			&m.ID, &m.RoomID, &m.Seq, &m.Author, &m.Content, &m.Source, &m.ClientMsgID, &m.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		b.Message = &m
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(out) > limit {
+		out = out[:limit]
+		return out, out[limit-1].ID, nil
+	}
+	return out, 0, nil
+}
@@ -1,134 +1,307 @@
-package store
-
-import (
-	"context"
-	"database/sql"
-	"errors"
-	"time"
-
-	_ "github.com/lib/pq"
-	"github.com/yngus4862/chat/internal/model"
-)
-
-type Store struct {
-	db *sql.DB
-}
-
-func New(dsn string) (*Store, error) {
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
-	}
-	// conservative pool defaults (small team)
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(30 * time.Minute)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
-		_ = db.Close()
-		return nil, err
-	}
-	return &Store{db: db}, nil
-}
-
-func (s *Store) Close() error { return s.db.Close() }
-
-func (s *Store) Ping(ctx context.Context) error {
-	return s.db.PingContext(ctx)
-}
-
-func (s *Store) EnsureSchema(ctx context.Context) error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS chat_rooms (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);`,
-		`CREATE TABLE IF NOT EXISTS messages (
-			id SERIAL PRIMARY KEY,
-			room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
-			content TEXT NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);`,
-	}
-	for _, q := range queries {
-		if _, err := s.db.ExecContext(ctx, q); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (s *Store) CreateRoom(ctx context.Context, name string) (model.Room, error) {
-	var r model.Room
-	err := s.db.QueryRowContext(
-		ctx,
-		`INSERT INTO chat_rooms(name) VALUES($1)
-		 RETURNING id, name, created_at`,
-		name,
-	).Scan(&r.ID, &r.Name, &r.CreatedAt)
-	return r, err
-}
-
-func (s *Store) ListRooms(ctx context.Context) ([]model.Room, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, name, created_at FROM chat_rooms ORDER BY id`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var out []model.Room
-	for rows.Next() {
-		var r model.Room
-		if err := rows.Scan(&r.ID, &r.Name, &r.CreatedAt); err != nil {
-			return nil, err
-		}
-		out = append(out, r)
-	}
-	return out, rows.Err()
-}
-
-func (s *Store) CreateMessage(ctx context.Context, roomID int64, content string) (model.Message, error) {
-	if roomID <= 0 {
-		return model.Message{}, errors.New("invalid roomID")
-	}
-	var m model.Message
-	err := s.db.QueryRowContext(
-		ctx,
-		`INSERT INTO messages(room_id, content) VALUES($1, $2)
-		 RETURNING id, room_id, content, created_at`,
-		roomID, content,
-	).Scan(&m.ID, &m.RoomID, &m.Content, &m.CreatedAt)
-	return m, err
-}
-
-func (s *Store) ListMessages(ctx context.Context, roomID int64, limit int) ([]model.Message, error) {
-	if limit <= 0 || limit > 200 {
-		limit = 50
-	}
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT id, room_id, content, created_at
-		 FROM messages
-		 WHERE room_id=$1
-		 ORDER BY id DESC
-		 LIMIT $2`,
-		roomID, limit,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var out []model.Message
-	for rows.Next() {
-		var m model.Message
-		if err := rows.Scan(&m.ID, &m.RoomID, &m.Content, &m.CreatedAt); err != nil {
-			return nil, err
-		}
-		out = append(out, m)
-	}
-	return out, rows.Err()
-}
\ No newline at end of file
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/migrate"
+	"github.com/yngus4862/chat/internal/tracing"
+	"github.com/yngus4862/chat/migrations"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Postgres is the pgx-backed Store.
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+// EnsureSchema brings the database up to the newest embedded migration (see
+// internal/migrate). It is what DB_MIGRATE=auto runs on startup.
+func (s *Postgres) EnsureSchema(ctx context.Context) error {
+	r, err := migrate.New(s.pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+	applied, err := r.Up(ctx)
+	if len(applied) > 0 {
+		logging.FromContext(ctx).Info("migrations applied", "versions", applied)
+	}
+	return err
+}
+
+func (s *Postgres) Ping(ctx context.Context) error {
+	if s == nil || s.pool == nil {
+		return errors.New("store not initialized")
+	}
+	return s.pool.Ping(ctx)
+}
+
+func (s *Postgres) CreateRoom(ctx context.Context, name, createdBy string) (Room, error) {
+	var r Room
+	err := s.pool.QueryRow(ctx,
+		`WITH room AS (
+			INSERT INTO chat_rooms(name) VALUES($1)
+			 RETURNING id, name, created_at
+		 ), member AS (
+			INSERT INTO room_members(room_id, user_id, is_admin)
+			 SELECT id, $2, true FROM room WHERE $2 <> ''
+		 )
+		 SELECT id, name, created_at FROM room`,
+		name, createdBy,
+	).Scan(&r.ID, &r.Name, &r.CreatedAt)
+	return r, err
+}
+
+func (s *Postgres) RoomExists(ctx context.Context, roomID int64) (bool, error) {
+	var ok bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM chat_rooms WHERE id=$1)`, roomID).Scan(&ok)
+	return ok, err
+}
+
+func (s *Postgres) IsRoomAdmin(ctx context.Context, roomID int64, userID string) (bool, error) {
+	var ok bool
+	err := s.pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id=$1 AND user_id=$2 AND is_admin)`,
+		roomID, userID,
+	).Scan(&ok)
+	return ok, err
+}
+
+// roomSelect joins the denormalized last message so listings can show a preview
+// without a per-room lookup.
+const roomSelect = `SELECT r.id, r.name, r.created_at, r.last_seq, r.last_message_id, r.last_message_at,
+		m.id, left(m.content, 200), m.source, m.created_at
+	 FROM chat_rooms r
+	 LEFT JOIN messages m ON m.id = r.last_message_id`
+
+func (s *Postgres) ListRooms(ctx context.Context, p ListRoomsParams) ([]Room, string, error) {
+	sort, limit := roomListParams(p)
+
+	var (
+		where string
+		order string
+		args  []any
+	)
+	switch sort {
+	case RoomSortCreated:
+		order = `r.id DESC`
+	case RoomSortName:
+		order = `r.name ASC, r.id ASC`
+	default:
+		order = `COALESCE(r.last_message_at, r.created_at) DESC, r.id DESC`
+	}
+	if p.Cursor != "" {
+		cur, err := decodeRoomCursor(p.Cursor, sort)
+		if err != nil {
+			return nil, "", err
+		}
+		switch sort {
+		case RoomSortCreated:
+			where = `WHERE r.id < $1`
+			args = append(args, cur.ID)
+		case RoomSortName:
+			where = `WHERE (r.name, r.id) > ($1, $2)`
+			args = append(args, cur.Key, cur.ID)
+		default:
+			at, err := parseMicros(cur.Key)
+			if err != nil {
+				return nil, "", err
+			}
+			where = `WHERE (COALESCE(r.last_message_at, r.created_at), r.id) < ($1, $2)`
+			args = append(args, at, cur.ID)
+		}
+	}
+	args = append(args, limit+1)
+	q := roomSelect + ` ` + where + ` ORDER BY ` + order + ` LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := s.pool.Query(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	out := make([]Room, 0, limit+1)
+	for rows.Next() {
+		r, err := scanRoom(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	out, next := roomPage(out, sort, limit)
+	return out, next, nil
+}
+
+func scanRoom(row pgx.Row) (Room, error) {
+	var (
+		r         Room
+		lastID    *int64
+		lastAt    *time.Time
+		pvID      *int64
+		pvContent *string
+		pvSource  *string
+		pvAt      *time.Time
+	)
+	if err := row.Scan(&r.ID, &r.Name, &r.CreatedAt, &r.LastSeq, &lastID, &lastAt, &pvID, &pvContent, &pvSource, &pvAt); err != nil {
+		return r, err
+	}
+	if lastID != nil {
+		r.LastMessageID = *lastID
+	}
+	r.LastMessageAt = lastAt
+	if pvID != nil {
+		r.LastMessage = &MessagePreview{ID: *pvID, Content: *pvContent, Source: *pvSource, CreatedAt: *pvAt}
+	}
+	return r, nil
+}
+
+func (s *Postgres) ListMessages(ctx context.Context, roomID int64, q MessageQuery) (MessagePage, error) {
+	return pageMessages(ctx, s, roomID, q)
+}
+
+func (s *Postgres) MessagesAfter(ctx context.Context, roomID, afterSeq int64, limit int) ([]Message, bool, error) {
+	return s.messageWindow(ctx, roomID, ">", afterSeq, afterLimit(limit))
+}
+
+func (s *Postgres) messageWindow(ctx context.Context, roomID int64, op string, pivot int64, limit int) ([]Message, bool, error) {
+	order := "DESC"
+	if op == ">" {
+		order = "ASC"
+	}
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+messageColumns+`
+			 FROM messages
+			 WHERE room_id=$1 AND seq `+op+` $2
+			 ORDER BY seq `+order+`
+			 LIMIT $3`,
+		roomID, pivot, limit+1,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	out := make([]Message, 0, limit+1)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
+}
+
+func (s *Postgres) messageExists(ctx context.Context, roomID int64, op string, pivot int64) (bool, error) {
+	var ok bool
+	err := s.pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM messages WHERE room_id=$1 AND seq `+op+` $2)`,
+		roomID, pivot,
+	).Scan(&ok)
+	return ok, err
+}
+
+// CreateMessage locks the room row while the sequence is bumped, so within a
+// room seq order is commit order.
+func (s *Postgres) CreateMessage(ctx context.Context, roomID int64, author, content, source, clientMsgID string) (m Message, err error) {
+	ctx, span := tracing.Start(ctx, "store.CreateMessage", trace.WithAttributes(tracing.RoomID(roomID)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if clientMsgID == "" {
+		clientMsgID = newClientMsgID()
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var seq int64
+	err = tx.QueryRow(ctx,
+		`UPDATE chat_rooms SET last_seq = last_seq + 1 WHERE id=$1 RETURNING last_seq`,
+		roomID,
+	).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Message{}, ErrRoomNotFound
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Another request with the same clientMsgID may have committed while we
+	// waited for the room lock; it is visible now.
+	m, err = scanMessage(tx.QueryRow(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE room_id=$1 AND client_msg_id=$2`,
+		roomID, clientMsgID,
+	))
+	if err == nil {
+		deduplicated(ctx, m)
+		return m, nil // rollback releases the sequence bump
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return Message{}, err
+	}
+
+	m, err = scanMessage(tx.QueryRow(ctx,
+		`INSERT INTO messages(room_id, seq, author, content, source, client_msg_id)
+			 VALUES($1,$2,$3,$4,$5,$6)
+			 RETURNING `+messageColumns,
+		roomID, seq, author, content, source, clientMsgID,
+	))
+	if err != nil {
+		return Message{}, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE chat_rooms SET last_message_id=$2, last_message_at=$3 WHERE id=$1`,
+		roomID, m.ID, m.CreatedAt,
+	); err != nil {
+		return Message{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Message{}, err
+	}
+	stored(ctx, m)
+	return m, nil
+}
+
+func (s *Postgres) GetMessage(ctx context.Context, id int64) (Message, error) {
+	m, err := scanMessage(s.pool.QueryRow(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE id=$1`, id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Message{}, ErrMessageNotFound
+	}
+	return m, err
+}
+
+const messageColumns = `id, room_id, seq, author, content, source, client_msg_id, created_at`
+
+func scanMessage(row pgx.Row) (Message, error) {
+	var m Message
+	err := row.Scan(&m.ID, &m.RoomID, &m.Seq, &m.Author, &m.Content, &m.Source, &m.ClientMsgID, &m.CreatedAt)
+	return m, err
+}
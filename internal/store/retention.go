@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RoomRetention is a room's retention policy. Days nil follows the global
+// default, 0 keeps messages forever. A room under legal hold is never purged,
+// whatever its retention.
+type RoomRetention struct {
+	RoomID    int64 `json:"roomId"`
+	Days      *int  `json:"days"`
+	LegalHold bool  `json:"legalHold"`
+}
+
+// EffectiveDays resolves Days against the global default.
+func (r RoomRetention) EffectiveDays(defaultDays int) int {
+	if r.Days != nil {
+		return *r.Days
+	}
+	return defaultDays
+}
+
+// PurgeRecord is one entry of the purge audit trail: deleted messages of the
+// room older than cutoff were removed at PurgedAt.
+type PurgeRecord struct {
+	ID       int64     `json:"id"`
+	RoomID   int64     `json:"roomId"`
+	Cutoff   time.Time `json:"cutoff"`
+	Deleted  int64     `json:"deleted"`
+	PurgedAt time.Time `json:"purgedAt"`
+}
+
+func (s *Postgres) ListRetention(ctx context.Context) ([]RoomRetention, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, retention_days, legal_hold FROM chat_rooms ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]RoomRetention, 0)
+	for rows.Next() {
+		var r RoomRetention
+		if err := rows.Scan(&r.RoomID, &r.Days, &r.LegalHold); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *Postgres) GetRetention(ctx context.Context, roomID int64) (RoomRetention, error) {
+	r := RoomRetention{RoomID: roomID}
+	err := s.pool.QueryRow(ctx,
+		`SELECT retention_days, legal_hold FROM chat_rooms WHERE id=$1`, roomID,
+	).Scan(&r.Days, &r.LegalHold)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return RoomRetention{}, ErrRoomNotFound
+	}
+	return r, err
+}
+
+func (s *Postgres) SetRetention(ctx context.Context, p RoomRetention) (RoomRetention, error) {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE chat_rooms SET retention_days=$2, legal_hold=$3 WHERE id=$1`,
+		p.RoomID, p.Days, p.LegalHold,
+	)
+	if err != nil {
+		return RoomRetention{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return RoomRetention{}, ErrRoomNotFound
+	}
+	return p, nil
+}
+
+// notOpenReported keeps messages m with an open report out of a purge, so
+// the admins still see what was reported. Once the report is closed the next
+// purge takes the message and its reports; the closing stays in the audit log.
+const notOpenReported = `NOT EXISTS (SELECT 1 FROM message_reports mr WHERE mr.message_id = m.id AND mr.status = 'open')`
+
+func (s *Postgres) CountMessagesBefore(ctx context.Context, roomID int64, before time.Time) (int64, error) {
+	var n int64
+	err := s.pool.QueryRow(ctx,
+		`SELECT count(*) FROM messages m WHERE m.room_id=$1 AND m.created_at < $2 AND `+notOpenReported,
+		roomID, before,
+	).Scan(&n)
+	return n, err
+}
+
+// PurgeMessages deletes one batch in its own statement, so row locks are held
+// only for that batch. The legal hold is checked in the same statement; a hold
+// placed while a purge runs stops it at the next batch. The same statement
+// repoints the room's last message when the batch took it; last_message_at is
+// left as is, so a purge does not reorder the room list.
+func (s *Postgres) PurgeMessages(ctx context.Context, roomID int64, before time.Time, limit int) (int64, error) {
+	var n int64
+	err := s.pool.QueryRow(ctx,
+		`WITH gone AS (
+			DELETE FROM messages WHERE id IN (
+				SELECT m.id FROM messages m
+				 JOIN chat_rooms r ON r.id = m.room_id
+				 WHERE m.room_id=$1 AND m.created_at < $2 AND NOT r.legal_hold AND `+notOpenReported+`
+				 ORDER BY m.created_at, m.id
+				 LIMIT $3
+			) RETURNING id
+		 ), room AS (
+			UPDATE chat_rooms SET last_message_id = (
+				SELECT max(m.id) FROM messages m
+				 WHERE m.room_id=$1 AND m.id NOT IN (SELECT id FROM gone)
+			)
+			 WHERE id=$1 AND last_message_id IN (SELECT id FROM gone)
+		 )
+		 SELECT count(*) FROM gone`,
+		roomID, before, limit,
+	).Scan(&n)
+	return n, err
+}
+
+func (s *Postgres) RecordPurge(ctx context.Context, p PurgeRecord) (PurgeRecord, error) {
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO retention_purges(room_id, cutoff, deleted) VALUES($1, $2, $3)
+		 RETURNING id, purged_at`,
+		p.RoomID, p.Cutoff, p.Deleted,
+	).Scan(&p.ID, &p.PurgedAt)
+	return p, err
+}
+
+func (s *Postgres) ListPurges(ctx context.Context, roomID int64, limit int) ([]PurgeRecord, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, room_id, cutoff, deleted, purged_at
+		   FROM retention_purges
+		  WHERE $1 = 0 OR room_id = $1
+		  ORDER BY id DESC
+		  LIMIT $2`,
+		roomID, purgeLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]PurgeRecord, 0)
+	for rows.Next() {
+		var p PurgeRecord
+		if err := rows.Scan(&p.ID, &p.RoomID, &p.Cutoff, &p.Deleted, &p.PurgedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func purgeLimit(limit int) int {
+	if limit <= 0 || limit > 500 {
+		return 100
+	}
+	return limit
+}
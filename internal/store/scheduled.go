@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/yngus4862/chat/internal/apperr"
+)
+
+const (
+	ScheduledKindMessage  = "message"
+	ScheduledKindReminder = "reminder"
+
+	ScheduledPending    = "pending"
+	ScheduledProcessing = "processing"
+	ScheduledSent       = "sent"
+	ScheduledFailed     = "failed"
+)
+
+var ErrScheduledNotFound = apperr.New(apperr.CodeNotFound, "scheduled item not found")
+
+// ScheduledMessage is either a message to post later (Kind "message") or a
+// reminder about an existing message (Kind "reminder", Content holds the note).
+type ScheduledMessage struct {
+	ID            int64     `json:"id"`
+	Kind          string    `json:"kind"`
+	UserID        string    `json:"userId"`
+	RoomID        int64     `json:"roomId"`
+	MessageID     int64     `json:"messageId,omitempty"`
+	Content       string    `json:"content,omitempty"`
+	SendAt        time.Time `json:"sendAt"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	SentMessageID int64     `json:"sentMessageId,omitempty"`
+	LastError     string    `json:"lastError,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+const scheduledColumns = `id, kind, user_id, room_id, message_id, content, send_at, status,
+	attempts, sent_message_id, last_error, created_at`
+
+func scanScheduled(row pgx.Row) (ScheduledMessage, error) {
+	var (
+		sm        ScheduledMessage
+		messageID *int64
+		sentID    *int64
+		lastError *string
+	)
+	err := row.Scan(&sm.ID, &sm.Kind, &sm.UserID, &sm.RoomID, &messageID, &sm.Content, &sm.SendAt,
+		&sm.Status, &sm.Attempts, &sentID, &lastError, &sm.CreatedAt)
+	if messageID != nil {
+		sm.MessageID = *messageID
+	}
+	if sentID != nil {
+		sm.SentMessageID = *sentID
+	}
+	if lastError != nil {
+		sm.LastError = *lastError
+	}
+	return sm, err
+}
+
+// ScheduleMessage queues content to be posted to the room at sendAt.
+func (s *Postgres) ScheduleMessage(ctx context.Context, userID string, roomID int64, content string, sendAt time.Time) (ScheduledMessage, error) {
+	sm, err := scanScheduled(s.pool.QueryRow(ctx,
+		`INSERT INTO scheduled_messages(kind, user_id, room_id, content, send_at)
+			 SELECT 'message', $1, id, $3, $4 FROM chat_rooms WHERE id=$2
+			 RETURNING `+scheduledColumns,
+		userID, roomID, content, sendAt,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ScheduledMessage{}, ErrRoomNotFound
+	}
+	return sm, err
+}
+
+// ScheduleReminder queues a personal reminder about a message at remindAt.
+func (s *Postgres) ScheduleReminder(ctx context.Context, userID string, messageID int64, note string, remindAt time.Time) (ScheduledMessage, error) {
+	sm, err := scanScheduled(s.pool.QueryRow(ctx,
+		`INSERT INTO scheduled_messages(kind, user_id, room_id, message_id, content, send_at)
+			 SELECT 'reminder', $1, room_id, id, $3, $4 FROM messages WHERE id=$2
+			 RETURNING `+scheduledColumns,
+		userID, messageID, note, remindAt,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ScheduledMessage{}, ErrMessageNotFound
+	}
+	return sm, err
+}
+
+// ListScheduled returns the user's scheduled items that have not been sent yet,
+// soonest first. Failed items stay listed until the user deletes them.
+func (s *Postgres) ListScheduled(ctx context.Context, userID string) ([]ScheduledMessage, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+scheduledColumns+`
+			 FROM scheduled_messages
+			 WHERE user_id=$1 AND status <> 'sent'
+			 ORDER BY send_at, id
+			 LIMIT 500`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ScheduledMessage, 0)
+	for rows.Next() {
+		sm, err := scanScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sm)
+	}
+	return out, rows.Err()
+}
+
+// CancelScheduled deletes a pending or failed item of the user. Items already
+// claimed by the scheduler can no longer be cancelled.
+func (s *Postgres) CancelScheduled(ctx context.Context, userID string, id int64) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM scheduled_messages
+			 WHERE id=$1 AND user_id=$2 AND status IN ('pending', 'failed')`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrScheduledNotFound
+	}
+	return nil
+}
+
+// ClaimDueScheduled leases up to limit due items to owner. SKIP LOCKED lets
+// several chatd instances claim concurrently without handing out the same row;
+// items whose lease expired (owner crashed) become claimable again.
+func (s *Postgres) ClaimDueScheduled(ctx context.Context, owner string, lease time.Duration, limit int) ([]ScheduledMessage, error) {
+	rows, err := s.pool.Query(ctx,
+		`UPDATE scheduled_messages
+			 SET status='processing', locked_by=$1, locked_until=now() + make_interval(secs => $2),
+			     attempts=attempts+1, updated_at=now()
+			 WHERE id IN (
+				SELECT id FROM scheduled_messages
+				 WHERE (status='pending' AND send_at <= now())
+				    OR (status='processing' AND locked_until < now())
+				 ORDER BY send_at
+				 LIMIT $3
+				 FOR UPDATE SKIP LOCKED
+			 )
+			 RETURNING `+scheduledColumns,
+		owner, lease.Seconds(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ScheduledMessage, 0, limit)
+	for rows.Next() {
+		sm, err := scanScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sm)
+	}
+	return out, rows.Err()
+}
+
+func (s *Postgres) MarkScheduledSent(ctx context.Context, id int64, owner string, sentMessageID int64) error {
+	var sent *int64
+	if sentMessageID > 0 {
+		sent = &sentMessageID
+	}
+	_, err := s.pool.Exec(ctx,
+		`UPDATE scheduled_messages
+			 SET status='sent', sent_message_id=$3, locked_by=NULL, locked_until=NULL, updated_at=now()
+			 WHERE id=$1 AND locked_by=$2`,
+		id, owner, sent,
+	)
+	return err
+}
+
+// MarkScheduledFailed records a failed attempt. Unless final, the item stays
+// leased and is retried once the lease runs out.
+func (s *Postgres) MarkScheduledFailed(ctx context.Context, id int64, owner, reason string, final bool) error {
+	if final {
+		_, err := s.pool.Exec(ctx,
+			`UPDATE scheduled_messages
+				 SET status='failed', last_error=$3, locked_by=NULL, locked_until=NULL, updated_at=now()
+				 WHERE id=$1 AND locked_by=$2`,
+			id, owner, reason,
+		)
+		return err
+	}
+	_, err := s.pool.Exec(ctx,
+		`UPDATE scheduled_messages SET last_error=$3, updated_at=now() WHERE id=$1 AND locked_by=$2`,
+		id, owner, reason,
+	)
+	return err
+}
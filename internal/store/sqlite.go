@@ -0,0 +1,1450 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite is a Store in a single SQLite file, for single-binary deployments
+// without Postgres. It holds one connection, so writes are serialized the way
+// the Postgres backend serializes them with row locks. Times are stored as
+// unix microseconds.
+type SQLite struct {
+	db *sql.DB
+}
+
+// sqliteSchema mirrors migrations/ as of 0012. When a migration changes the
+// model, bump sqliteVersion, add the changes here and, for tables that already
+// exist, the ALTER statements to sqliteUpgrades.
+const (
+	sqliteVersion = 12
+	sqliteSchema  = `
+CREATE TABLE IF NOT EXISTS chat_rooms (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  last_seq INTEGER NOT NULL DEFAULT 0,
+  last_message_id INTEGER,
+  last_message_at INTEGER,
+  retention_days INTEGER CHECK (retention_days >= 0),
+  legal_hold INTEGER NOT NULL DEFAULT 0,
+  origin TEXT
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  seq INTEGER NOT NULL,
+  author TEXT NOT NULL DEFAULT '',
+  content TEXT NOT NULL,
+  source TEXT NOT NULL DEFAULT 'rest',
+  client_msg_id TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  UNIQUE (room_id, client_msg_id),
+  UNIQUE (room_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+  room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  user_id TEXT NOT NULL,
+  is_admin INTEGER NOT NULL DEFAULT 0,
+  joined_at INTEGER NOT NULL,
+  PRIMARY KEY (room_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS room_pins (
+  room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  position INTEGER NOT NULL,
+  pinned_by TEXT NOT NULL,
+  pinned_at INTEGER NOT NULL,
+  PRIMARY KEY (room_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_bookmarks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id TEXT NOT NULL,
+  message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  note TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  UNIQUE (user_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  kind TEXT NOT NULL DEFAULT 'message',
+  user_id TEXT NOT NULL,
+  room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+  content TEXT NOT NULL DEFAULT '',
+  send_at INTEGER NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  locked_by TEXT,
+  locked_until INTEGER,
+  sent_message_id INTEGER,
+  last_error TEXT,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS retention_purges (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  room_id INTEGER NOT NULL,
+  cutoff INTEGER NOT NULL,
+  deleted INTEGER NOT NULL,
+  purged_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  at INTEGER NOT NULL,
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target_type TEXT NOT NULL DEFAULT '',
+  target_id TEXT NOT NULL DEFAULT '',
+  request_id TEXT NOT NULL DEFAULT '',
+  remote_addr TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  details TEXT
+);
+
+CREATE TABLE IF NOT EXISTS message_reviews (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  message_id INTEGER NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+  room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  user_id TEXT NOT NULL DEFAULT '',
+  reasons TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+  created_at INTEGER NOT NULL,
+  reviewed_by TEXT,
+  reviewed_at INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS user_blocks (
+  user_id TEXT NOT NULL,
+  blocked_id TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  PRIMARY KEY (user_id, blocked_id),
+  CHECK (user_id <> blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS message_reports (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  reporter TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved', 'dismissed')),
+  created_at INTEGER NOT NULL,
+  resolved_by TEXT,
+  resolved_at INTEGER,
+  UNIQUE (message_id, reporter)
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_id_created_at ON messages(room_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_user_bookmarks_user_id_id ON user_bookmarks(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(status, send_at);
+CREATE INDEX IF NOT EXISTS idx_retention_purges_room_id_id ON retention_purges(room_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_rooms_origin ON chat_rooms(origin);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action_id ON audit_events(action, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_type, target_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_at ON audit_events(at);
+CREATE INDEX IF NOT EXISTS idx_message_reviews_status_id ON message_reviews(status, id);
+CREATE INDEX IF NOT EXISTS idx_message_reports_status_id ON message_reports(status, id);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END;
+`
+)
+
+// sqliteUpgrades brings an existing database from version-1 to version. New
+// tables need no entry; sqliteSchema creates them.
+var sqliteUpgrades = map[int]string{
+	7: `ALTER TABLE chat_rooms ADD COLUMN retention_days INTEGER CHECK (retention_days >= 0);
+		ALTER TABLE chat_rooms ADD COLUMN legal_hold INTEGER NOT NULL DEFAULT 0;`,
+	8:  `ALTER TABLE chat_rooms ADD COLUMN origin TEXT;`,
+	12: `ALTER TABLE messages ADD COLUMN author TEXT NOT NULL DEFAULT '';`,
+}
+
+// OpenSQLite opens (creating if needed) the database at path; ":memory:" gives
+// a private in-memory database.
+func OpenSQLite(path string) (*SQLite, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	if path != ":memory:" {
+		dsn += "&_pragma=journal_mode(WAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if version > sqliteVersion {
+		_ = db.Close()
+		return nil, errors.New("sqlite database was created by a newer chatd (schema " + strconv.Itoa(version) + ")")
+	}
+	if version > 0 {
+		for v := version + 1; v <= sqliteVersion; v++ {
+			if _, err := db.Exec(sqliteUpgrades[v]); err != nil {
+				_ = db.Close()
+				return nil, err
+			}
+		}
+	}
+	if _, err := db.Exec(sqliteSchema + `PRAGMA user_version = ` + strconv.Itoa(sqliteVersion) + `;`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+func (s *SQLite) Close() error { return s.db.Close() }
+
+func (s *SQLite) Ping(ctx context.Context) error { return s.db.PingContext(ctx) }
+
+func micros(t time.Time) int64 { return t.UnixMicro() }
+
+func fromMicros(v int64) time.Time { return time.UnixMicro(v).UTC() }
+
+func (s *SQLite) CreateRoom(ctx context.Context, name, createdBy string) (Room, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Room{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	r := Room{Name: name, CreatedAt: now()}
+	res, err := tx.ExecContext(ctx, `INSERT INTO chat_rooms(name, created_at) VALUES(?, ?)`, name, micros(r.CreatedAt))
+	if err != nil {
+		return Room{}, err
+	}
+	if r.ID, err = res.LastInsertId(); err != nil {
+		return Room{}, err
+	}
+	if createdBy != "" {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO room_members(room_id, user_id, is_admin, joined_at) VALUES(?, ?, 1, ?)`,
+			r.ID, createdBy, micros(r.CreatedAt),
+		); err != nil {
+			return Room{}, err
+		}
+	}
+	return r, tx.Commit()
+}
+
+func (s *SQLite) RoomExists(ctx context.Context, roomID int64) (bool, error) {
+	var ok bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM chat_rooms WHERE id=?)`, roomID).Scan(&ok)
+	return ok, err
+}
+
+func (s *SQLite) IsRoomAdmin(ctx context.Context, roomID int64, userID string) (bool, error) {
+	var ok bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id=? AND user_id=? AND is_admin)`,
+		roomID, userID,
+	).Scan(&ok)
+	return ok, err
+}
+
+const sqliteRoomSelect = `SELECT r.id, r.name, r.created_at, r.last_seq, r.last_message_id, r.last_message_at,
+		m.id, substr(m.content, 1, 200), m.source, m.created_at
+	 FROM chat_rooms r
+	 LEFT JOIN messages m ON m.id = r.last_message_id`
+
+func (s *SQLite) ListRooms(ctx context.Context, p ListRoomsParams) ([]Room, string, error) {
+	sort, limit := roomListParams(p)
+
+	var (
+		where string
+		order string
+		args  []any
+	)
+	switch sort {
+	case RoomSortCreated:
+		order = `r.id DESC`
+	case RoomSortName:
+		order = `r.name ASC, r.id ASC`
+	default:
+		order = `COALESCE(r.last_message_at, r.created_at) DESC, r.id DESC`
+	}
+	if p.Cursor != "" {
+		cur, err := decodeRoomCursor(p.Cursor, sort)
+		if err != nil {
+			return nil, "", err
+		}
+		switch sort {
+		case RoomSortCreated:
+			where = `WHERE r.id < ?`
+			args = append(args, cur.ID)
+		case RoomSortName:
+			where = `WHERE (r.name, r.id) > (?, ?)`
+			args = append(args, cur.Key, cur.ID)
+		default:
+			at, err := parseMicros(cur.Key)
+			if err != nil {
+				return nil, "", err
+			}
+			where = `WHERE (COALESCE(r.last_message_at, r.created_at), r.id) < (?, ?)`
+			args = append(args, micros(at), cur.ID)
+		}
+	}
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, sqliteRoomSelect+` `+where+` ORDER BY `+order+` LIMIT ?`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	out := make([]Room, 0, limit+1)
+	for rows.Next() {
+		r, err := scanSQLiteRoom(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	out, next := roomPage(out, sort, limit)
+	return out, next, nil
+}
+
+func scanSQLiteRoom(row sqlScanner) (Room, error) {
+	var (
+		r                   Room
+		created             int64
+		lastID, lastAt      *int64
+		pvID, pvAt          *int64
+		pvContent, pvSource *string
+	)
+	if err := row.Scan(&r.ID, &r.Name, &created, &r.LastSeq, &lastID, &lastAt, &pvID, &pvContent, &pvSource, &pvAt); err != nil {
+		return r, err
+	}
+	r.CreatedAt = fromMicros(created)
+	if lastID != nil {
+		r.LastMessageID = *lastID
+	}
+	if lastAt != nil {
+		at := fromMicros(*lastAt)
+		r.LastMessageAt = &at
+	}
+	if pvID != nil {
+		r.LastMessage = &MessagePreview{ID: *pvID, Content: *pvContent, Source: *pvSource, CreatedAt: fromMicros(*pvAt)}
+	}
+	return r, nil
+}
+
+func (s *SQLite) GetRoom(ctx context.Context, roomID int64) (Room, error) {
+	r, err := scanSQLiteRoom(s.db.QueryRowContext(ctx, sqliteRoomSelect+` WHERE r.id=?`, roomID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Room{}, ErrRoomNotFound
+	}
+	return r, err
+}
+
+const sqliteMessageColumns = `id, room_id, seq, author, content, source, client_msg_id, created_at`
+
+// sqliteQuerier is satisfied by both *sql.DB and *sql.Tx.
+type sqliteQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type sqlScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSQLiteMessage(row sqlScanner) (Message, error) {
+	var (
+		m       Message
+		created int64
+	)
+	err := row.Scan(&m.ID, &m.RoomID, &m.Seq, &m.Author, &m.Content, &m.Source, &m.ClientMsgID, &created)
+	m.CreatedAt = fromMicros(created)
+	return m, err
+}
+
+func (s *SQLite) CreateMessage(ctx context.Context, roomID int64, author, content, source, clientMsgID string) (Message, error) {
+	if clientMsgID == "" {
+		clientMsgID = newClientMsgID()
+	}
+	tx, err := s.db.BeginTx(ctx, nil) // _txlock=immediate: takes the write lock up front
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	m, err := scanSQLiteMessage(tx.QueryRowContext(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE room_id=? AND client_msg_id=?`,
+		roomID, clientMsgID,
+	))
+	if err == nil {
+		deduplicated(ctx, m)
+		return m, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Message{}, err
+	}
+
+	var seq int64
+	err = tx.QueryRowContext(ctx,
+		`UPDATE chat_rooms SET last_seq = last_seq + 1 WHERE id=? RETURNING last_seq`,
+		roomID,
+	).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrRoomNotFound
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	m, err = scanSQLiteMessage(tx.QueryRowContext(ctx,
+		`INSERT INTO messages(room_id, seq, author, content, source, client_msg_id, created_at)
+			 VALUES(?, ?, ?, ?, ?, ?, ?)
+			 RETURNING `+sqliteMessageColumns,
+		roomID, seq, author, content, source, clientMsgID, micros(now()),
+	))
+	if err != nil {
+		return Message{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE chat_rooms SET last_message_id=?, last_message_at=? WHERE id=?`,
+		m.ID, micros(m.CreatedAt), roomID,
+	); err != nil {
+		return Message{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Message{}, err
+	}
+	stored(ctx, m)
+	return m, nil
+}
+
+func (s *SQLite) GetMessage(ctx context.Context, id int64) (Message, error) {
+	m, err := scanSQLiteMessage(s.db.QueryRowContext(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE id=?`, id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrMessageNotFound
+	}
+	return m, err
+}
+
+func (s *SQLite) ListMessages(ctx context.Context, roomID int64, q MessageQuery) (MessagePage, error) {
+	return pageMessages(ctx, s, roomID, q)
+}
+
+func (s *SQLite) MessagesAfter(ctx context.Context, roomID, afterSeq int64, limit int) ([]Message, bool, error) {
+	return s.messageWindow(ctx, roomID, ">", afterSeq, afterLimit(limit))
+}
+
+func (s *SQLite) messageWindow(ctx context.Context, roomID int64, op string, pivot int64, limit int) ([]Message, bool, error) {
+	order := "DESC"
+	if op == ">" {
+		order = "ASC"
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+sqliteMessageColumns+`
+			 FROM messages
+			 WHERE room_id=? AND seq `+op+` ?
+			 ORDER BY seq `+order+`
+			 LIMIT ?`,
+		roomID, pivot, limit+1,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	out := make([]Message, 0, limit+1)
+	for rows.Next() {
+		m, err := scanSQLiteMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
+}
+
+func (s *SQLite) messageExists(ctx context.Context, roomID int64, op string, pivot int64) (bool, error) {
+	var ok bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM messages WHERE room_id=? AND seq `+op+` ?)`,
+		roomID, pivot,
+	).Scan(&ok)
+	return ok, err
+}
+
+const sqliteJoinedMessageColumns = `m.id, m.room_id, m.seq, m.author, m.content, m.source, m.client_msg_id, m.created_at`
+
+func (s *SQLite) ListPins(ctx context.Context, roomID int64) ([]Pin, error) {
+	return listSQLitePins(ctx, s.db, roomID)
+}
+
+func listSQLitePins(ctx context.Context, q sqliteQuerier, roomID int64) ([]Pin, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT p.room_id, p.message_id, p.position, p.pinned_by, p.pinned_at, `+sqliteJoinedMessageColumns+`
+			 FROM room_pins p
+			 JOIN messages m ON m.id = p.message_id
+			 WHERE p.room_id=?
+			 ORDER BY p.position, p.pinned_at`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Pin, 0)
+	for rows.Next() {
+		var (
+			p                 Pin
+			m                 Message
+			pinnedAt, created int64
+		)
+		if err := rows.Scan(&p.RoomID, &p.MessageID, &p.Position, &p.PinnedBy, &pinnedAt,
+			&m.ID, &m.RoomID, &m.Seq, &m.Author, &m.Content, &m.Source, &m.ClientMsgID, &created); err != nil {
+			return nil, err
+		}
+		p.PinnedAt, m.CreatedAt = fromMicros(pinnedAt), fromMicros(created)
+		p.Message = &m
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) AddPin(ctx context.Context, roomID, messageID int64, pinnedBy string) (Pin, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Pin{}, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := sqliteRoomExists(ctx, tx, roomID); err != nil {
+		return Pin{}, false, err
+	}
+	m, err := scanSQLiteMessage(tx.QueryRowContext(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE id=? AND room_id=?`,
+		messageID, roomID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Pin{}, false, ErrMessageNotFound
+	}
+	if err != nil {
+		return Pin{}, false, err
+	}
+
+	p := Pin{RoomID: roomID, MessageID: messageID, Message: &m}
+	var pinnedAt int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT position, pinned_by, pinned_at FROM room_pins WHERE room_id=? AND message_id=?`,
+		roomID, messageID,
+	).Scan(&p.Position, &p.PinnedBy, &pinnedAt)
+	if err == nil {
+		p.PinnedAt = fromMicros(pinnedAt)
+		return p, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Pin{}, false, err
+	}
+
+	var count, maxPos int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT count(*), COALESCE(max(position), 0) FROM room_pins WHERE room_id=?`,
+		roomID,
+	).Scan(&count, &maxPos); err != nil {
+		return Pin{}, false, err
+	}
+	if count >= MaxPinsPerRoom {
+		return Pin{}, false, ErrPinLimit
+	}
+	p.Position, p.PinnedBy, p.PinnedAt = maxPos+1, pinnedBy, now()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO room_pins(room_id, message_id, position, pinned_by, pinned_at) VALUES(?, ?, ?, ?, ?)`,
+		roomID, messageID, p.Position, pinnedBy, micros(p.PinnedAt),
+	); err != nil {
+		return Pin{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Pin{}, false, err
+	}
+	return p, true, nil
+}
+
+func sqliteRoomExists(ctx context.Context, tx *sql.Tx, roomID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM chat_rooms WHERE id=?`, roomID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRoomNotFound
+	}
+	return err
+}
+
+func (s *SQLite) RemovePin(ctx context.Context, roomID, messageID int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM room_pins WHERE room_id=? AND message_id=?`, roomID, messageID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPinNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) ReorderPins(ctx context.Context, roomID int64, messageIDs []int64) ([]Pin, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := sqliteRoomExists(ctx, tx, roomID); err != nil {
+		return nil, err
+	}
+	var pinned int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM room_pins WHERE room_id=?`, roomID).Scan(&pinned); err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]struct{}, len(messageIDs))
+	for _, id := range messageIDs {
+		seen[id] = struct{}{}
+	}
+	if len(seen) != len(messageIDs) || len(messageIDs) != pinned {
+		return nil, ErrPinOrderInvalid
+	}
+	for i, id := range messageIDs {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE room_pins SET position=? WHERE room_id=? AND message_id=?`,
+			i+1, roomID, id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, ErrPinOrderInvalid
+		}
+	}
+	pins, err := listSQLitePins(ctx, tx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+func (s *SQLite) PutBookmark(ctx context.Context, userID string, messageID int64, note string) (Bookmark, error) {
+	var (
+		b       Bookmark
+		created int64
+	)
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO user_bookmarks(user_id, message_id, note, created_at)
+			 SELECT ?, id, ?, ? FROM messages WHERE id=?
+			 ON CONFLICT (user_id, message_id)
+			 DO UPDATE SET note = excluded.note
+			 RETURNING id, message_id, note, created_at`,
+		userID, note, micros(now()), messageID,
+	).Scan(&b.ID, &b.MessageID, &b.Note, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Bookmark{}, ErrMessageNotFound
+	}
+	b.CreatedAt = fromMicros(created)
+	return b, err
+}
+
+func (s *SQLite) DeleteBookmark(ctx context.Context, userID string, messageID int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM user_bookmarks WHERE user_id=? AND message_id=?`, userID, messageID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBookmarkNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) ListBookmarks(ctx context.Context, userID string, before int64, limit int) ([]Bookmark, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT b.id, b.message_id, b.note, b.created_at, `+sqliteJoinedMessageColumns+`
+			 FROM user_bookmarks b
+			 JOIN messages m ON m.id = b.message_id
+			 WHERE b.user_id=? AND b.id < ?
+			 ORDER BY b.id DESC
+			 LIMIT ?`,
+		userID, before, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Bookmark, 0, limit+1)
+	for rows.Next() {
+		var (
+			b                 Bookmark
+			m                 Message
+			bCreated, created int64
+		)
+		if err := rows.Scan(&b.ID, &b.MessageID, &b.Note, &bCreated,
+			&m.ID, &m.RoomID, &m.Seq, &m.Author, &m.Content, &m.Source, &m.ClientMsgID, &created); err != nil {
+			return nil, 0, err
+		}
+		b.CreatedAt, m.CreatedAt = fromMicros(bCreated), fromMicros(created)
+		b.Message = &m
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(out) > limit {
+		out = out[:limit]
+		return out, out[limit-1].ID, nil
+	}
+	return out, 0, nil
+}
+
+const sqliteScheduledColumns = `id, kind, user_id, room_id, message_id, content, send_at, status,
+	attempts, sent_message_id, last_error, created_at`
+
+func scanSQLiteScheduled(row sqlScanner) (ScheduledMessage, error) {
+	var (
+		sm              ScheduledMessage
+		messageID       *int64
+		sentID          *int64
+		lastError       *string
+		sendAt, created int64
+	)
+	err := row.Scan(&sm.ID, &sm.Kind, &sm.UserID, &sm.RoomID, &messageID, &sm.Content, &sendAt,
+		&sm.Status, &sm.Attempts, &sentID, &lastError, &created)
+	sm.SendAt, sm.CreatedAt = fromMicros(sendAt), fromMicros(created)
+	if messageID != nil {
+		sm.MessageID = *messageID
+	}
+	if sentID != nil {
+		sm.SentMessageID = *sentID
+	}
+	if lastError != nil {
+		sm.LastError = *lastError
+	}
+	return sm, err
+}
+
+func (s *SQLite) ScheduleMessage(ctx context.Context, userID string, roomID int64, content string, sendAt time.Time) (ScheduledMessage, error) {
+	t := micros(now())
+	sm, err := scanSQLiteScheduled(s.db.QueryRowContext(ctx,
+		`INSERT INTO scheduled_messages(kind, user_id, room_id, content, send_at, created_at, updated_at)
+			 SELECT 'message', ?, id, ?, ?, ?, ? FROM chat_rooms WHERE id=?
+			 RETURNING `+sqliteScheduledColumns,
+		userID, content, micros(sendAt), t, t, roomID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScheduledMessage{}, ErrRoomNotFound
+	}
+	return sm, err
+}
+
+func (s *SQLite) ScheduleReminder(ctx context.Context, userID string, messageID int64, note string, remindAt time.Time) (ScheduledMessage, error) {
+	t := micros(now())
+	sm, err := scanSQLiteScheduled(s.db.QueryRowContext(ctx,
+		`INSERT INTO scheduled_messages(kind, user_id, room_id, message_id, content, send_at, created_at, updated_at)
+			 SELECT 'reminder', ?, room_id, id, ?, ?, ?, ? FROM messages WHERE id=?
+			 RETURNING `+sqliteScheduledColumns,
+		userID, note, micros(remindAt), t, t, messageID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScheduledMessage{}, ErrMessageNotFound
+	}
+	return sm, err
+}
+
+func (s *SQLite) ListScheduled(ctx context.Context, userID string) ([]ScheduledMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+sqliteScheduledColumns+`
+			 FROM scheduled_messages
+			 WHERE user_id=? AND status <> 'sent'
+			 ORDER BY send_at, id
+			 LIMIT 500`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectSQLiteScheduled(rows)
+}
+
+func collectSQLiteScheduled(rows *sql.Rows) ([]ScheduledMessage, error) {
+	defer rows.Close()
+	out := make([]ScheduledMessage, 0)
+	for rows.Next() {
+		sm, err := scanSQLiteScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sm)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) CancelScheduled(ctx context.Context, userID string, id int64) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM scheduled_messages WHERE id=? AND user_id=? AND status IN ('pending', 'failed')`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrScheduledNotFound
+	}
+	return nil
+}
+
+// ClaimDueScheduled needs no SKIP LOCKED: the single connection already
+// serializes claimers.
+func (s *SQLite) ClaimDueScheduled(ctx context.Context, owner string, lease time.Duration, limit int) ([]ScheduledMessage, error) {
+	t := micros(time.Now())
+	rows, err := s.db.QueryContext(ctx,
+		`UPDATE scheduled_messages
+			 SET status='processing', locked_by=?, locked_until=?, attempts=attempts+1, updated_at=?
+			 WHERE id IN (
+				SELECT id FROM scheduled_messages
+				 WHERE (status='pending' AND send_at <= ?)
+				    OR (status='processing' AND locked_until < ?)
+				 ORDER BY send_at
+				 LIMIT ?
+			 )
+			 RETURNING `+sqliteScheduledColumns,
+		owner, t+lease.Microseconds(), t, t, t, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	out, err := collectSQLiteScheduled(rows)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING order is unspecified; keep the send_at order Postgres gives.
+	sortScheduled(out)
+	return out, nil
+}
+
+func (s *SQLite) MarkScheduledSent(ctx context.Context, id int64, owner string, sentMessageID int64) error {
+	var sent *int64
+	if sentMessageID > 0 {
+		sent = &sentMessageID
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE scheduled_messages
+			 SET status='sent', sent_message_id=?, locked_by=NULL, locked_until=NULL, updated_at=?
+			 WHERE id=? AND locked_by=?`,
+		sent, micros(now()), id, owner,
+	)
+	return err
+}
+
+func (s *SQLite) MarkScheduledFailed(ctx context.Context, id int64, owner, reason string, final bool) error {
+	set := `last_error=?, updated_at=?`
+	if final {
+		set = `status='failed', ` + set + `, locked_by=NULL, locked_until=NULL`
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE scheduled_messages SET `+set+` WHERE id=? AND locked_by=?`,
+		reason, micros(now()), id, owner,
+	)
+	return err
+}
+
+func sortScheduled(items []ScheduledMessage) {
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		return a.SendAt.Before(b.SendAt) || a.SendAt.Equal(b.SendAt) && a.ID < b.ID
+	})
+}
+
+func (s *SQLite) ListRetention(ctx context.Context) ([]RoomRetention, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, retention_days, legal_hold FROM chat_rooms ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]RoomRetention, 0)
+	for rows.Next() {
+		var r RoomRetention
+		if err := rows.Scan(&r.RoomID, &r.Days, &r.LegalHold); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) GetRetention(ctx context.Context, roomID int64) (RoomRetention, error) {
+	r := RoomRetention{RoomID: roomID}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT retention_days, legal_hold FROM chat_rooms WHERE id=?`, roomID,
+	).Scan(&r.Days, &r.LegalHold)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RoomRetention{}, ErrRoomNotFound
+	}
+	return r, err
+}
+
+func (s *SQLite) SetRetention(ctx context.Context, p RoomRetention) (RoomRetention, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE chat_rooms SET retention_days=?, legal_hold=? WHERE id=?`,
+		p.Days, p.LegalHold, p.RoomID,
+	)
+	if err != nil {
+		return RoomRetention{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return RoomRetention{}, ErrRoomNotFound
+	}
+	return p, nil
+}
+
+func (s *SQLite) CountMessagesBefore(ctx context.Context, roomID int64, before time.Time) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM messages m WHERE m.room_id=? AND m.created_at < ? AND `+notOpenReported,
+		roomID, micros(before),
+	).Scan(&n)
+	return n, err
+}
+
+// PurgeMessages deletes the batch and repoints the room's last message in one
+// transaction; SQLite has no data-modifying CTEs to do it in one statement.
+func (s *SQLite) PurgeMessages(ctx context.Context, roomID int64, before time.Time, limit int) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM messages WHERE id IN (
+			SELECT m.id FROM messages m
+			 JOIN chat_rooms r ON r.id = m.room_id
+			 WHERE m.room_id=? AND m.created_at < ? AND NOT r.legal_hold AND `+notOpenReported+`
+			 ORDER BY m.created_at, m.id
+			 LIMIT ?
+		 )`,
+		roomID, micros(before), limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE chat_rooms SET last_message_id = (SELECT max(id) FROM messages WHERE room_id = chat_rooms.id)
+		  WHERE id=? AND NOT EXISTS (SELECT 1 FROM messages WHERE id = chat_rooms.last_message_id)`,
+		roomID,
+	); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLite) RecordPurge(ctx context.Context, p PurgeRecord) (PurgeRecord, error) {
+	p.PurgedAt = now()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO retention_purges(room_id, cutoff, deleted, purged_at) VALUES(?, ?, ?, ?)`,
+		p.RoomID, micros(p.Cutoff), p.Deleted, micros(p.PurgedAt),
+	)
+	if err != nil {
+		return PurgeRecord{}, err
+	}
+	p.ID, err = res.LastInsertId()
+	return p, err
+}
+
+func (s *SQLite) ListPurges(ctx context.Context, roomID int64, limit int) ([]PurgeRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, room_id, cutoff, deleted, purged_at
+		   FROM retention_purges
+		  WHERE ? = 0 OR room_id = ?
+		  ORDER BY id DESC
+		  LIMIT ?`,
+		roomID, roomID, purgeLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]PurgeRecord, 0)
+	for rows.Next() {
+		var (
+			p              PurgeRecord
+			cutoff, purged int64
+		)
+		if err := rows.Scan(&p.ID, &p.RoomID, &cutoff, &p.Deleted, &purged); err != nil {
+			return nil, err
+		}
+		p.Cutoff, p.PurgedAt = fromMicros(cutoff), fromMicros(purged)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) AppendAudit(ctx context.Context, e AuditEvent) (AuditEvent, error) {
+	e.At = now()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO audit_events(at, actor, action, target_type, target_id, request_id, remote_addr, user_agent, details)
+			 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		micros(e.At), e.Actor, e.Action, e.TargetType, e.TargetID, e.RequestID, e.RemoteAddr, e.UserAgent, nullJSON(e.Details),
+	)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	e.ID, err = res.LastInsertId()
+	return e, err
+}
+
+func (s *SQLite) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, int64, error) {
+	where, args := auditFilter(q,
+		func(int) string { return `?` },
+		func(t time.Time) any { return micros(t) },
+	)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_events `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]AuditEvent, 0)
+	for rows.Next() {
+		var (
+			e       AuditEvent
+			at      int64
+			details sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &at, &e.Actor, &e.Action, &e.TargetType, &e.TargetID,
+			&e.RequestID, &e.RemoteAddr, &e.UserAgent, &details); err != nil {
+			return nil, 0, err
+		}
+		e.At = fromMicros(at)
+		if details.Valid {
+			e.Details = json.RawMessage(details.String)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	out, next := auditPage(out, q.Limit)
+	return out, next, nil
+}
+
+func (s *SQLite) FlagMessage(ctx context.Context, r Review) (Review, error) {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO message_reviews(message_id, room_id, user_id, reasons, created_at)
+			 SELECT id, room_id, ?, ?, ? FROM messages WHERE id=?
+			 ON CONFLICT (message_id) DO NOTHING`,
+		r.UserID, strings.Join(r.Reasons, ","), micros(now()), r.MessageID,
+	)
+	if err != nil {
+		return Review{}, err
+	}
+	r, err = scanSQLiteReview(s.db.QueryRowContext(ctx, reviewSelect+` WHERE v.message_id=?`, r.MessageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Review{}, ErrMessageNotFound
+	}
+	return r, err
+}
+
+func (s *SQLite) ListReviews(ctx context.Context, status string, before int64, limit int) ([]Review, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	rows, err := s.db.QueryContext(ctx,
+		reviewSelect+` WHERE v.id < ? AND (? = '' OR v.status = ?) ORDER BY v.id DESC LIMIT ?`,
+		before, status, status, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Review, 0)
+	for rows.Next() {
+		r, err := scanSQLiteReview(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	out, next := reviewPage(out, limit)
+	return out, next, nil
+}
+
+func (s *SQLite) ResolveReview(ctx context.Context, id int64, status, reviewer string) (Review, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE message_reviews SET status=?, reviewed_by=?, reviewed_at=?
+		  WHERE id=? AND status='pending'`,
+		status, reviewer, micros(now()), id,
+	)
+	if err != nil {
+		return Review{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Review{}, err
+	}
+	r, err := scanSQLiteReview(s.db.QueryRowContext(ctx, reviewSelect+` WHERE v.id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Review{}, ErrReviewNotFound
+	}
+	if err == nil && n == 0 {
+		return r, ErrReviewResolved
+	}
+	return r, err
+}
+
+func scanSQLiteReview(row sqlScanner) (Review, error) {
+	var (
+		r          Review
+		reasons    string
+		created    int64
+		reviewedAt sql.NullInt64
+	)
+	err := row.Scan(&r.ID, &r.MessageID, &r.RoomID, &r.UserID, &r.Content, &reasons, &r.Status,
+		&created, &r.ReviewedBy, &reviewedAt)
+	r.Reasons, r.CreatedAt = splitReasons(reasons), fromMicros(created)
+	if reviewedAt.Valid {
+		t := fromMicros(reviewedAt.Int64)
+		r.ReviewedAt = &t
+	}
+	return r, err
+}
+
+func (s *SQLite) BlockUser(ctx context.Context, userID, blockedID string) (Block, bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_blocks(user_id, blocked_id, created_at) VALUES (?, ?, ?)
+			 ON CONFLICT (user_id, blocked_id) DO NOTHING`,
+		userID, blockedID, micros(now()),
+	)
+	if err != nil {
+		return Block{}, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Block{}, false, err
+	}
+	var created int64
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT created_at FROM user_blocks WHERE user_id=? AND blocked_id=?`,
+		userID, blockedID,
+	).Scan(&created); err != nil {
+		return Block{}, false, err
+	}
+	return Block{UserID: blockedID, BlockedAt: fromMicros(created)}, n > 0, nil
+}
+
+func (s *SQLite) UnblockUser(ctx context.Context, userID, blockedID string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM user_blocks WHERE user_id=? AND blocked_id=?`,
+		userID, blockedID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) ListBlocks(ctx context.Context, userID string) ([]Block, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT blocked_id, created_at FROM user_blocks WHERE user_id=? ORDER BY created_at DESC, blocked_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Block, 0)
+	for rows.Next() {
+		var (
+			b       Block
+			created int64
+		)
+		if err := rows.Scan(&b.UserID, &created); err != nil {
+			return nil, err
+		}
+		b.BlockedAt = fromMicros(created)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) ReportMessage(ctx context.Context, r Report) (Report, bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO message_reports(message_id, room_id, reporter, reason, note, created_at)
+			 SELECT id, room_id, ?, ?, ?, ? FROM messages WHERE id=? AND room_id=?
+			 ON CONFLICT (message_id, reporter) DO NOTHING`,
+		r.Reporter, r.Reason, r.Note, micros(now()), r.MessageID, r.RoomID,
+	)
+	if err != nil {
+		return Report{}, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Report{}, false, err
+	}
+	r, err = scanSQLiteReport(s.db.QueryRowContext(ctx,
+		reportSelect+` WHERE p.message_id=? AND p.room_id=? AND p.reporter=?`,
+		r.MessageID, r.RoomID, r.Reporter,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, false, ErrMessageNotFound
+	}
+	return r, n > 0, err
+}
+
+func (s *SQLite) ListReports(ctx context.Context, status string, before int64, limit int) ([]Report, int64, error) {
+	limit, before = bookmarkPage(limit, before)
+	rows, err := s.db.QueryContext(ctx,
+		reportSelect+` WHERE p.id < ? AND (? = '' OR p.status = ?) ORDER BY p.id DESC LIMIT ?`,
+		before, status, status, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Report, 0)
+	for rows.Next() {
+		r, err := scanSQLiteReport(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	out, next := reportPage(out, limit)
+	return out, next, nil
+}
+
+func (s *SQLite) ResolveReport(ctx context.Context, id int64, status, resolver string) (Report, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE message_reports SET status=?, resolved_by=?, resolved_at=?
+		  WHERE id=? AND status='open'`,
+		status, resolver, micros(now()), id,
+	)
+	if err != nil {
+		return Report{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Report{}, err
+	}
+	r, err := scanSQLiteReport(s.db.QueryRowContext(ctx, reportSelect+` WHERE p.id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, ErrReportNotFound
+	}
+	if err == nil && n == 0 {
+		return r, ErrReportResolved
+	}
+	return r, err
+}
+
+func scanSQLiteReport(row sqlScanner) (Report, error) {
+	var (
+		r          Report
+		created    int64
+		resolvedAt sql.NullInt64
+	)
+	err := row.Scan(&r.ID, &r.MessageID, &r.RoomID, &r.Reporter, &r.Reason, &r.Note, &r.Content, &r.Status,
+		&created, &r.ResolvedBy, &resolvedAt)
+	r.CreatedAt = fromMicros(created)
+	if resolvedAt.Valid {
+		t := fromMicros(resolvedAt.Int64)
+		r.ResolvedAt = &t
+	}
+	return r, err
+}
+
+func (s *SQLite) ListMembers(ctx context.Context, roomID int64) ([]Member, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT user_id, is_admin, joined_at FROM room_members WHERE room_id=? ORDER BY joined_at, user_id`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Member, 0)
+	for rows.Next() {
+		var (
+			m      Member
+			joined int64
+		)
+		if err := rows.Scan(&m.UserID, &m.IsAdmin, &joined); err != nil {
+			return nil, err
+		}
+		m.JoinedAt = fromMicros(joined)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) ImportRoom(ctx context.Context, origin, name string, createdAt time.Time) (Room, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Room{}, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		r       Room
+		created int64
+	)
+	err = tx.QueryRowContext(ctx, `SELECT id, name, created_at FROM chat_rooms WHERE origin=?`, origin).Scan(&r.ID, &r.Name, &created)
+	if err == nil {
+		r.CreatedAt = fromMicros(created)
+		return r, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Room{}, false, err
+	}
+	r = Room{Name: name, CreatedAt: createdAt.UTC().Truncate(time.Microsecond)}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO chat_rooms(name, created_at, origin) VALUES(?, ?, ?)`,
+		name, micros(r.CreatedAt), origin,
+	)
+	if err != nil {
+		return Room{}, false, err
+	}
+	if r.ID, err = res.LastInsertId(); err != nil {
+		return Room{}, false, err
+	}
+	return r, true, tx.Commit()
+}
+
+func (s *SQLite) ImportMembers(ctx context.Context, roomID int64, members []Member) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := sqliteRoomExists(ctx, tx, roomID); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range members {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO room_members(room_id, user_id, is_admin, joined_at) VALUES(?, ?, ?, ?)
+			 ON CONFLICT (room_id, user_id) DO NOTHING`,
+			roomID, m.UserID, m.IsAdmin, micros(m.JoinedAt),
+		)
+		if err != nil {
+			return 0, err
+		}
+		if k, _ := res.RowsAffected(); k > 0 {
+			n++
+		}
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLite) ImportMessages(ctx context.Context, roomID int64, msgs []Message) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var seq int64
+	err = tx.QueryRowContext(ctx, `SELECT last_seq FROM chat_rooms WHERE id=?`, roomID).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrRoomNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var last Message
+	n := 0
+	for _, m := range msgs {
+		m, err = scanSQLiteMessage(tx.QueryRowContext(ctx,
+			`INSERT INTO messages(room_id, seq, author, content, source, client_msg_id, created_at)
+				 VALUES(?, ?, ?, ?, ?, ?, ?)
+				 ON CONFLICT (room_id, client_msg_id) DO NOTHING
+				 RETURNING `+sqliteMessageColumns,
+			roomID, seq+1, m.Author, m.Content, m.Source, m.ClientMsgID, micros(m.CreatedAt),
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // already imported
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 || !m.CreatedAt.Before(last.CreatedAt) {
+			last = m
+		}
+		seq = m.Seq
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	at := micros(last.CreatedAt)
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE chat_rooms SET last_seq=?,
+		        last_message_id = CASE WHEN last_message_at IS NULL OR last_message_at <= ? THEN ? ELSE last_message_id END,
+		        last_message_at = CASE WHEN last_message_at IS NULL OR last_message_at <= ? THEN ? ELSE last_message_at END
+		  WHERE id=?`,
+		seq, at, last.ID, at, at, roomID,
+	); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -2,119 +2,354 @@ package store
 
 import (
 	"context"
-	"errors"
+	"math"
 	"strconv"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
 )
 
-type Store struct {
-	pool *pgxpool.Pool
+var (
+	ErrRoomNotFound    = apperr.New(apperr.CodeRoomNotFound, "room not found")
+	ErrMessageNotFound = apperr.New(apperr.CodeMessageNotFound, "message not found")
+)
+
+// Store is everything chatd persists. Postgres is the production backend;
+// SQLite serves single-binary deployments and Memory serves tests. All three
+// pass the same conformance suite (tests/store_conformance_test.go), so
+// handlers can be exercised against Memory without a database.
+type Store interface {
+	Ping(ctx context.Context) error
+	Rooms
+	Messages
+	Pins
+	Bookmarks
+	Scheduled
+	Retention
+	Archive
+	Audit
+	Moderation
+	Blocks
+	Reports
 }
 
-func New(pool *pgxpool.Pool) *Store {
-	return &Store{pool: pool}
+type Rooms interface {
+	// CreateRoom creates a room. When createdBy is set the creator joins it as
+	// its first admin.
+	CreateRoom(ctx context.Context, name, createdBy string) (Room, error)
+	GetRoom(ctx context.Context, roomID int64) (Room, error)
+	RoomExists(ctx context.Context, roomID int64) (bool, error)
+	IsRoomAdmin(ctx context.Context, roomID int64, userID string) (bool, error)
+	// ListRooms returns one page of rooms in the requested order and the
+	// cursor of the next page ("" when there is none).
+	ListRooms(ctx context.Context, p ListRoomsParams) ([]Room, string, error)
 }
 
-func (s *Store) Ping(ctx context.Context) error {
-	if s == nil || s.pool == nil {
-		return errors.New("store not initialized")
-	}
-	return s.pool.Ping(ctx)
+type Messages interface {
+	// CreateMessage stores a message under the room's next sequence number.
+	// Within a room seq order is commit order and has no gaps; a retried
+	// clientMsgID returns the original message without consuming a number.
+	// author is the posting user, empty for anonymous posts.
+	CreateMessage(ctx context.Context, roomID int64, author, content, source, clientMsgID string) (Message, error)
+	GetMessage(ctx context.Context, id int64) (Message, error)
+	ListMessages(ctx context.Context, roomID int64, q MessageQuery) (MessagePage, error)
+	// MessagesAfter returns up to limit messages with seq greater than
+	// afterSeq in ascending order, and whether more follow. It backs replay.
+	MessagesAfter(ctx context.Context, roomID, afterSeq int64, limit int) ([]Message, bool, error)
+}
+
+type Pins interface {
+	ListPins(ctx context.Context, roomID int64) ([]Pin, error)
+	// AddPin pins a message of the room at the end of the pin list. Pinning
+	// an already pinned message returns the existing pin with created=false.
+	AddPin(ctx context.Context, roomID, messageID int64, pinnedBy string) (pin Pin, created bool, err error)
+	RemovePin(ctx context.Context, roomID, messageID int64) error
+	// ReorderPins sets the pin order of a room. messageIDs must contain every
+	// pinned message of the room exactly once.
+	ReorderPins(ctx context.Context, roomID int64, messageIDs []int64) ([]Pin, error)
+}
+
+type Bookmarks interface {
+	// PutBookmark bookmarks a message for the user, replacing the note if the
+	// bookmark already exists.
+	PutBookmark(ctx context.Context, userID string, messageID int64, note string) (Bookmark, error)
+	DeleteBookmark(ctx context.Context, userID string, messageID int64) error
+	// ListBookmarks returns the user's bookmarks newest first. before is the
+	// id of the last bookmark of the previous page; the returned cursor is 0
+	// on the last page.
+	ListBookmarks(ctx context.Context, userID string, before int64, limit int) ([]Bookmark, int64, error)
+}
+
+type Scheduled interface {
+	ScheduleMessage(ctx context.Context, userID string, roomID int64, content string, sendAt time.Time) (ScheduledMessage, error)
+	ScheduleReminder(ctx context.Context, userID string, messageID int64, note string, remindAt time.Time) (ScheduledMessage, error)
+	// ListScheduled returns the user's items that have not been sent yet,
+	// soonest first. Failed items stay listed until the user deletes them.
+	ListScheduled(ctx context.Context, userID string) ([]ScheduledMessage, error)
+	// CancelScheduled deletes a pending or failed item of the user. Items
+	// already claimed by the scheduler can no longer be cancelled.
+	CancelScheduled(ctx context.Context, userID string, id int64) error
+	// ClaimDueScheduled leases up to limit due items to owner. Items whose
+	// lease expired (owner crashed) become claimable again.
+	ClaimDueScheduled(ctx context.Context, owner string, lease time.Duration, limit int) ([]ScheduledMessage, error)
+	MarkScheduledSent(ctx context.Context, id int64, owner string, sentMessageID int64) error
+	// MarkScheduledFailed records a failed attempt. Unless final, the item
+	// stays leased and is retried once the lease runs out.
+	MarkScheduledFailed(ctx context.Context, id int64, owner, reason string, final bool) error
+}
+
+type Retention interface {
+	// ListRetention returns the policy of every room, in id order.
+	ListRetention(ctx context.Context) ([]RoomRetention, error)
+	GetRetention(ctx context.Context, roomID int64) (RoomRetention, error)
+	// SetRetention replaces the policy of p.RoomID.
+	SetRetention(ctx context.Context, p RoomRetention) (RoomRetention, error)
+	// CountMessagesBefore counts what PurgeMessages would delete, ignoring the
+	// legal hold.
+	CountMessagesBefore(ctx context.Context, roomID int64, before time.Time) (int64, error)
+	// PurgeMessages deletes up to limit of the room's messages created before
+	// before, oldest first, and returns how many it deleted. It deletes nothing
+	// while the room is under legal hold, and skips messages with an open
+	// report. Pins, bookmarks, reminders, reviews and closed reports of the
+	// deleted messages go with them; a room whose last message was deleted
+	// points at its newest remaining one.
+	PurgeMessages(ctx context.Context, roomID int64, before time.Time, limit int) (int64, error)
+	RecordPurge(ctx context.Context, p PurgeRecord) (PurgeRecord, error)
+	// ListPurges returns the newest purge records, of one room or (roomID 0)
+	// of all rooms.
+	ListPurges(ctx context.Context, roomID int64, limit int) ([]PurgeRecord, error)
+}
+
+// Archive backs room export and import (internal/archive).
+type Archive interface {
+	ListMembers(ctx context.Context, roomID int64) ([]Member, error)
+	// ImportRoom returns the room earlier imported from origin, or creates it
+	// with created=true.
+	ImportRoom(ctx context.Context, origin, name string, createdAt time.Time) (room Room, created bool, err error)
+	// ImportMembers adds the members not yet in the room and returns how many
+	// it added.
+	ImportMembers(ctx context.Context, roomID int64, members []Member) (int, error)
+	// ImportMessages appends messages in order under new seqs, keeping their
+	// content, source, clientMsgId and time. Messages whose clientMsgId the
+	// room already has are skipped; it returns how many it stored. The room's
+	// last message only moves to an imported one that is at least as new.
+	ImportMessages(ctx context.Context, roomID int64, msgs []Message) (int, error)
+}
+
+// Audit backs the audit log (internal/audit). Events can only be appended;
+// the Postgres and SQLite schemas reject updates and deletes.
+type Audit interface {
+	AppendAudit(ctx context.Context, e AuditEvent) (AuditEvent, error)
+	// ListAudit returns one page of matching events newest first and the
+	// cursor of the next page (0 on the last page).
+	ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, int64, error)
+}
+
+// Moderation is the review queue of internal/moderation.
+type Moderation interface {
+	// FlagMessage queues a stored message for review. A message already in
+	// the queue keeps its first review, which is returned.
+	FlagMessage(ctx context.Context, r Review) (Review, error)
+	// ListReviews returns reviews newest first, of one status or (status "")
+	// all. before and the returned cursor page like ListBookmarks.
+	ListReviews(ctx context.Context, status string, before int64, limit int) ([]Review, int64, error)
+	// ResolveReview moves a pending review to status. Resolving it again
+	// fails with ErrReviewResolved and returns the review as it stands.
+	ResolveReview(ctx context.Context, id int64, status, reviewer string) (Review, error)
 }
 
-func (s *Store) CreateRoom(ctx context.Context, name string) (Room, error) {
-	var r Room
-	err := s.pool.QueryRow(ctx,
-		`INSERT INTO chat_rooms(name) VALUES($1)
-			 RETURNING id, name, created_at`,
-		name,
-	).Scan(&r.ID, &r.Name, &r.CreatedAt)
-	return r, err
+// Blocks are the users each user has blocked.
+type Blocks interface {
+	// BlockUser blocks blockedID for userID. Blocking again keeps the first
+	// block, returned with created=false.
+	BlockUser(ctx context.Context, userID, blockedID string) (block Block, created bool, err error)
+	UnblockUser(ctx context.Context, userID, blockedID string) error
+	// ListBlocks returns the users userID blocked, newest first.
+	ListBlocks(ctx context.Context, userID string) ([]Block, error)
 }
 
-func (s *Store) ListRooms(ctx context.Context, limit int) ([]Room, error) {
-	if limit <= 0 {
+// Reports are the messages users reported to the admins.
+type Reports interface {
+	// ReportMessage files r against a message of r.RoomID. A user reports a
+	// message once; reporting it again returns the first report with
+	// created=false.
+	ReportMessage(ctx context.Context, r Report) (report Report, created bool, err error)
+	// ListReports returns reports newest first, of one status or (status "")
+	// all. before and the returned cursor page like ListBookmarks.
+	ListReports(ctx context.Context, status string, before int64, limit int) ([]Report, int64, error)
+	// ResolveReport closes an open report with status. Closing it again fails
+	// with ErrReportResolved and returns the report as it stands.
+	ResolveReport(ctx context.Context, id int64, status, resolver string) (Report, error)
+}
+
+var (
+	_ Store = (*Postgres)(nil)
+	_ Store = (*SQLite)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// The helpers below hold the paging rules every backend shares, so only the
+// reads themselves differ between them.
+
+// previewLen is how much of the last message a room listing shows.
+const previewLen = 200
+
+func roomListParams(p ListRoomsParams) (RoomSort, int) {
+	limit := p.Limit
+	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
-	rows, err := s.pool.Query(ctx, `SELECT id, name, created_at FROM chat_rooms ORDER BY id DESC LIMIT $1`, limit)
-	if err != nil {
-		return nil, err
+	sort := p.Sort
+	if !sort.Valid() {
+		sort = RoomSortActivity
 	}
-	defer rows.Close()
+	return sort, limit
+}
 
-	out := make([]Room, 0, limit)
-	for rows.Next() {
-		var r Room
-		if err := rows.Scan(&r.ID, &r.Name, &r.CreatedAt); err != nil {
-			return nil, err
-		}
-		out = append(out, r)
+// roomPage trims a limit+1 read to one page and builds the next cursor.
+func roomPage(out []Room, sort RoomSort, limit int) ([]Room, string) {
+	if len(out) <= limit {
+		return out, ""
+	}
+	out = out[:limit]
+	last := out[limit-1]
+	cur := roomCursor{Sort: sort, ID: last.ID}
+	switch sort {
+	case RoomSortName:
+		cur.Key = last.Name
+	case RoomSortActivity:
+		cur.Key = formatMicros(last.activity())
 	}
-	return out, rows.Err()
+	return out, encodeRoomCursor(cur)
 }
 
-func (s *Store) ListMessages(ctx context.Context, roomID int64, cursor int64, limit int) ([]Message, int64, error) {
+// activity is what RoomSortActivity orders by.
+func (r Room) activity() time.Time {
+	if r.LastMessageAt != nil {
+		return *r.LastMessageAt
+	}
+	return r.CreatedAt
+}
+
+// messageReader is what pageMessages needs from a backend.
+type messageReader interface {
+	// messageWindow reads up to limit messages on one side of the pivot seq,
+	// nearest first, and reports whether more exist beyond them. op is one of
+	// "<", "<=" or ">".
+	messageWindow(ctx context.Context, roomID int64, op string, pivot int64, limit int) ([]Message, bool, error)
+	messageExists(ctx context.Context, roomID int64, op string, pivot int64) (bool, error)
+}
+
+func pageMessages(ctx context.Context, r messageReader, roomID int64, q MessageQuery) (MessagePage, error) {
+	limit := q.Limit
 	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
 
-	var rows pgx.Rows
-	var err error
-	if cursor > 0 {
-		rows, err = s.pool.Query(ctx,
-			`SELECT id, room_id, content, source, client_msg_id, created_at
-				 FROM messages
-				 WHERE room_id=$1 AND id < $2
-				 ORDER BY id DESC
-				 LIMIT $3`,
-			roomID, cursor, limit,
-		)
-	} else {
-		rows, err = s.pool.Query(ctx,
-			`SELECT id, room_id, content, source, client_msg_id, created_at
-				 FROM messages
-				 WHERE room_id=$1
-				 ORDER BY id DESC
-				 LIMIT $2`,
-			roomID, limit,
-		)
+	var (
+		items                  []Message
+		hasOlder, hasNewer     bool
+		olderKnown, newerKnown bool
+		err                    error
+	)
+	switch {
+	case q.Around > 0:
+		// the target message is the first of the older half
+		var newer []Message
+		items, hasOlder, err = r.messageWindow(ctx, roomID, "<=", q.Around, limit-limit/2)
+		if err != nil {
+			return MessagePage{}, err
+		}
+		if limit/2 > 0 {
+			newer, hasNewer, err = r.messageWindow(ctx, roomID, ">", q.Around, limit/2)
+			if err != nil {
+				return MessagePage{}, err
+			}
+			newerKnown = true
+		}
+		items = append(reverseMessages(newer), items...)
+		olderKnown = true
+	case q.After > 0:
+		items, hasNewer, err = r.messageWindow(ctx, roomID, ">", q.After, limit)
+		if err != nil {
+			return MessagePage{}, err
+		}
+		items = reverseMessages(items)
+		newerKnown = true
+	case q.Before > 0:
+		items, hasOlder, err = r.messageWindow(ctx, roomID, "<", q.Before, limit)
+		olderKnown = true
+	default:
+		items, hasOlder, err = r.messageWindow(ctx, roomID, "<", math.MaxInt64, limit)
+		olderKnown, newerKnown = true, true
 	}
 	if err != nil {
-		return nil, 0, err
+		return MessagePage{}, err
 	}
-	defer rows.Close()
 
-	out := make([]Message, 0, limit)
-	var nextCursor int64 = 0
-	for rows.Next() {
-		var m Message
-		if err := rows.Scan(&m.ID, &m.RoomID, &m.Content, &m.Source, &m.ClientMsgID, &m.CreatedAt); err != nil {
-			return nil, 0, err
+	page := MessagePage{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+	newest, oldest := items[0].Seq, items[len(items)-1].Seq
+	if !olderKnown {
+		if hasOlder, err = r.messageExists(ctx, roomID, "<", oldest); err != nil {
+			return MessagePage{}, err
+		}
+	}
+	if !newerKnown {
+		if hasNewer, err = r.messageExists(ctx, roomID, ">", newest); err != nil {
+			return MessagePage{}, err
 		}
-		out = append(out, m)
-		nextCursor = m.ID
 	}
-	if err := rows.Err(); err != nil {
-		return nil, 0, err
+	if hasOlder {
+		page.NextCursor = oldest
+	}
+	if hasNewer {
+		page.PrevCursor = newest
+	}
+	return page, nil
+}
+
+func afterLimit(limit int) int {
+	if limit <= 0 || limit > 200 {
+		return 200
+	}
+	return limit
+}
+
+func bookmarkPage(limit int, before int64) (int, int64) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if before <= 0 {
+		before = math.MaxInt64
 	}
-	return out, nextCursor, nil
+	return limit, before
 }
 
-func (s *Store) CreateMessage(ctx context.Context, roomID int64, content, source, clientMsgID string) (Message, error) {
-	if clientMsgID == "" {
-		clientMsgID = strconv.FormatInt(time.Now().UnixNano(), 10)
+func reverseMessages(in []Message) []Message {
+	for i, j := 0, len(in)-1; i < j; i, j = i+1, j-1 {
+		in[i], in[j] = in[j], in[i]
 	}
-	var m Message
-	err := s.pool.QueryRow(ctx,
-		`INSERT INTO messages(room_id, content, source, client_msg_id)
-			 VALUES($1,$2,$3,$4)
-			 ON CONFLICT (room_id, client_msg_id)
-			 DO UPDATE SET content = messages.content
-			 RETURNING id, room_id, content, source, client_msg_id, created_at`,
-		roomID, content, source, clientMsgID,
-	).Scan(&m.ID, &m.RoomID, &m.Content, &m.Source, &m.ClientMsgID, &m.CreatedAt)
-	return m, err
+	return in
+}
+
+// newClientMsgID stands in for a missing client id so every message has one.
+func newClientMsgID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// stored records a newly persisted message in the metrics and debug log.
+func stored(ctx context.Context, m Message) {
+	metrics.MessagePersisted(m.Source)
+	logging.FromContext(ctx).Debug("message stored",
+		"room_id", m.RoomID, "message_id", m.ID, "seq", m.Seq, "source", m.Source)
+}
+
+func deduplicated(ctx context.Context, m Message) {
+	logging.FromContext(ctx).Debug("message deduplicated",
+		"room_id", m.RoomID, "message_id", m.ID, "client_msg_id", m.ClientMsgID)
 }
@@ -0,0 +1,161 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files names the PEM files of one listener. CertFile/KeyFile enable TLS;
+// ClientCAFile additionally requires and verifies client certificates (mTLS).
+type Files struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+func (f Files) Enabled() bool { return f.CertFile != "" && f.KeyFile != "" }
+
+// ParseMinVersion maps "1.2"/"1.3" to the crypto/tls constant.
+func ParseMinVersion(v string) (uint16, error) {
+	switch strings.TrimSpace(v) {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS min version %q (1.2|1.3)", v)
+}
+
+// Reloader serves the current certificate (and client CA pool) of a listener
+// and picks up replaced files without a restart. Files are polled rather than
+// watched so renames by cert tooling (certbot, cert-manager) are caught too.
+type Reloader struct {
+	files      Files
+	minVersion uint16
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	clients *x509.CertPool
+	stamp   string
+}
+
+// NewReloader loads the files once and fails if they are unusable, so a
+// misconfigured listener is caught at startup.
+func NewReloader(files Files, minVersion uint16) (*Reloader, error) {
+	if !files.Enabled() {
+		return nil, errors.New("tls cert and key files are required")
+	}
+	r := &Reloader{files: files, minVersion: minVersion}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server config that always hands out the latest files.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   r.minVersion,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.clients != nil {
+				cfg.ClientCAs = r.clients
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Watch polls the files every interval until ctx is done. A failed reload keeps
+// serving the previous certificate.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			stamp, err := r.fileStamp()
+			if err != nil {
+				slog.Warn("tls stat failed", "cert", r.files.CertFile, "err", err)
+				continue
+			}
+			r.mu.RLock()
+			same := stamp == r.stamp
+			r.mu.RUnlock()
+			if same {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				slog.Error("tls reload failed, keeping previous certificate", "cert", r.files.CertFile, "err", err)
+				continue
+			}
+			slog.Info("tls certificate reloaded", "cert", r.files.CertFile)
+		}
+	}
+}
+
+func (r *Reloader) reload() error {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clients *x509.CertPool
+	if r.files.ClientCAFile != "" {
+		if clients, err = LoadCertPool(r.files.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.clients, r.stamp = &cert, clients, stamp
+	r.mu.Unlock()
+	return nil
+}
+
+// fileStamp summarizes size and mtime of every file to detect replacements.
+func (r *Reloader) fileStamp() (string, error) {
+	var b strings.Builder
+	for _, f := range []string{r.files.CertFile, r.files.KeyFile, r.files.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,132 @@
+// Package validate holds the input rules shared by the REST and gRPC APIs.
+// Error texts are what both APIs return to clients.
+package validate
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+const (
+	MaxRoomName    = 200
+	MaxContent     = 5000
+	MaxClientMsgID = 128
+	MaxNote        = 500
+	MaxUserID      = 128
+
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+var (
+	ErrRoomName        = apperr.Validation("name", "name required (<=200)")
+	ErrContentRequired = apperr.Validation("content", "content required")
+	ErrContentTooLong  = apperr.Validation("content", "content too long (<=5000)")
+	ErrClientMsgID     = apperr.Validation("clientMsgId", "clientMsgId too long (<=128)")
+	ErrNote            = apperr.Validation("note", "note too long (<=500)")
+	ErrUserID          = apperr.Validation("userId", "userId required (<=128)")
+	ErrBlockSelf       = apperr.Validation("userId", "cannot block yourself")
+	ErrReportReason    = apperr.Validation("reason", "invalid reason (spam|abuse|harassment|illegal|other)")
+	ErrSort            = apperr.Validation("sort", "invalid sort (activity|created|name)")
+	ErrCursor          = apperr.Validation("cursor", "invalid cursor")
+	ErrCursorConflict  = apperr.Validation("", "only one of before, after, around is allowed")
+)
+
+// RoomName trims a room name and checks its length.
+func RoomName(raw string) (string, error) {
+	name := strings.TrimSpace(raw)
+	if name == "" || len([]rune(name)) > MaxRoomName {
+		return "", ErrRoomName
+	}
+	return name, nil
+}
+
+// Content trims message content and checks it is present and not too long.
+func Content(raw string) (string, error) {
+	content := strings.TrimSpace(raw)
+	if content == "" {
+		return "", ErrContentRequired
+	}
+	if len([]rune(content)) > MaxContent {
+		return "", ErrContentTooLong
+	}
+	return content, nil
+}
+
+// ClientMsgID trims the client's idempotency key; empty means none.
+func ClientMsgID(raw string) (string, error) {
+	id := strings.TrimSpace(raw)
+	if len(id) > MaxClientMsgID {
+		return "", ErrClientMsgID
+	}
+	return id, nil
+}
+
+// Note trims an optional bookmark or reminder note.
+func Note(raw string) (string, error) {
+	note := strings.TrimSpace(raw)
+	if len([]rune(note)) > MaxNote {
+		return "", ErrNote
+	}
+	return note, nil
+}
+
+// ReportReasons are what a message can be reported for.
+var ReportReasons = []string{"spam", "abuse", "harassment", "illegal", "other"}
+
+// UserID trims a user id taken from a path or body.
+func UserID(raw string) (string, error) {
+	id := strings.TrimSpace(raw)
+	if id == "" || len(id) > MaxUserID {
+		return "", ErrUserID
+	}
+	return id, nil
+}
+
+// ReportReason checks a report reason against ReportReasons.
+func ReportReason(raw string) (string, error) {
+	if !slices.Contains(ReportReasons, raw) {
+		return "", ErrReportReason
+	}
+	return raw, nil
+}
+
+// RoomSort defaults an empty sort to activity and rejects unknown values.
+func RoomSort(raw string) (store.RoomSort, error) {
+	if raw == "" {
+		return store.RoomSortActivity, nil
+	}
+	sort := store.RoomSort(raw)
+	if !sort.Valid() {
+		return "", ErrSort
+	}
+	return sort, nil
+}
+
+// MessageQuery checks the seq cursors: none negative, at most one set.
+func MessageQuery(q store.MessageQuery) error {
+	set := 0
+	for _, v := range []int64{q.Before, q.After, q.Around} {
+		if v < 0 {
+			return ErrCursor
+		}
+		if v > 0 {
+			set++
+		}
+	}
+	if set > 1 {
+		return ErrCursorConflict
+	}
+	return nil
+}
+
+// Limit applies the page size default and cap.
+func Limit(n int) int {
+	if n <= 0 {
+		return DefaultLimit
+	}
+	return min(n, MaxLimit)
+}
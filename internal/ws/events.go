@@ -0,0 +1,50 @@
+package ws
+
+import (
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// Event types pushed to clients besides plain messages. Reminders are personal
+// and go to the user's connections rather than a room.
+const (
+	EventPinAdded   = "pin.added"
+	EventPinRemoved = "pin.removed"
+	EventReminder   = "reminder"
+	EventError      = "error"
+)
+
+var errNotJoined = apperr.New(apperr.CodeNotJoined, "roomId must be one of the connection's rooms")
+
+// errorFrame tells a client why one of its frames was not accepted. Codes are
+// the apperr codes REST and gRPC use; clientMsgId ties it to the refused frame.
+type errorFrame struct {
+	Type         string      `json:"type"`
+	Code         apperr.Code `json:"code"`
+	Message      string      `json:"message,omitempty"`
+	Field        string      `json:"field,omitempty"`
+	ClientMsgID  string      `json:"clientMsgId,omitempty"`
+	RetryAfterMs int64       `json:"retryAfterMs,omitempty"`
+}
+
+// Event is a room event other than a new message. Messages keep their bare
+// object shape on the wire; events carry a "type" so clients can tell them apart.
+type Event struct {
+	Type   string `json:"type"`
+	RoomID int64  `json:"roomId"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// envelope is the Redis payload shared by messages, events and block changes
+// (Blocks names the user whose blocks changed). Trace carries
+// the publisher's W3C trace context so delivery on other instances joins the
+// same trace; ReceivedAt and PublishedAt (unix microseconds) let them measure
+// delivery latency from the original receive.
+type envelope struct {
+	Message     *store.Message    `json:"message,omitempty"`
+	Event       *Event            `json:"event,omitempty"`
+	Blocks      string            `json:"blocks,omitempty"`
+	Trace       map[string]string `json:"trace,omitempty"`
+	ReceivedAt  int64             `json:"receivedAt,omitempty"`
+	PublishedAt int64             `json:"publishedAt,omitempty"`
+}
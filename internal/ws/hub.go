@@ -3,147 +3,732 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/clientip"
+	"github.com/yngus4862/chat/internal/latency"
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/metrics"
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/ratelimit"
 	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/tracing"
+	"github.com/yngus4862/chat/internal/validate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Options tunes a Hub. The zero value applies no limits.
+type Options struct {
+	// SendLimiter/SendBudget throttle inbound message frames per user (or
+	// client IP for anonymous connections).
+	SendLimiter ratelimit.Limiter
+	SendBudget  ratelimit.Budget
+
+	// AllowedOrigins lists the Origin values browsers may connect from ("*"
+	// for any, "https://*.example.com" for subdomains). Empty means same host
+	// only. Requests without an Origin header (native clients) are accepted.
+	AllowedOrigins []string
+
+	// Proxies are the trusted reverse proxies a client's address is read
+	// behind; nil trusts none and uses the peer address.
+	Proxies *clientip.Resolver
+
+	// Admission limits; zero means unlimited. MaxConnsPerUser also bounds
+	// anonymous connections per client IP.
+	MaxConns        int // per instance
+	MaxConnsPerUser int
+	MaxRoomsPerConn int
+
+	// Moderator checks inbound messages before they are stored; nil stores
+	// them as sent.
+	Moderator *moderation.Moderator
+}
+
 type Hub struct {
-	st *store.Store
-	ps *RedisPubSub
+	st       store.Store
+	ps       *RedisPubSub
+	opts     Options
+	upgrader websocket.Upgrader
+
+	mu       sync.RWMutex
+	rooms    map[int64]map[*Client]struct{}
+	subs     map[int64]func()
+	users    map[string]map[*Client]struct{}
+	userSubs map[string]func()
+
+	// admission counters, reserved before the upgrade and released on leave
+	conns     int
+	userConns map[string]int
+	ipConns   map[string]int // anonymous connections
 
-	mu    sync.RWMutex
-	rooms map[int64]map[*Client]struct{}
-	subs  map[int64]func()
+	// closing is closed by Close to end every stream.
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
+// ConnIDHeader carries a WebSocket connection's id in the handshake response,
+// so clients can quote it when reporting problems.
+const ConnIDHeader = "X-Conn-ID"
+
 type Client struct {
+	// id names the connection in logs; log is tagged with it.
+	id  string
+	log *slog.Logger
+
 	conn   *websocket.Conn
-	roomID int64
-	send   chan []byte
+	rooms  []int64
+	userID string
+	ip     string
+	send   chan Frame
 	hub    *Hub
+
+	// acks maps message ids written to this connection to their receive time
+	// once the client has sent its first ack frame.
+	acking atomic.Bool
+	ackMu  sync.Mutex
+	acks   map[int64]time.Time
+
+	// personal clients also receive the user's own events (reminders) and
+	// count as present for SendToUser.
+	personal bool
+
+	// While a sinceSeq replay runs, frames are held here rather than queued
+	// on send, so a long replay cannot overflow send and drop live messages.
+	holding atomic.Bool
+	holdMu  sync.Mutex
+	held    []Frame
+
+	// blocks are the users userID blocked; their messages are not sent here.
+	blocks atomic.Pointer[store.BlockSet]
 }
 
+// inbound is a client frame: a message to post, or with Type "ack" the
+// client's receipt of MessageID at ClientTs (unix milliseconds).
 type inbound struct {
+	Type        string `json:"type,omitempty"`
+	RoomID      int64  `json:"roomId,omitempty"`
 	Content     string `json:"content"`
 	ClientMsgID string `json:"clientMsgId,omitempty"`
+	MessageID   int64  `json:"messageId,omitempty"`
+	ClientTs    int64  `json:"clientTs,omitempty"`
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  4096,
-	WriteBufferSize: 4096,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+// Frame is one JSON frame queued for a subscriber. MessageID and ReceivedAt
+// are set for messages received with a latency stamp, so the writer can record
+// delivery latency. Seq and Author are set for messages.
+type Frame struct {
+	Data       []byte
+	MessageID  int64
+	Seq        int64
+	Author     string
+	ReceivedAt time.Time
 }
 
-func NewHub(st *store.Store, ps *RedisPubSub) *Hub {
-	return &Hub{
-		st:    st,
-		ps:    ps,
-		rooms: make(map[int64]map[*Client]struct{}),
-		subs:  make(map[int64]func()),
+// Written records a frame's socket write, which started at start, and its
+// delivery latency.
+func (f Frame) Written(start time.Time) {
+	latency.Since(latency.Write, start)
+	latency.Since(latency.Deliver, f.ReceivedAt)
+}
+
+// Stats is a snapshot of the hub's connections on this instance.
+type Stats struct {
+	Connections           int `json:"connections"`
+	Users                 int `json:"users"`
+	Rooms                 int `json:"rooms"`
+	MaxConnections        int `json:"maxConnections,omitempty"`
+	MaxConnectionsPerUser int `json:"maxConnectionsPerUser,omitempty"`
+}
+
+func NewHub(st store.Store, ps *RedisPubSub, opts Options) *Hub {
+	h := &Hub{
+		st:        st,
+		ps:        ps,
+		opts:      opts,
+		rooms:     make(map[int64]map[*Client]struct{}),
+		subs:      make(map[int64]func()),
+		users:     make(map[string]map[*Client]struct{}),
+		userSubs:  make(map[string]func()),
+		userConns: make(map[string]int),
+		ipConns:   make(map[string]int),
+		closing:   make(chan struct{}),
+	}
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin:     h.checkOrigin,
+	}
+	if ps != nil {
+		// block changes made through other instances
+		if ch, _, err := ps.SubscribeBlocks(); err == nil {
+			go func() {
+				for env := range ch {
+					if env.Blocks != "" {
+						h.reloadBlocks(context.Background(), env.Blocks)
+					}
+				}
+			}()
+		}
 	}
+	return h
 }
 
+// ServeWS upgrades a connection subscribed to one or more rooms
+// (?roomId=1&roomId=2 or ?roomId=1,2). Everything that can be refused is
+// checked before the upgrade so clients get a plain HTTP status.
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
-	roomID, err := strconv.ParseInt(r.URL.Query().Get("roomId"), 10, 64)
-	if err != nil || roomID <= 0 {
+	rooms, err := parseRooms(r.URL.Query()["roomId"])
+	if err != nil || len(rooms) == 0 {
 		http.Error(w, "invalid roomId", http.StatusBadRequest)
 		return
 	}
+	if max := h.opts.MaxRoomsPerConn; max > 0 && len(rooms) > max {
+		http.Error(w, "too many rooms (<="+strconv.Itoa(max)+")", http.StatusBadRequest)
+		return
+	}
+
+	// sinceSeq asks for every message after the given seq before live delivery
+	// starts, so a reconnecting client can close the gap it detected.
+	replay := r.URL.Query().Has("sinceSeq")
+	sinceSeq, err := strconv.ParseInt(r.URL.Query().Get("sinceSeq"), 10, 64)
+	if replay && (err != nil || sinceSeq < 0) {
+		http.Error(w, "invalid sinceSeq", http.StatusBadRequest)
+		return
+	}
+	if replay && len(rooms) > 1 {
+		http.Error(w, "sinceSeq needs a single roomId", http.StatusBadRequest)
+		return
+	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if !h.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	userID, ip := auth.UserID(r), h.opts.Proxies.IP(r)
+	blocks, err := store.LoadBlockSet(r.Context(), h.st, userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("ws load blocks failed", "user_id", userID, logging.Err(err))
+		refuse(w, err)
+		return
+	}
+	if err := h.admit(userID, ip); err != nil {
+		refuse(w, err)
+		return
+	}
+
+	id := logging.NewID()
+	conn, err := h.upgrader.Upgrade(w, r, http.Header{ConnIDHeader: {id}})
 	if err != nil {
+		h.release(userID, ip)
 		return
 	}
 
 	c := &Client{
-		conn:   conn,
-		roomID: roomID,
-		send:   make(chan []byte, 256),
-		hub:    h,
+		id:       id,
+		log:      logging.FromContext(r.Context()).With("conn_id", id, "user_id", userID),
+		conn:     conn,
+		rooms:    rooms,
+		userID:   userID,
+		ip:       ip,
+		send:     make(chan Frame, 256),
+		hub:      h,
+		personal: true,
 	}
+	c.blocks.Store(&blocks)
 
+	// Join before replaying so nothing published meanwhile is missed. Frames
+	// published meanwhile are held until the replay is written.
+	c.holding.Store(replay)
 	h.join(c)
-	go c.writePump()
+	c.log.Info("ws connected", "rooms", rooms, "client_ip", c.ip)
+	ctx := r.Context()
+	go func() {
+		if replay {
+			c.replay(ctx, sinceSeq)
+		}
+		c.writePump()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-h.closing:
+			c.goAway()
+		case <-done:
+		}
+	}()
 	c.readPump()
 }
 
+// Close ends every stream so the servers can shut down: WebSocket
+// connections get a going-away close frame and Subscribers' Done is closed.
+// Connections made afterwards end at once. Close is idempotent.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return Stats{
+		Connections:           h.conns,
+		Users:                 len(h.userConns),
+		Rooms:                 len(h.rooms),
+		MaxConnections:        h.opts.MaxConns,
+		MaxConnectionsPerUser: h.opts.MaxConnsPerUser,
+	}
+}
+
+var (
+	errTooManyConns     = apperr.New(apperr.CodeUnavailable, "too many connections")
+	errTooManyUserConns = apperr.New(apperr.CodeRateLimited, "too many connections for user")
+	errTooManyIPConns   = apperr.New(apperr.CodeRateLimited, "too many connections from this address")
+)
+
+// admit reserves a connection slot, failing when the instance, the user or,
+// for anonymous connections, the client IP is at its limit.
+func (h *Hub) admit(userID, ip string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if max := h.opts.MaxConns; max > 0 && h.conns >= max {
+		return errTooManyConns
+	}
+	counts, key, errFull := h.userConns, userID, errTooManyUserConns
+	if userID == "" {
+		counts, key, errFull = h.ipConns, ip, errTooManyIPConns
+	}
+	if key != "" {
+		if max := h.opts.MaxConnsPerUser; max > 0 && counts[key] >= max {
+			return errFull
+		}
+		counts[key]++
+	}
+	h.conns++
+	return nil
+}
+
+// refuse answers a handshake the hub turned down with the API's error body.
+// An instance at capacity asks the client to come back later.
+func refuse(w http.ResponseWriter, err error) {
+	e := apperr.From(err)
+	if e.Code == apperr.CodeUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code.HTTPStatus())
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": e.Message, "code": string(e.Code)})
+}
+
+func (h *Hub) release(userID, ip string) {
+	h.mu.Lock()
+	h.releaseLocked(userID, ip)
+	h.mu.Unlock()
+}
+
+func (h *Hub) releaseLocked(userID, ip string) {
+	h.conns--
+	counts, key := h.userConns, userID
+	if userID == "" {
+		counts, key = h.ipConns, ip
+	}
+	if key != "" {
+		if counts[key]--; counts[key] <= 0 {
+			delete(counts, key)
+		}
+	}
+}
+
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(h.opts.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range h.opts.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// "https://*.example.com" matches any subdomain of example.com
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseRooms(values []string) ([]int64, error) {
+	var out []int64
+	seen := make(map[int64]struct{})
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil || id <= 0 {
+				return nil, errors.New("invalid roomId")
+			}
+			if _, dup := seen[id]; dup {
+				continue
+			}
+			seen[id] = struct{}{}
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) inRoom(roomID int64) bool {
+	for _, id := range c.rooms {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *Hub) BroadcastMessage(ctx context.Context, msg store.Message) {
+	latency.Since(latency.Persist, latency.ReceivedAt(ctx))
+	l := logging.FromContext(ctx)
 	// publish to redis (so other instances can deliver), and also deliver locally
 	if h.ps != nil {
-		_ = h.ps.PublishMessage(ctx, msg)
+		if err := h.ps.PublishMessage(ctx, msg); err != nil {
+			l.Warn("publish message failed", "room_id", msg.RoomID, "message_id", msg.ID, logging.Err(err))
+		}
+	}
+	sent, dropped := h.deliver(ctx, msg)
+	metrics.MessageBroadcast(msg.Source)
+	l.Debug("message broadcast", "room_id", msg.RoomID, "message_id", msg.ID, "seq", msg.Seq,
+		"local_clients", sent, "dropped", dropped)
+}
+
+// BroadcastEvent fans a room event out to this and other instances.
+func (h *Hub) BroadcastEvent(ctx context.Context, ev Event) {
+	l := logging.FromContext(ctx)
+	if h.ps != nil {
+		if err := h.ps.PublishEvent(ctx, ev); err != nil {
+			l.Warn("publish event failed", "room_id", ev.RoomID, "type", ev.Type, logging.Err(err))
+		}
+	}
+	sent, dropped := h.deliverEvent(ctx, ev)
+	l.Debug("event broadcast", "room_id", ev.RoomID, "type", ev.Type,
+		"local_clients", sent, "dropped", dropped)
+}
+
+// SendToUser delivers a personal event to every connection of the user on any
+// instance and reports whether at least one instance had the user connected.
+func (h *Hub) SendToUser(ctx context.Context, userID string, ev Event) bool {
+	if h.ps != nil {
+		// Every instance holding a connection of the user, this one included, is
+		// subscribed to the user's channel, so the receiver count doubles as
+		// cluster-wide presence.
+		n, err := h.ps.PublishUserEvent(ctx, userID, ev)
+		if err == nil {
+			return n > 0
+		}
+	}
+	return h.deliverUser(userID, ev)
+}
+
+// BlocksChanged reloads the blocks of userID's connections on every instance,
+// after the user blocked or unblocked someone.
+func (h *Hub) BlocksChanged(ctx context.Context, userID string) {
+	if h.ps != nil {
+		if err := h.ps.PublishBlocks(ctx, userID); err != nil {
+			logging.FromContext(ctx).Warn("publish blocks failed", "user_id", userID, logging.Err(err))
+		}
+	}
+	h.reloadBlocks(ctx, userID)
+}
+
+func (h *Hub) reloadBlocks(ctx context.Context, userID string) {
+	blocks, err := store.LoadBlockSet(ctx, h.st, userID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("reload blocks failed", "user_id", userID, logging.Err(err))
+		return
 	}
-	h.deliver(msg)
+	h.mu.RLock()
+	for _, set := range h.rooms {
+		for c := range set {
+			if c.userID == userID {
+				c.blocks.Store(&blocks)
+			}
+		}
+	}
+	h.mu.RUnlock()
 }
 
 func (h *Hub) join(c *Client) {
 	h.mu.Lock()
-	set, ok := h.rooms[c.roomID]
-	if !ok {
-		set = make(map[*Client]struct{})
-		h.rooms[c.roomID] = set
-
-		// start redis subscription for room (once)
-		if h.ps != nil {
-			ch, cancel, err := h.ps.SubscribeRoom(c.roomID)
-			if err == nil {
-				h.subs[c.roomID] = cancel
-				go func(roomID int64, in <-chan store.Message) {
-					for msg := range in {
-						h.deliver(msg)
-					}
-				}(c.roomID, ch)
+	for _, roomID := range c.rooms {
+		set, ok := h.rooms[roomID]
+		if !ok {
+			set = make(map[*Client]struct{})
+			h.rooms[roomID] = set
+
+			// start redis subscription for room (once)
+			if h.ps != nil {
+				ch, cancel, err := h.ps.SubscribeRoom(roomID)
+				if err == nil {
+					h.subs[roomID] = cancel
+					go func(roomID int64, in <-chan envelope) {
+						for env := range in {
+							h.receive(env)
+						}
+					}(roomID, ch)
+				}
+			}
+		}
+		set[c] = struct{}{}
+	}
+
+	if c.userID != "" && c.personal {
+		uset, ok := h.users[c.userID]
+		if !ok {
+			uset = make(map[*Client]struct{})
+			h.users[c.userID] = uset
+
+			if h.ps != nil {
+				ch, cancel, err := h.ps.SubscribeUser(c.userID)
+				if err == nil {
+					h.userSubs[c.userID] = cancel
+					go func(userID string, in <-chan envelope) {
+						for env := range in {
+							if env.Event != nil {
+								h.deliverUser(userID, *env.Event)
+							}
+						}
+					}(c.userID, ch)
+				}
 			}
 		}
+		uset[c] = struct{}{}
 	}
-	set[c] = struct{}{}
 	h.mu.Unlock()
 }
 
 func (h *Hub) leave(c *Client) {
 	h.mu.Lock()
-	set, ok := h.rooms[c.roomID]
-	if ok {
+	for _, roomID := range c.rooms {
+		set, ok := h.rooms[roomID]
+		if !ok {
+			continue
+		}
 		delete(set, c)
 		if len(set) == 0 {
-			delete(h.rooms, c.roomID)
-			if cancel, ok := h.subs[c.roomID]; ok {
+			delete(h.rooms, roomID)
+			if cancel, ok := h.subs[roomID]; ok {
 				cancel()
-				delete(h.subs, c.roomID)
+				delete(h.subs, roomID)
 			}
 		}
 	}
+	if uset, ok := h.users[c.userID]; ok {
+		delete(uset, c)
+		if len(uset) == 0 {
+			delete(h.users, c.userID)
+			if cancel, ok := h.userSubs[c.userID]; ok {
+				cancel()
+				delete(h.userSubs, c.userID)
+			}
+		}
+	}
+	h.releaseLocked(c.userID, c.ip)
 	h.mu.Unlock()
 }
 
-func (h *Hub) deliver(msg store.Message) {
+// receive delivers a room envelope published by another instance, continuing
+// the publisher's trace.
+func (h *Hub) receive(env envelope) {
+	if env.PublishedAt > 0 {
+		latency.Since(latency.Broker, time.UnixMicro(env.PublishedAt))
+	}
+	ctx := tracing.Extract(context.Background(), env.Trace)
+	if env.ReceivedAt > 0 {
+		ctx = latency.WithReceived(ctx, time.UnixMicro(env.ReceivedAt))
+	}
+	switch {
+	case env.Message != nil:
+		h.deliver(ctx, *env.Message)
+	case env.Event != nil:
+		h.deliverEvent(ctx, *env.Event)
+	}
+}
+
+func (h *Hub) deliver(ctx context.Context, msg store.Message) (sent, dropped int) {
 	b, err := json.Marshal(msg)
 	if err != nil {
-		return
+		return 0, 0
+	}
+	f := Frame{Data: b, MessageID: msg.ID, Seq: msg.Seq, Author: msg.Author, ReceivedAt: latency.ReceivedAt(ctx)}
+	return h.deliverTraced(ctx, msg.RoomID, f, attribute.Int64("chat.message_id", msg.ID))
+}
+
+func (h *Hub) deliverEvent(ctx context.Context, ev Event) (sent, dropped int) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return 0, 0
+	}
+	return h.deliverTraced(ctx, ev.RoomID, Frame{Data: b}, attribute.String("chat.event_type", string(ev.Type)))
+}
+
+// deliverTraced is deliverFrame inside a "ws deliver" span recording how many
+// local clients the frame was queued for.
+func (h *Hub) deliverTraced(ctx context.Context, roomID int64, f Frame, attrs ...attribute.KeyValue) (sent, dropped int) {
+	_, span := tracing.Start(ctx, "ws deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(append(attrs, tracing.RoomID(roomID))...),
+	)
+	sent, dropped = h.deliverFrame(roomID, f)
+	span.SetAttributes(attribute.Int("chat.clients", sent), attribute.Int("chat.dropped", dropped))
+	span.End()
+	return sent, dropped
+}
+
+func (h *Hub) deliverUser(userID string, ev Event) bool {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return false
 	}
 	h.mu.RLock()
-	set := h.rooms[msg.RoomID]
+	set := h.users[userID]
 	for c := range set {
-		select {
-		case c.send <- b:
-		default:
+		c.queue(Frame{Data: b}) // drop for a slow client
+	}
+	n := len(set)
+	h.mu.RUnlock()
+	return n > 0
+}
+
+// deliverFrame queues b for every local client in the room and reports how
+// many got it and how many were skipped for being too far behind. Clients
+// whose user blocked the author are passed over.
+func (h *Hub) deliverFrame(roomID int64, f Frame) (sent, dropped int) {
+	h.mu.RLock()
+	set := h.rooms[roomID]
+	for c := range set {
+		if c.hides(f.Author) {
+			continue
+		}
+		if c.queue(f) {
+			sent++
+		} else {
 			// drop slow client
+			dropped++
 		}
 	}
 	h.mu.RUnlock()
+	metrics.FramesDropped(dropped)
+	return sent, dropped
+}
+
+// hides reports whether messages by author are kept from c.
+func (c *Client) hides(author string) bool {
+	blocks := c.blocks.Load()
+	return blocks != nil && blocks.Hides(store.Message{Author: author})
+}
+
+// maxReplay bounds how much history is pushed over WS on reconnect; clients
+// further behind page the rest through REST.
+const maxReplay = 1000
+
+// maxHeld bounds the frames held for a client during replay.
+const maxHeld = 2 * maxReplay
+
+// queue hands f to the writer without blocking and reports whether it was
+// taken; it is not when the client is too far behind.
+func (c *Client) queue(f Frame) bool {
+	if c.holding.Load() {
+		c.holdMu.Lock()
+		if c.holding.Load() {
+			ok := len(c.held) < maxHeld
+			if ok {
+				c.held = append(c.held, f)
+			}
+			c.holdMu.Unlock()
+			return ok
+		}
+		c.holdMu.Unlock()
+	}
+	select {
+	case c.send <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// replay writes missed messages straight to the connection, then the frames
+// held meanwhile, skipping messages the replay already covered. It runs
+// before writePump starts, so it is the only writer, and frames queued once
+// it stops holding are written after the held ones.
+func (c *Client) replay(ctx context.Context, sinceSeq int64) {
+	defer func() {
+		c.holdMu.Lock()
+		held := c.held
+		c.held = nil
+		c.holding.Store(false)
+		c.holdMu.Unlock()
+		for _, f := range held {
+			if f.Seq != 0 && f.Seq <= sinceSeq {
+				continue
+			}
+			if err := c.write(f); err != nil {
+				return
+			}
+		}
+	}()
+	for sent := 0; sent < maxReplay; {
+		msgs, more, err := c.hub.st.MessagesAfter(ctx, c.rooms[0], sinceSeq, 200)
+		if err != nil {
+			c.log.Warn("ws replay failed", "room_id", c.rooms[0], "since_seq", sinceSeq, logging.Err(err))
+			return
+		}
+		for _, m := range msgs {
+			if c.hides(m.Author) {
+				sinceSeq = m.Seq
+				continue
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				return
+			}
+			_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				return
+			}
+			sinceSeq = m.Seq
+		}
+		sent += len(msgs)
+		if !more {
+			return
+		}
+	}
 }
 
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.leave(c)
 		_ = c.conn.Close()
+		c.log.Info("ws disconnected")
 	}()
 
-	_ = c.conn.SetReadLimit(1 << 20)
+	c.conn.SetReadLimit(1 << 20)
 	_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -155,21 +740,132 @@ func (c *Client) readPump() {
 		if err != nil {
 			return
 		}
+		received := time.Now()
 		var in inbound
 		if err := json.Unmarshal(data, &in); err != nil {
+			c.sendError(apperr.Validation("", "invalid json"), "")
+			continue
+		}
+		if in.Type == "ack" {
+			c.ack(in.MessageID, in.ClientTs)
+			continue
+		}
+		content, err := validate.Content(in.Content)
+		if err == nil {
+			in.ClientMsgID, err = validate.ClientMsgID(in.ClientMsgID)
+		}
+		if err != nil {
+			c.sendError(err, in.ClientMsgID)
+			continue
+		}
+		// roomId may be omitted on single-room connections
+		roomID := in.RoomID
+		if roomID == 0 && len(c.rooms) == 1 {
+			roomID = c.rooms[0]
+		}
+		if !c.inRoom(roomID) {
+			c.sendError(errNotJoined, in.ClientMsgID)
 			continue
 		}
-		if len(in.Content) == 0 {
+		if !c.allowSend(in.ClientMsgID) {
 			continue
 		}
 
-		// Persist message then broadcast
-		msg, err := c.hub.st.CreateMessage(context.Background(), c.roomID, in.Content, "ws", in.ClientMsgID)
+		// Persist message then broadcast; both log under the connection's id
+		// and trace under one span per frame.
+		ctx := latency.WithReceived(logging.WithContext(context.Background(), c.log), received)
+		ctx, span := tracing.Start(ctx, "ws message",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(tracing.RoomID(roomID), attribute.String("chat.conn_id", c.id)),
+		)
+		verdict, err := c.hub.opts.Moderator.Check(ctx, content)
 		if err != nil {
+			span.End()
+			c.sendError(err, in.ClientMsgID)
 			continue
 		}
-		c.hub.BroadcastMessage(context.Background(), msg)
+		msg, err := c.hub.st.CreateMessage(ctx, roomID, c.userID, verdict.Content, "ws", in.ClientMsgID)
+		if err != nil {
+			if e := apperr.From(err); e.Code == apperr.CodeInternal {
+				c.log.Error("create message failed", "room_id", roomID, logging.Err(err))
+			}
+			span.RecordError(err)
+			span.End()
+			c.sendError(err, in.ClientMsgID)
+			continue
+		}
+		c.hub.opts.Moderator.Review(ctx, msg, c.userID, verdict)
+		c.hub.BroadcastMessage(ctx, msg)
+		span.End()
+	}
+}
+
+// maxPendingAcks bounds the ack bookkeeping of a client that stops acking.
+const maxPendingAcks = 512
+
+// ack records the client's receipt of messageID. The first ack turns on
+// bookkeeping for the connection, so that one is only used as the signal.
+func (c *Client) ack(messageID, clientTs int64) {
+	if c.acking.CompareAndSwap(false, true) || messageID <= 0 || clientTs <= 0 {
+		return
+	}
+	c.ackMu.Lock()
+	received, ok := c.acks[messageID]
+	delete(c.acks, messageID)
+	c.ackMu.Unlock()
+	if ok {
+		latency.Observe(latency.Client, time.UnixMilli(clientTs).Sub(received))
+	}
+}
+
+// written remembers when a message written to the socket was received, for a
+// later ack.
+func (c *Client) written(f Frame) {
+	if !c.acking.Load() || f.MessageID == 0 || f.ReceivedAt.IsZero() {
+		return
+	}
+	c.ackMu.Lock()
+	if c.acks == nil || len(c.acks) >= maxPendingAcks {
+		c.acks = make(map[int64]time.Time)
+	}
+	c.acks[f.MessageID] = f.ReceivedAt
+	c.ackMu.Unlock()
+}
+
+// allowSend takes a token from the client's send budget, telling the client
+// with a rate_limited frame when there is none. Limiter errors fail open.
+func (c *Client) allowSend(clientMsgID string) bool {
+	l, b := c.hub.opts.SendLimiter, c.hub.opts.SendBudget
+	if l == nil || !b.Enabled() {
+		return true
+	}
+	ok, wait, err := l.Allow(context.Background(), "ws send|"+ratelimit.Subject(c.userID, c.ip), b)
+	if err != nil || ok {
+		return true
 	}
+	c.sendFrame(errorFrame{
+		Code:         apperr.CodeRateLimited,
+		Message:      "rate limited",
+		ClientMsgID:  clientMsgID,
+		RetryAfterMs: wait.Milliseconds(),
+	})
+	return false
+}
+
+// sendError tells the client why one of its frames was refused, with the
+// codes the REST and gRPC APIs use.
+func (c *Client) sendError(err error, clientMsgID string) {
+	e := apperr.From(err)
+	c.sendFrame(errorFrame{Code: e.Code, Message: e.Message, Field: e.Field, ClientMsgID: clientMsgID})
+}
+
+func (c *Client) sendFrame(f errorFrame) {
+	f.Type = EventError
+	b, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	c.queue(Frame{Data: b})
 }
 
 func (c *Client) writePump() {
@@ -181,13 +877,13 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case msg, ok := <-c.send:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		case f, ok := <-c.send:
 			if !ok {
+				_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
 				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			if err := c.write(f); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -198,3 +894,23 @@ func (c *Client) writePump() {
 		}
 	}
 }
+
+// goAway tells the client the server is going away and closes the
+// connection, which ends readPump and with it the connection.
+func (c *Client) goAway() {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	_ = c.conn.Close()
+}
+
+// write writes one queued frame and records its delivery.
+func (c *Client) write(f Frame) error {
+	start := time.Now()
+	_ = c.conn.SetWriteDeadline(start.Add(5 * time.Second))
+	if err := c.conn.WriteMessage(websocket.TextMessage, f.Data); err != nil {
+		return err
+	}
+	f.Written(start)
+	c.written(f)
+	return nil
+}
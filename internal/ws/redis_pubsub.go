@@ -4,24 +4,31 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/yngus4862/chat/internal/latency"
+	"github.com/yngus4862/chat/internal/metrics"
 	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RedisPubSub struct {
 	client *redis.Client
 	mu     sync.Mutex
-	subs   map[int64]*redis.PubSub
+	subs   map[string]*redis.PubSub
 }
 
 func NewRedisPubSub(addr string) *RedisPubSub {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
-	return &RedisPubSub{client: rdb, subs: make(map[int64]*redis.PubSub)}
+	return &RedisPubSub{client: rdb, subs: make(map[string]*redis.PubSub)}
 }
 
 func (r *RedisPubSub) Close() error {
@@ -32,7 +39,7 @@ func (r *RedisPubSub) Close() error {
 	for _, ps := range r.subs {
 		_ = ps.Close()
 	}
-	r.subs = map[int64]*redis.PubSub{}
+	r.subs = map[string]*redis.PubSub{}
 	r.mu.Unlock()
 	return r.client.Close()
 }
@@ -48,39 +55,139 @@ func roomChannel(roomID int64) string {
 	return fmt.Sprintf("room:%d", roomID)
 }
 
+func userChannel(userID string) string {
+	return "user:" + userID
+}
+
+// blocksChannel announces block changes to every instance.
+const blocksChannel = "blocks"
+
 func (r *RedisPubSub) PublishMessage(ctx context.Context, msg store.Message) error {
+	env := envelope{Message: &msg}
+	if t := latency.ReceivedAt(ctx); !t.IsZero() {
+		env.ReceivedAt = t.UnixMicro()
+	}
+	return r.publish(ctx, msg.RoomID, env)
+}
+
+func (r *RedisPubSub) PublishEvent(ctx context.Context, ev Event) error {
+	return r.publish(ctx, ev.RoomID, envelope{Event: &ev})
+}
+
+// PublishUserEvent sends a personal event to the user's channel and returns how
+// many instances received it, i.e. 0 when the user has no live connection.
+func (r *RedisPubSub) PublishUserEvent(ctx context.Context, userID string, ev Event) (int64, error) {
+	if r == nil || r.client == nil {
+		return 0, nil
+	}
+	channel := userChannel(userID)
+	ctx, span := startPublish(ctx, channel)
+	defer span.End()
+	b, err := json.Marshal(envelope{Event: &ev, Trace: tracing.Inject(ctx)})
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.client.Publish(ctx, channel, b).Result()
+	if err != nil {
+		metrics.RedisError("publish")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return n, err
+}
+
+// PublishBlocks tells the other instances that userID's blocks changed.
+func (r *RedisPubSub) PublishBlocks(ctx context.Context, userID string) error {
 	if r == nil || r.client == nil {
 		return nil
 	}
-	b, err := json.Marshal(msg)
+	ctx, span := startPublish(ctx, blocksChannel)
+	defer span.End()
+	b, err := json.Marshal(envelope{Blocks: userID, Trace: tracing.Inject(ctx)})
 	if err != nil {
 		return err
 	}
-	return r.client.Publish(ctx, roomChannel(msg.RoomID), b).Err()
+	if err := r.client.Publish(ctx, blocksChannel, b).Err(); err != nil {
+		metrics.RedisError("publish")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
 }
 
-func (r *RedisPubSub) SubscribeRoom(roomID int64) (<-chan store.Message, func(), error) {
+func (r *RedisPubSub) publish(ctx context.Context, roomID int64, env envelope) error {
 	if r == nil || r.client == nil {
-		ch := make(chan store.Message)
+		return nil
+	}
+	channel := roomChannel(roomID)
+	ctx, span := startPublish(ctx, channel)
+	defer span.End()
+	env.Trace = tracing.Inject(ctx)
+	start := time.Now()
+	env.PublishedAt = start.UnixMicro()
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	err = r.client.Publish(ctx, channel, b).Err()
+	latency.Since(latency.Publish, start)
+	if err != nil {
+		metrics.RedisError("publish")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+func startPublish(ctx context.Context, channel string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "redis publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("redis"),
+			semconv.MessagingDestinationName(channel),
+		),
+	)
+}
+
+func (r *RedisPubSub) SubscribeRoom(roomID int64) (<-chan envelope, func(), error) {
+	return r.subscribe(roomChannel(roomID))
+}
+
+func (r *RedisPubSub) SubscribeUser(userID string) (<-chan envelope, func(), error) {
+	return r.subscribe(userChannel(userID))
+}
+
+func (r *RedisPubSub) SubscribeBlocks() (<-chan envelope, func(), error) {
+	return r.subscribe(blocksChannel)
+}
+
+func (r *RedisPubSub) subscribe(channel string) (<-chan envelope, func(), error) {
+	if r == nil || r.client == nil {
+		ch := make(chan envelope)
 		close(ch)
 		return ch, func() {}, nil
 	}
 
 	r.mu.Lock()
-	ps, ok := r.subs[roomID]
+	ps, ok := r.subs[channel]
 	if !ok {
-		ps = r.client.Subscribe(context.Background(), roomChannel(roomID))
-		r.subs[roomID] = ps
+		ps = r.client.Subscribe(context.Background(), channel)
+		r.subs[channel] = ps
 	}
 	r.mu.Unlock()
 
-	out := make(chan store.Message, 128)
+	out := make(chan envelope, 128)
 	done := make(chan struct{})
 
 	go func() {
 		defer close(out)
 		// Wait for subscription
-		_, _ = ps.ReceiveTimeout(context.Background(), 2*time.Second)
+		if _, err := ps.ReceiveTimeout(context.Background(), 2*time.Second); err != nil {
+			metrics.RedisError("subscribe")
+			slog.Warn("redis subscribe failed", "channel", channel, "err", err)
+		}
 		ch := ps.Channel()
 		for {
 			select {
@@ -90,11 +197,20 @@ func (r *RedisPubSub) SubscribeRoom(roomID int64) (<-chan store.Message, func(),
 				if !ok {
 					return
 				}
-				var msg store.Message
-				if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				var env envelope
+				if err := json.Unmarshal([]byte(m.Payload), &env); err != nil {
+					metrics.RedisError("decode")
 					continue
 				}
-				out <- msg
+				if env.Message == nil && env.Event == nil && env.Blocks == "" {
+					// bare message published by an older instance
+					var msg store.Message
+					if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil || msg.ID == 0 {
+						continue
+					}
+					env.Message = &msg
+				}
+				out <- env
 			}
 		}
 	}()
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"context"
+	"sync"
+
+	"github.com/yngus4862/chat/internal/logging"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// Subscriber receives a room's frames without a WebSocket; it backs the SSE
+// and long-poll transports. Frames are the JSON objects WS clients get: a
+// bare Message, or an Event carrying a "type".
+type Subscriber struct {
+	c    *Client
+	once sync.Once
+}
+
+// SubscribeOptions describes a non-WebSocket subscriber.
+type SubscribeOptions struct {
+	UserID string
+	// IP is the client address anonymous subscribers are admitted by.
+	IP string
+	// Personal also delivers the user's own events (reminders). Long-poll
+	// leaves it off so a waiting request is not mistaken for presence.
+	Personal bool
+	// Replay holds frames published while the caller replays history, as
+	// ServeWS does for sinceSeq, until EndReplay hands them over.
+	Replay bool
+}
+
+// Subscribe joins roomID outside of a WebSocket. It counts against the same
+// admission limits as ServeWS and fails with an *apperr.Error when full. ctx
+// supplies the logger and bounds reading the user's blocks; the subscription
+// lasts until Close.
+func (h *Hub) Subscribe(ctx context.Context, roomID int64, o SubscribeOptions) (*Subscriber, error) {
+	blocks, err := store.LoadBlockSet(ctx, h.st, o.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.admit(o.UserID, o.IP); err != nil {
+		return nil, err
+	}
+	id := logging.NewID()
+	c := &Client{
+		id:       id,
+		log:      logging.FromContext(ctx).With("conn_id", id),
+		rooms:    []int64{roomID},
+		userID:   o.UserID,
+		ip:       o.IP,
+		send:     make(chan Frame, 256),
+		hub:      h,
+		personal: o.Personal,
+	}
+	c.blocks.Store(&blocks)
+	c.holding.Store(o.Replay)
+	h.join(c)
+	return &Subscriber{c: c}, nil
+}
+
+// Frames yields frames in delivery order, without messages of users the
+// subscriber blocked. Frames are dropped, as for WS clients, when the
+// subscriber falls 256 behind, or 2000 while a replay holds them. Call
+// Frame.Written after
+// sending one to record its delivery latency.
+func (s *Subscriber) Frames() <-chan Frame { return s.c.send }
+
+// EndReplay stops holding frames and returns those held since Subscribe,
+// except messages with a seq up to afterSeq, which the replay covered. Write
+// them before reading Frames; frames published from now on go to Frames.
+func (s *Subscriber) EndReplay(afterSeq int64) []Frame {
+	c := s.c
+	c.holdMu.Lock()
+	held := c.held
+	c.held = nil
+	c.holding.Store(false)
+	c.holdMu.Unlock()
+	out := held[:0]
+	for _, f := range held {
+		if f.Seq == 0 || f.Seq > afterSeq {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Hides reports whether m is kept from the subscriber, for messages it reads
+// from the store rather than from Frames.
+func (s *Subscriber) Hides(m store.Message) bool { return s.c.hides(m.Author) }
+
+// Done is closed when the hub is closed for shutdown; the caller should end
+// its stream then.
+func (s *Subscriber) Done() <-chan struct{} { return s.c.hub.closing }
+
+// Close leaves the room and releases the admission slot. It is idempotent.
+func (s *Subscriber) Close() {
+	s.once.Do(func() { s.c.hub.leave(s.c) })
+}
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_chat_rooms_name_id;
+DROP INDEX IF EXISTS idx_chat_rooms_activity;
+
+ALTER TABLE chat_rooms
+  DROP COLUMN IF EXISTS last_message_at,
+  DROP COLUMN IF EXISTS last_message_id;
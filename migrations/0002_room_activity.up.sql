@@ -0,0 +1,18 @@
+ALTER TABLE chat_rooms
+  ADD COLUMN IF NOT EXISTS last_message_id BIGINT,
+  ADD COLUMN IF NOT EXISTS last_message_at TIMESTAMPTZ;
+
+UPDATE chat_rooms r
+SET last_message_id = m.id,
+    last_message_at = m.created_at
+FROM (
+  SELECT DISTINCT ON (room_id) room_id, id, created_at
+  FROM messages
+  ORDER BY room_id, id DESC
+) m
+WHERE m.room_id = r.id;
+
+CREATE INDEX IF NOT EXISTS idx_chat_rooms_activity
+  ON chat_rooms ((COALESCE(last_message_at, created_at)) DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_chat_rooms_name_id ON chat_rooms(name, id);
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_room_id_seq_key;
+ALTER TABLE messages DROP COLUMN IF EXISTS seq;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS last_seq;
@@ -0,0 +1,16 @@
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+UPDATE messages m
+SET seq = s.seq
+FROM (
+  SELECT id, row_number() OVER (PARTITION BY room_id ORDER BY id) AS seq
+  FROM messages
+) s
+WHERE s.id = m.id;
+
+UPDATE chat_rooms r
+SET last_seq = COALESCE((SELECT max(seq) FROM messages WHERE room_id = r.id), 0);
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+ALTER TABLE messages ADD CONSTRAINT messages_room_id_seq_key UNIQUE (room_id, seq);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS room_members (
+  room_id BIGINT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(128) NOT NULL,
+  is_admin BOOLEAN NOT NULL DEFAULT false,
+  joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_members_user_id ON room_members(user_id);
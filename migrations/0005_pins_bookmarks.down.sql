@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_bookmarks;
+DROP TABLE IF EXISTS room_pins;
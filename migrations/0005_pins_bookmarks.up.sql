@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS room_pins (
+  room_id BIGINT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  position INTEGER NOT NULL,
+  pinned_by VARCHAR(128) NOT NULL,
+  pinned_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (room_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_pins_room_position ON room_pins(room_id, position);
+
+CREATE TABLE IF NOT EXISTS user_bookmarks (
+  id BIGSERIAL PRIMARY KEY,
+  user_id VARCHAR(128) NOT NULL,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  note VARCHAR(500) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_bookmarks_user_id_id_desc ON user_bookmarks(user_id, id DESC);
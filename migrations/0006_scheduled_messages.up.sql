@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+  id BIGSERIAL PRIMARY KEY,
+  kind VARCHAR(16) NOT NULL DEFAULT 'message',
+  user_id VARCHAR(128) NOT NULL,
+  room_id BIGINT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+  content TEXT NOT NULL DEFAULT '',
+  send_at TIMESTAMPTZ NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  locked_by VARCHAR(128),
+  locked_until TIMESTAMPTZ,
+  sent_message_id BIGINT,
+  last_error TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (kind IN ('message', 'reminder')),
+  CHECK (status IN ('pending', 'processing', 'sent', 'failed')),
+  CHECK (kind <> 'reminder' OR message_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due
+  ON scheduled_messages(send_at) WHERE status IN ('pending', 'processing');
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_user
+  ON scheduled_messages(user_id, send_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS retention_purges;
+DROP INDEX IF EXISTS idx_messages_room_id_created_at;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS legal_hold;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS retention_days;
@@ -0,0 +1,16 @@
+-- retention_days: NULL follows the global RETENTION_DAYS, 0 keeps forever.
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS retention_days INTEGER CHECK (retention_days >= 0);
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_id_created_at ON messages(room_id, created_at);
+
+-- Purge audit trail. No foreign key: the record outlives the room.
+CREATE TABLE IF NOT EXISTS retention_purges (
+  id BIGSERIAL PRIMARY KEY,
+  room_id BIGINT NOT NULL,
+  cutoff TIMESTAMPTZ NOT NULL,
+  deleted BIGINT NOT NULL,
+  purged_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_purges_room_id_id ON retention_purges(room_id, id DESC);
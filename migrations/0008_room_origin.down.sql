@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chat_rooms_origin;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS origin;
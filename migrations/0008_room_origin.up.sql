@@ -0,0 +1,5 @@
+-- Where an imported room came from (see internal/archive); NULL for rooms
+-- created here. Re-importing the same archive finds the room by it.
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS origin VARCHAR(256);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_rooms_origin ON chat_rooms(origin);
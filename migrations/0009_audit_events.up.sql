@@ -0,0 +1,29 @@
+-- Append-only record of administrative and security-relevant actions.
+CREATE TABLE IF NOT EXISTS audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  actor VARCHAR(128) NOT NULL,
+  action VARCHAR(64) NOT NULL,
+  target_type VARCHAR(32) NOT NULL DEFAULT '',
+  target_id VARCHAR(128) NOT NULL DEFAULT '',
+  request_id VARCHAR(128) NOT NULL DEFAULT '',
+  remote_addr VARCHAR(128) NOT NULL DEFAULT '',
+  user_agent VARCHAR(256) NOT NULL DEFAULT '',
+  details JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action_id ON audit_events(action, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_type, target_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_at ON audit_events(at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+  BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
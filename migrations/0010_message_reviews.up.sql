@@ -0,0 +1,15 @@
+-- Moderation review queue: messages a filter flagged, awaiting an admin.
+-- reasons is the comma-separated list of filters that flagged the message.
+CREATE TABLE IF NOT EXISTS message_reviews (
+  id BIGSERIAL PRIMARY KEY,
+  message_id BIGINT NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+  room_id BIGINT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(128) NOT NULL DEFAULT '',
+  reasons TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  reviewed_by VARCHAR(128),
+  reviewed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_reviews_status_id ON message_reviews(status, id DESC);
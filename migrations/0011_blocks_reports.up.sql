@@ -0,0 +1,26 @@
+-- Users a user has blocked. The server keeps a blocked user's messages (by
+-- messages.author, added in 0012) and reminders of them from the blocker.
+CREATE TABLE IF NOT EXISTS user_blocks (
+  user_id VARCHAR(128) NOT NULL,
+  blocked_id VARCHAR(128) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, blocked_id),
+  CHECK (user_id <> blocked_id)
+);
+
+-- Messages users reported to the admins. A user reports a message once.
+CREATE TABLE IF NOT EXISTS message_reports (
+  id BIGSERIAL PRIMARY KEY,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  room_id BIGINT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+  reporter VARCHAR(128) NOT NULL,
+  reason VARCHAR(32) NOT NULL,
+  note VARCHAR(500) NOT NULL DEFAULT '',
+  status VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved', 'dismissed')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  resolved_by VARCHAR(128),
+  resolved_at TIMESTAMPTZ,
+  UNIQUE (message_id, reporter)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_reports_status_id ON message_reports(status, id DESC);
@@ -0,0 +1,3 @@
+-- The user who posted a message, so blocks can be enforced on delivery.
+-- Empty for anonymous posts and for messages stored before this migration.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS author VARCHAR(128) NOT NULL DEFAULT '';
@@ -1,13 +0,0 @@
--- Migration for initial chat schema
-CREATE TABLE IF NOT EXISTS chat_rooms (
-    id SERIAL PRIMARY KEY,
-    name VARCHAR(255) NOT NULL,
-    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);
-
-CREATE TABLE IF NOT EXISTS messages (
-    id SERIAL PRIMARY KEY,
-    room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
-    content TEXT NOT NULL,
-    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);
\ No newline at end of file
@@ -0,0 +1,10 @@
+// Package migrations embeds the schema migrations so chatd can apply them
+// itself. Files follow the golang-migrate naming scheme
+// (NNNN_name.up.sql / NNNN_name.down.sql) and either tool can manage a
+// database.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
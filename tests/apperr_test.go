@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+func TestAppErrFrom(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		code   apperr.Code
+		status int
+	}{
+		{"store sentinel", store.ErrRoomNotFound, apperr.CodeRoomNotFound, http.StatusNotFound},
+		{"wrapped sentinel", fmt.Errorf("post: %w", store.ErrPinLimit), apperr.CodeConflict, http.StatusConflict},
+		{"fk violation", &pgconn.PgError{Code: "23503", Message: `insert violates foreign key "messages_room_id_fkey"`}, apperr.CodeNotFound, http.StatusNotFound},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, apperr.CodeConflict, http.StatusConflict},
+		{"unknown", errors.New(`pq: relation "chat_rooms" does not exist`), apperr.CodeInternal, http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := apperr.From(tc.err)
+			if e.Code != tc.code || e.Code.HTTPStatus() != tc.status {
+				t.Fatalf("code=%s status=%d want %s/%d", e.Code, e.Code.HTTPStatus(), tc.code, tc.status)
+			}
+		})
+	}
+}
+
+func TestAppErrDoesNotLeakCauses(t *testing.T) {
+	e := apperr.From(&pgconn.PgError{Code: "23503", Message: "SQL detail"})
+	if e.Message != "referenced resource not found" {
+		t.Fatalf("client message %q exposes the cause", e.Message)
+	}
+	if !errors.As(e, new(*pgconn.PgError)) {
+		t.Fatal("cause must stay reachable for logs")
+	}
+}
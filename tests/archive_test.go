@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/archive"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+func TestRoomExportImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	src := store.NewMemory()
+	r := api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: src}})
+
+	w := do(r, http.MethodPost, "/v1/rooms", "alice", `{"name":"general"}`)
+	var room store.Room
+	_ = json.Unmarshal(w.Body.Bytes(), &room)
+	base := "/v1/rooms/" + strconv.FormatInt(room.ID, 10)
+	for i := 1; i <= 3; i++ {
+		do(r, http.MethodPost, base+"/messages", "alice", `{"content":"m`+strconv.Itoa(i)+`","clientMsgId":"c-`+strconv.Itoa(i)+`"}`)
+	}
+
+	if w = do(r, http.MethodGet, base+"/export", "bob", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("export by non-admin: %d %s", w.Code, w.Body)
+	}
+	w = do(r, http.MethodGet, base+"/export", "alice", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	dump := w.Body.String()
+	var types []string
+	sc := bufio.NewScanner(strings.NewReader(dump))
+	for sc.Scan() {
+		var l archive.Line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		types = append(types, l.Type)
+	}
+	if strings.Join(types, ",") != "room,member,message,message,message,end" {
+		t.Fatalf("archive lines = %v", types)
+	}
+
+	dst := store.NewMemory()
+	mux := http.NewServeMux()
+	for _, rt := range archive.Routes(dst) {
+		mux.HandleFunc(rt.Pattern, rt.Handler)
+	}
+	var res archive.Result
+	w = do(mux, http.MethodPost, "/admin/rooms/import", "", dump)
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || !res.RoomCreated || res.Members != 1 || res.Messages != 3 {
+		t.Fatalf("import: %d %s", w.Code, w.Body)
+	}
+	w = do(mux, http.MethodPost, "/admin/rooms/import", "", dump)
+	var again archive.Result
+	if err := json.Unmarshal(w.Body.Bytes(), &again); err != nil || again.RoomCreated || again.RoomID != res.RoomID || again.Messages != 0 || again.Duplicates != 3 {
+		t.Fatalf("re-import: %d %s", w.Code, w.Body)
+	}
+
+	msgs, _, _ := dst.MessagesAfter(context.Background(), res.RoomID, 0, 10)
+	if len(msgs) != 3 || msgs[0].Content != "m1" || msgs[2].ClientMsgID != "c-3" {
+		t.Fatalf("imported messages = %+v", msgs)
+	}
+	if w = do(mux, http.MethodGet, "/admin/rooms/"+strconv.FormatInt(res.RoomID, 10)+"/export", "", ""); !strings.Contains(w.Body.String(), `"content":"m3"`) {
+		t.Fatalf("admin export: %d %s", w.Code, w.Body)
+	}
+
+	// A cut-off archive, a wrong count or lines after the end are rejected.
+	lines := strings.SplitAfter(strings.TrimSuffix(dump, "\n"), "\n")
+	truncated := strings.Join(lines[:len(lines)-1], "")
+	miscounted := strings.Join(lines[:len(lines)-2], "") + lines[len(lines)-1]
+	for _, bad := range []string{
+		`{"type":"message","message":{"content":"x","clientMsgId":"y"}}`,
+		`{"type":"room","room":{"version":99,"origin":"o","name":"n"}}`,
+		`not json`,
+		truncated,
+		miscounted,
+		dump + `{"type":"end","messages":3}`,
+	} {
+		if w = do(mux, http.MethodPost, "/admin/rooms/import", "", bad); w.Code != http.StatusBadRequest {
+			t.Errorf("import %q: %d %s", bad, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/archive"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/clientip"
+	"github.com/yngus4862/chat/internal/control"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+func TestAuditLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := store.NewMemory()
+	rec := audit.New(st)
+	r := api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: st, Audit: rec}})
+
+	w := do(r, http.MethodPost, "/v1/rooms", "alice", `{"name":"general"}`)
+	var room store.Room
+	_ = json.Unmarshal(w.Body.Bytes(), &room)
+	roomID := strconv.FormatInt(room.ID, 10)
+	msg := do(r, http.MethodPost, "/v1/rooms/"+roomID+"/messages", "alice", `{"content":"hi","clientMsgId":"c-1"}`)
+	var m store.Message
+	_ = json.Unmarshal(msg.Body.Bytes(), &m)
+	do(r, http.MethodPost, "/v1/rooms/"+roomID+"/pins", "alice", `{"messageId":`+strconv.FormatInt(m.ID, 10)+`}`)
+
+	emitter, _ := control.New()
+	status := func() control.Status { return control.BuildStatus(time.Now(), "", "", "") }
+	admin := control.AdminHandler("secret", emitter, status, rec.Record, append(archive.Routes(st), rec.Routes()...)...)
+	adminDo := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("X-Request-ID", "req-42")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+	if w := adminDo(http.MethodGet, "/admin/audit", "wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("bad token: %d", w.Code)
+	}
+	// Repeated failures from the same address are counted, not logged one by one.
+	for _, token := range []string{"wrong again", "", "secretx"} {
+		if w := adminDo(http.MethodGet, "/admin/status", token); w.Code == http.StatusOK {
+			t.Fatalf("token %q accepted", token)
+		}
+	}
+	if w := adminDo(http.MethodGet, "/admin/rooms/"+roomID+"/export", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", w.Code, w.Body)
+	}
+
+	w = adminDo(http.MethodGet, "/admin/audit", "secret")
+	var page struct {
+		Items      []store.AuditEvent `json:"items"`
+		NextCursor int64              `json:"nextCursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("audit: %d %s", w.Code, w.Body)
+	}
+	var actions []string
+	for _, e := range page.Items {
+		actions = append(actions, e.Actor+":"+e.Action)
+	}
+	want := []string{"admin:room.export", "admin:admin.auth_failed", "alice:pin.add", "alice:room.create"}
+	if len(actions) != len(want) {
+		t.Fatalf("audit log = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("audit log = %v, want %v", actions, want)
+		}
+	}
+	if e := page.Items[0]; e.TargetType != "room" || e.TargetID != roomID || e.RequestID != "req-42" || string(e.Details) != `{"status":200}` {
+		t.Fatalf("export event = %+v", e)
+	}
+
+	w = adminDo(http.MethodGet, "/admin/audit?actor=alice&limit=1", "secret")
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Items) != 1 || page.Items[0].Action != "pin.add" || page.NextCursor == 0 {
+		t.Fatalf("first page: %d %s", w.Code, w.Body)
+	}
+	w = adminDo(http.MethodGet, "/admin/audit?actor=alice&limit=1&cursor="+strconv.FormatInt(page.NextCursor, 10), "secret")
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Items) != 1 || page.Items[0].Action != "room.create" || page.NextCursor != 0 {
+		t.Fatalf("second page: %d %s", w.Code, w.Body)
+	}
+	if w := adminDo(http.MethodGet, "/admin/audit?since=yesterday", "secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad since: %d", w.Code)
+	}
+}
+
+func TestAuditRecordsTrustedClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := store.NewMemory()
+	proxies, err := clientip.New([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: st, Audit: audit.New(st)}, Proxies: proxies})
+
+	create := func(peer, xff string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/rooms", strings.NewReader(`{"name":"general"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "alice")
+		req.Header.Set("X-Forwarded-For", xff)
+		req.RemoteAddr = peer
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	create("10.0.0.1:5000", "6.6.6.6, 203.0.113.7") // through the proxy
+	create("198.51.100.4:5000", "6.6.6.6")          // direct, claiming to be proxied
+
+	events, _, err := st.ListAudit(context.Background(), store.AuditQuery{Action: audit.ActionRoomCreate})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("events = %+v, %v", events, err)
+	}
+	if events[1].RemoteAddr != "203.0.113.7" || events[0].RemoteAddr != "198.51.100.4" {
+		t.Fatalf("remote addrs = %q, %q", events[1].RemoteAddr, events[0].RemoteAddr)
+	}
+}
+
+func TestSQLiteAuditIsAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	st, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.AppendAudit(context.Background(), store.AuditEvent{Actor: "admin", Action: "admin.stop"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = st.Close()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE audit_events SET actor = 'mallory'`); err == nil {
+		t.Fatal("update of audit_events succeeded")
+	}
+	if _, err := db.Exec(`DELETE FROM audit_events`); err == nil {
+		t.Fatal("delete from audit_events succeeded")
+	}
+}
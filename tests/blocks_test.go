@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/auth"
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/scheduler"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+	chatv1 "github.com/yngus4862/chat/proto/chat/v1"
+)
+
+// blockServer serves the REST API and /ws over one hub.
+func blockServer(t *testing.T, st store.Store, hub *ws.Hub) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: st, Hub: hub}, WS: hub.ServeWS}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request sends a REST request as user, failing the test unless it answers
+// with status.
+func request(t *testing.T, srv *httptest.Server, method, path, user, body string, status int) {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	req.Header.Set(auth.UserHeader, user)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != status {
+		t.Fatalf("%s %s as %s: status %d, want %d", method, path, user, res.StatusCode, status)
+	}
+}
+
+func TestBlockedAuthorNotDelivered(t *testing.T) {
+	st := store.NewMemory()
+	hub := ws.NewHub(st, nil, ws.Options{})
+	srv := blockServer(t, st, hub)
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	roomPath := "/v1/rooms/" + strconv.FormatInt(room.ID, 10)
+	post := func(user, content string) {
+		t.Helper()
+		request(t, srv, http.MethodPost, roomPath+"/messages", user,
+			`{"content":"`+content+`","clientMsgId":"c-`+content+`"}`, http.StatusCreated)
+	}
+	header := http.Header{auth.UserHeader: {"alice"}}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?roomId=" + strconv.FormatInt(room.ID, 10)
+
+	// Blocking takes effect on a connection that is already open.
+	live := dialWS(t, wsURL, header)
+	request(t, srv, http.MethodPut, "/v1/me/blocks/bob", "alice", "", http.StatusCreated)
+	post("bob", "hidden")
+	post("carol", "shown")
+	if f := readFrame(t, live); f["content"] != "shown" || f["author"] != "carol" {
+		t.Fatalf("live frame = %v", f)
+	}
+
+	// Replay skips the blocked author.
+	replay := dialWS(t, wsURL+"&sinceSeq=0", header)
+	if f := readFrame(t, replay); f["content"] != "shown" {
+		t.Fatalf("replayed frame = %v", f)
+	}
+
+	// SSE resume skips it too.
+	first := must(st.CreateMessage(ctx, room.ID, "carol", "first", "rest", "c-first"))(t)
+	post("bob", "hidden again")
+	post("carol", "shown again")
+	sseCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(sseCtx, http.MethodGet, srv.URL+roomPath+"/events", nil)
+	req.Header.Set(auth.UserHeader, "alice")
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(first.ID, 10))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	ev, err := readSSE(bufio.NewReader(res.Body))
+	if err != nil || !strings.Contains(ev.data, `"content":"shown again"`) {
+		t.Fatalf("sse event = %+v, %v", ev, err)
+	}
+
+	// Long-poll leaves it out of the page but moves lastSeq past it.
+	pollAs := func(user string, after int64) pollPage {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+roomPath+"/messages/poll?timeout=0&after="+strconv.FormatInt(after, 10), nil)
+		req.Header.Set(auth.UserHeader, user)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var page pollPage
+		if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	page := pollAs("alice", first.Seq)
+	if len(page.Items) != 1 || page.Items[0].Content != "shown again" || page.LastSeq != first.Seq+2 {
+		t.Fatalf("alice's poll = %+v", page)
+	}
+	if page := pollAs("alice", first.Seq+2); len(page.Items) != 0 || page.LastSeq != first.Seq+2 {
+		t.Fatalf("empty poll = %+v", page)
+	}
+	if page := pollAs("dave", first.Seq); len(page.Items) != 2 {
+		t.Fatalf("dave's poll = %+v", page)
+	}
+
+	// Unblocking lets the author through again.
+	request(t, srv, http.MethodDelete, "/v1/me/blocks/bob", "alice", "", http.StatusNoContent)
+	post("bob", "welcome back")
+	for {
+		f := readFrame(t, live)
+		if f["author"] == "bob" {
+			if f["content"] != "welcome back" {
+				t.Fatalf("frame after unblock = %v", f)
+			}
+			break
+		}
+	}
+}
+
+func TestGRPCStreamSkipsBlockedAuthor(t *testing.T) {
+	st := store.NewMemory()
+	hub := ws.NewHub(st, nil, ws.Options{})
+	c := grpcClient(t, st, hub, nil, ratelimit.Budgets{})
+	ctx, cancel := context.WithTimeout(asUser("alice"), 5*time.Second)
+	defer cancel()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	if _, _, err := st.BlockUser(ctx, "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	postAs := func(user, text string) {
+		t.Helper()
+		if _, err := c.PostMessage(asUser(user), &chatv1.PostMessageRequest{RoomId: room.ID, Content: text, ClientMsgId: "c-" + text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	postAs("bob", "old hidden")
+	postAs("carol", "old shown")
+
+	since := int64(0)
+	s, err := c.StreamRoom(ctx, &chatv1.StreamRoomRequest{RoomId: room.ID, SinceSeq: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := s.Recv(); err != nil || ev.GetMessage().GetContent() != "old shown" || ev.GetMessage().GetAuthor() != "carol" {
+		t.Fatalf("replay: %+v, %v", ev, err)
+	}
+	postAs("bob", "new hidden")
+	postAs("carol", "new shown")
+	for {
+		ev, err := s.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.GetMessage() == nil {
+			continue
+		}
+		if ev.GetMessage().GetContent() != "new shown" || ev.GetMessage().GetAuthor() != "carol" {
+			t.Fatalf("live: %+v", ev)
+		}
+		break
+	}
+}
+
+func TestReminderOfBlockedAuthorDropped(t *testing.T) {
+	st := store.NewMemory()
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	msg := must(st.CreateMessage(ctx, room.ID, "bob", "call me", "rest", "c-1"))(t)
+	hub := ws.NewHub(st, nil, ws.Options{})
+	notifier := &recordingNotifier{}
+	s := scheduler.New(st, hub, moderation.New(st), notifier, time.Second)
+
+	if _, _, err := st.BlockUser(ctx, "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	sm := must(st.ScheduleReminder(ctx, "alice", msg.ID, "", time.Now().Add(-time.Second)))(t)
+	sub := must(hub.Subscribe(ctx, room.ID, ws.SubscribeOptions{UserID: "alice", Personal: true}))(t)
+	defer sub.Close()
+	s.RunOnce(ctx)
+	select {
+	case f := <-sub.Frames():
+		t.Fatalf("frame = %s", f.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	sub.Close()
+
+	// Offline, no push goes out either.
+	must(st.ScheduleReminder(ctx, "alice", msg.ID, "again", time.Now().Add(-time.Second)))(t)
+	s.RunOnce(ctx)
+	if notifier.count() != 0 {
+		t.Fatalf("pushed: %+v", notifier.sent)
+	}
+	if _, ok := scheduledByID(t, st, "alice", sm.ID); ok {
+		t.Error("dropped reminder still listed")
+	}
+
+	// Others still get their reminders of bob's message.
+	must(st.ScheduleReminder(ctx, "carol", msg.ID, "", time.Now().Add(-time.Second)))(t)
+	s.RunOnce(ctx)
+	if notifier.count() != 1 {
+		t.Fatalf("carol's push = %+v", notifier.sent)
+	}
+}
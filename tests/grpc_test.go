@@ -0,0 +1,305 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yngus4862/chat/internal/apperr"
+	"github.com/yngus4862/chat/internal/audit"
+	"github.com/yngus4862/chat/internal/grpcapi"
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+	chatv1 "github.com/yngus4862/chat/proto/chat/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves grpcapi over an in-memory listener and dials it.
+func grpcClient(t *testing.T, st store.Store, hub *ws.Hub, limiter ratelimit.Limiter, budgets ratelimit.Budgets) chatv1.ChatServiceClient {
+	t.Helper()
+	mod := moderation.New(st, moderation.Words(moderation.Reject, "forbidden"))
+	srv := grpcapi.NewServer(st, hub, audit.New(st), mod, limiter, budgets, nil)
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return chatv1.NewChatServiceClient(conn)
+}
+
+func asUser(user string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-user-id", user)
+}
+
+// reason is the apperr code carried in the status ErrorInfo.
+func reason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+func TestGRPCPostAndListMessages(t *testing.T) {
+	st := store.NewMemory()
+	c := grpcClient(t, st, nil, nil, ratelimit.Budgets{})
+	ctx := asUser("alice")
+
+	room, err := c.CreateRoom(ctx, &chatv1.CreateRoomRequest{Name: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header metadata.MD
+	for _, text := range []string{"one", "two", "three"} {
+		m, err := c.PostMessage(ctx, &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: text, ClientMsgId: "c-" + text}, grpc.Header(&header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.GetSource() != "grpc" || m.GetContent() != text {
+			t.Fatalf("posted %+v", m)
+		}
+	}
+	if len(header.Get("x-request-id")) != 1 {
+		t.Errorf("x-request-id header = %v", header.Get("x-request-id"))
+	}
+
+	again, err := c.PostMessage(ctx, &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: "one", ClientMsgId: "c-one"})
+	if err != nil || again.GetSeq() != 1 {
+		t.Fatalf("retried clientMsgId: %+v, %v", again, err)
+	}
+
+	page, err := c.ListMessages(ctx, &chatv1.ListMessagesRequest{RoomId: room.GetId(), Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.GetItems()) != 2 || !page.GetHasMore() {
+		t.Fatalf("first page: %+v", page)
+	}
+	page, err = c.ListMessages(ctx, &chatv1.ListMessagesRequest{RoomId: room.GetId(), Before: page.GetNextCursor(), Limit: 2})
+	if err != nil || len(page.GetItems()) != 1 || page.GetHasMore() {
+		t.Fatalf("second page: %+v, %v", page, err)
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	st := store.NewMemory()
+	c := grpcClient(t, st, nil, nil, ratelimit.Budgets{})
+	ctx := asUser("alice")
+	room, err := c.CreateRoom(ctx, &chatv1.CreateRoomRequest{Name: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		call   func() error
+		code   codes.Code
+		reason apperr.Code
+	}{
+		{"blank name", func() error {
+			_, err := c.CreateRoom(ctx, &chatv1.CreateRoomRequest{Name: " "})
+			return err
+		}, codes.InvalidArgument, apperr.CodeValidation},
+		{"missing room", func() error {
+			_, err := c.PostMessage(ctx, &chatv1.PostMessageRequest{RoomId: 999, Content: "hi", ClientMsgId: "c-1"})
+			return err
+		}, codes.NotFound, apperr.CodeRoomNotFound},
+		{"rejected content", func() error {
+			_, err := c.PostMessage(ctx, &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: "forbidden", ClientMsgId: "c-2"})
+			return err
+		}, codes.FailedPrecondition, apperr.CodeRejected},
+		{"bad room id", func() error {
+			_, err := c.ListMessages(ctx, &chatv1.ListMessagesRequest{RoomId: 0})
+			return err
+		}, codes.InvalidArgument, apperr.CodeValidation},
+		{"stream without hub", func() error {
+			s, err := c.StreamRoom(ctx, &chatv1.StreamRoomRequest{RoomId: room.GetId()})
+			if err != nil {
+				return err
+			}
+			_, err = s.Recv()
+			return err
+		}, codes.Unavailable, apperr.CodeUnavailable},
+	}
+	for _, tc := range cases {
+		err := tc.call()
+		if status.Code(err) != tc.code || reason(err) != string(tc.reason) {
+			t.Errorf("%s: %v (reason %q), want %s/%s", tc.name, err, reason(err), tc.code, tc.reason)
+		}
+	}
+}
+
+func TestGRPCStreamRoom(t *testing.T) {
+	st := store.NewMemory()
+	hub := ws.NewHub(st, nil, ws.Options{})
+	c := grpcClient(t, st, hub, nil, ratelimit.Budgets{})
+	ctx, cancel := context.WithTimeout(asUser("alice"), 5*time.Second)
+	defer cancel()
+
+	room, err := c.CreateRoom(ctx, &chatv1.CreateRoomRequest{Name: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"one", "two"} {
+		if _, err := c.PostMessage(ctx, &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: text, ClientMsgId: "c-" + text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	since := int64(1)
+	s, err := c.StreamRoom(ctx, &chatv1.StreamRoomRequest{RoomId: room.GetId(), SinceSeq: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := s.Recv()
+	if err != nil || ev.GetMessage().GetContent() != "two" {
+		t.Fatalf("replay: %+v, %v", ev, err)
+	}
+
+	// The subscription is attached before the replay, so a post made now
+	// must arrive live, exactly once.
+	if _, err := c.PostMessage(ctx, &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: "three", ClientMsgId: "c-three"}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ev, err = s.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.GetMessage() != nil {
+			break
+		}
+	}
+	if ev.GetMessage().GetContent() != "three" || ev.GetMessage().GetSeq() != 3 {
+		t.Fatalf("live: %+v", ev)
+	}
+}
+
+func TestGRPCStreamReplayKeepsLiveMessages(t *testing.T) {
+	st := store.NewMemory()
+	ctx, cancel := context.WithTimeout(asUser("alice"), 10*time.Second)
+	defer cancel()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	// Large enough that the replay outgrows the flow-control window and
+	// blocks while the client is not reading.
+	body := strings.Repeat("x", 4096)
+	for i := range 1000 {
+		must(st.CreateMessage(ctx, room.ID, "", body, "rest", "old-"+strconv.Itoa(i)))(t)
+	}
+	hub := ws.NewHub(st, nil, ws.Options{})
+	c := grpcClient(t, st, hub, nil, ratelimit.Budgets{})
+	since := int64(0)
+	s, err := c.StreamRoom(ctx, &chatv1.StreamRoomRequest{RoomId: room.ID, SinceSeq: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); hub.Stats().Rooms == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("stream never joined")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// More live messages than the frame buffer holds arrive mid-replay.
+	for i := range 400 {
+		hub.BroadcastMessage(ctx, must(st.CreateMessage(ctx, room.ID, "", "live", "rest", "live-"+strconv.Itoa(i)))(t))
+	}
+
+	for want := int64(1); want <= 1400; {
+		ev, err := s.Recv()
+		if err != nil {
+			t.Fatalf("waiting for seq %d: %v", want, err)
+		}
+		if ev.GetMessage() == nil {
+			continue
+		}
+		if ev.GetMessage().GetSeq() != want {
+			t.Fatalf("got seq %d, want %d", ev.GetMessage().GetSeq(), want)
+		}
+		want++
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	st := store.NewMemory()
+	hub := ws.NewHub(st, nil, ws.Options{})
+	budgets := ratelimit.Budgets{Routes: map[string]ratelimit.Budget{
+		"POST /v1/rooms/:roomId/messages": {Rate: 0.001, Burst: 1},
+		"GET /v1/rooms/:roomId/events":    {Rate: 0.001, Burst: 1},
+	}}
+	c := grpcClient(t, st, hub, ratelimit.NewMemory(), budgets)
+	ctx, cancel := context.WithTimeout(asUser("alice"), 5*time.Second)
+	defer cancel()
+
+	room, err := c.CreateRoom(ctx, &chatv1.CreateRoomRequest{Name: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: "hi", ClientMsgId: "c-1"}
+	if _, err := c.PostMessage(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	var header metadata.MD
+	_, err = c.PostMessage(ctx, post, grpc.Header(&header))
+	if status.Code(err) != codes.ResourceExhausted || reason(err) != string(apperr.CodeRateLimited) {
+		t.Fatalf("second post: %v", err)
+	}
+	if len(header.Get("retry-after")) != 1 {
+		t.Errorf("retry-after header = %v", header.Get("retry-after"))
+	}
+	// Buckets are per user: bob still has his token.
+	if _, err := c.PostMessage(asUser("bob"), &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: "hi", ClientMsgId: "c-2"}); err != nil {
+		t.Fatalf("bob: %v", err)
+	}
+	// Unlisted routes fall back to the (disabled) default.
+	if _, err := c.ListMessages(ctx, &chatv1.ListMessagesRequest{RoomId: room.GetId()}); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+
+	// StreamRoom is charged once when the stream opens, not per event.
+	s, err := c.StreamRoom(ctx, &chatv1.StreamRoomRequest{RoomId: room.GetId()})
+	if err == nil {
+		_, err = s.Header() // sent once subscribed
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := c.StreamRoom(ctx, &chatv1.StreamRoomRequest{RoomId: room.GetId()})
+	if err == nil {
+		_, err = s2.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second stream: %v", err)
+	}
+	if _, err := c.PostMessage(asUser("carol"), &chatv1.PostMessageRequest{RoomId: room.GetId(), Content: "live", ClientMsgId: "c-3"}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ev, err := s.Recv()
+		if err != nil {
+			t.Fatalf("first stream: %v", err)
+		}
+		if ev.GetMessage().GetContent() == "live" {
+			break
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// memoryRouter serves the REST API from an in-memory store, without
+// Postgres or Redis.
+func memoryRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	return api.NewRouter(api.Deps{
+		Handlers: &api.Handlers{Store: store.NewMemory()},
+		WS:       func(http.ResponseWriter, *http.Request) {},
+	})
+}
+
+func do(r http.Handler, method, path, user, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set("X-User-ID", user)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandlersOnMemoryStore(t *testing.T) {
+	r := memoryRouter()
+
+	w := do(r, http.MethodPost, "/v1/rooms", "alice", `{"name":"general"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create room: %d %s", w.Code, w.Body)
+	}
+	var room store.Room
+	_ = json.Unmarshal(w.Body.Bytes(), &room)
+	base := "/v1/rooms/" + strconv.FormatInt(room.ID, 10)
+
+	w = do(r, http.MethodPost, base+"/messages", "", `{"content":"hi","clientMsgId":"m-1"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("post message: %d %s", w.Code, w.Body)
+	}
+	var msg store.Message
+	_ = json.Unmarshal(w.Body.Bytes(), &msg)
+	if msg.Seq != 1 {
+		t.Fatalf("message = %+v", msg)
+	}
+
+	pin := `{"messageId":` + strconv.FormatInt(msg.ID, 10) + `}`
+	if w = do(r, http.MethodPost, base+"/pins", "bob", pin); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin pin: %d %s", w.Code, w.Body)
+	}
+	if w = do(r, http.MethodPost, base+"/pins", "alice", pin); w.Code != http.StatusCreated {
+		t.Fatalf("admin pin: %d %s", w.Code, w.Body)
+	}
+	if w = do(r, http.MethodPost, "/v1/rooms/999/messages", "", `{"content":"hi"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("post to unknown room: %d %s", w.Code, w.Body)
+	}
+
+	w = do(r, http.MethodGet, base+"/messages", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"clientMsgId":"m-1"`) {
+		t.Fatalf("list messages: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestListMessagesCursor(t *testing.T) {
+	r := memoryRouter()
+	w := do(r, http.MethodPost, "/v1/rooms", "alice", `{"name":"general"}`)
+	var room store.Room
+	_ = json.Unmarshal(w.Body.Bytes(), &room)
+	base := "/v1/rooms/" + strconv.FormatInt(room.ID, 10) + "/messages"
+	var last store.Message
+	for i := 1; i <= 5; i++ {
+		w = do(r, http.MethodPost, base, "", `{"content":"m`+strconv.Itoa(i)+`","clientMsgId":"c-`+strconv.Itoa(i)+`"}`)
+		_ = json.Unmarshal(w.Body.Bytes(), &last)
+	}
+	type page struct {
+		Items      []store.Message `json:"items"`
+		NextCursor string          `json:"nextCursor"`
+		PrevCursor string          `json:"prevCursor"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		w := do(r, http.MethodGet, base+query, "", "")
+		var p page
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("list %s: %d %s", query, w.Code, w.Body)
+		}
+		return p
+	}
+
+	first := list("?limit=2")
+	older := list("?limit=2&cursor=" + first.NextCursor)
+	if len(older.Items) != 2 || older.Items[0].Seq != 3 || older.PrevCursor == "" {
+		t.Fatalf("older page = %+v", older)
+	}
+	if newer := list("?limit=2&cursor=" + older.PrevCursor); len(newer.Items) != 2 || newer.Items[0].Seq != 5 {
+		t.Fatalf("newer page = %+v", newer)
+	}
+
+	// A message id, what cursor used to take, is refused rather than read as
+	// a seq.
+	for _, query := range []string{
+		"?cursor=" + strconv.FormatInt(last.ID, 10),
+		"?cursor=bogus",
+		"?before=3&cursor=" + first.NextCursor,
+	} {
+		if w := do(r, http.MethodGet, base+query, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("list %s: %d %s", query, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yngus4862/chat/internal/latency"
+)
+
+func TestLatencySnapshot(t *testing.T) {
+	for i := 1; i <= 100; i++ {
+		latency.Observe(latency.Client, time.Duration(i)*time.Millisecond)
+	}
+	latency.Observe(latency.Client, -time.Second) // clock skew, dropped
+	latency.Since(latency.Client, time.Time{})    // no stamp, ignored
+
+	s, ok := latency.Snapshot()[latency.Client]
+	if !ok {
+		t.Fatal("no summary for client stage")
+	}
+	if s.Count != 100 || s.Window != 100 {
+		t.Fatalf("count=%d window=%d", s.Count, s.Window)
+	}
+	if s.P50 != 50 || s.P95 != 95 || s.P99 != 99 || s.Max != 100 {
+		t.Fatalf("summary = %+v", s)
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+	chatv1 "github.com/yngus4862/chat/proto/chat/v1"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestSingleListener serves REST, WebSocket and h2c from one server, as
+// APP_LISTEN_MODE=single with APP_HTTP_H2C does.
+func TestSingleListener(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := store.NewMemory()
+	hub := ws.NewHub(st, nil, ws.Options{})
+	router := api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: st, Hub: hub}, WS: hub.ServeWS})
+	srv := httptest.NewServer(h2c.NewHandler(router, &http2.Server{}))
+	t.Cleanup(srv.Close)
+
+	room := must(st.CreateRoom(context.Background(), "general", ""))(t)
+	roomID := strconv.FormatInt(room.ID, 10)
+
+	// /ws upgrades through the router.
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?roomId="+roomID, nil)
+	if err != nil {
+		t.Fatalf("ws dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if resp.Header.Get(ws.ConnIDHeader) == "" {
+		t.Errorf("handshake headers = %v", resp.Header)
+	}
+
+	// REST on HTTP/1.1 reaches the same hub.
+	res, err := http.Post(srv.URL+"/v1/rooms/"+roomID+"/messages", "application/json",
+		strings.NewReader(`{"content":"hello","clientMsgId":"c-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("post: status %d", res.StatusCode)
+	}
+	if f := readFrame(t, conn); f["content"] != "hello" {
+		t.Fatalf("ws frame = %v", f)
+	}
+
+	// h2c with prior knowledge on the same port.
+	h2 := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	res, err = h2.Get(srv.URL + "/v1/rooms/" + roomID + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.ProtoMajor != 2 {
+		t.Fatalf("h2c: status %d over %s", res.StatusCode, res.Proto)
+	}
+}
+
+// TestHubCloseEndsStreams checks that shutdown ends every open stream instead
+// of waiting on them, and that the streams left no request spans behind.
+func TestHubCloseEndsStreams(t *testing.T) {
+	rec := recordSpans(t)
+	st := store.NewMemory()
+	hub := ws.NewHub(st, nil, ws.Options{})
+	srv := blockServer(t, st, hub)
+	c := grpcClient(t, st, hub, nil, ratelimit.Budgets{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	roomID := strconv.FormatInt(room.ID, 10)
+
+	conn := dialWS(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?roomId="+roomID, nil)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/rooms/"+roomID+"/events", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	stream, err := c.StreamRoom(asUser("alice"), &chatv1.StreamRoomRequest{RoomId: room.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	hub.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Fatalf("ws: %v", err)
+		}
+		break
+	}
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		t.Fatalf("sse: %v", err)
+	}
+	for {
+		_, err := stream.Recv()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("grpc: %v", err)
+		}
+		break
+	}
+
+	for _, s := range rec.Ended() {
+		if strings.HasPrefix(s.Name(), "GET /") {
+			t.Errorf("streaming request got span %q", s.Name())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yngus4862/chat/internal/logging"
+)
+
+func TestAccessLogCarriesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	if err := logging.SetupWriter(&buf, "info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = logging.Setup("info", "json") })
+
+	r := testRouter()
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get("X-Request-ID"); got != "req-123" {
+		t.Fatalf("response X-Request-ID = %q", got)
+	}
+	var found bool
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("not json: %s", sc.Text())
+		}
+		if line["msg"] == "request" {
+			found = true
+			if line["request_id"] != "req-123" || line["route"] != "/healthz" || line["status"] != float64(200) {
+				t.Fatalf("access log = %v", line)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("no access log line in %q", buf.String())
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	t.Cleanup(func() { _ = logging.SetLevel("info") })
+	if err := logging.SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	if got := logging.Level(); got != "DEBUG" {
+		t.Fatalf("Level() = %q", got)
+	}
+	if err := logging.SetLevel("loud"); err == nil {
+		t.Fatal("unknown level accepted")
+	}
+	if got := logging.Level(); got != "DEBUG" {
+		t.Fatalf("failed SetLevel changed level to %q", got)
+	}
+}
@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yngus4862/chat/internal/metrics"
+)
+
+func TestMetricsRecordRoutes(t *testing.T) {
+	r := testRouter()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no/such/path", nil))
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`chat_http_requests_total{method="GET",route="/healthz",status="200"}`,
+		`chat_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`chat_http_request_duration_seconds_bucket{method="GET",route="/healthz"`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(body, "/no/such/path") {
+		t.Error("raw path leaked into labels")
+	}
+}
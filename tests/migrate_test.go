@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yngus4862/chat/internal/migrate"
+	"github.com/yngus4862/chat/migrations"
+)
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range ms {
+		if m.Version != uint64(i+1) {
+			t.Fatalf("migration %d has version %d; versions must be contiguous from 1", i, m.Version)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrationLoadRejectsMissingUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.up.sql":   {Data: []byte("SELECT 1;")},
+		"0002_more.down.sql": {Data: []byte("SELECT 1;")},
+		"README.md":          {Data: []byte("ignored")},
+	}
+	if _, err := migrate.Load(fsys); err == nil {
+		t.Fatal("expected an error for a migration without an up file")
+	}
+}
+
+// migrateSchema returns a pool on a fresh, empty schema of TEST_DATABASE_URL,
+// skipping the test when it is not set.
+func migrateSchema(t *testing.T) *pgxpool.Pool {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	schema := "migrate_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	admin, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+	if _, err := admin.Exec(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec(context.Background(), `DROP SCHEMA `+schema+` CASCADE`) })
+
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestMigrateReadsDoNotCreateTable(t *testing.T) {
+	pool := migrateSchema(t)
+	ctx := context.Background()
+	r := must(migrate.New(pool, migrations.FS))(t)
+
+	if v, dirty, err := r.Version(ctx); err != nil || v != 0 || dirty {
+		t.Fatalf("version = %d, %v, %v", v, dirty, err)
+	}
+	if err := r.Check(ctx); err == nil {
+		t.Fatal("check passed on an empty database")
+	}
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || exists {
+		t.Fatalf("schema_migrations exists = %v, %v", exists, err)
+	}
+}
+
+func TestMigrateStampsLegacySchema(t *testing.T) {
+	pool := migrateSchema(t)
+	ctx := context.Background()
+	// the layout of the old EnsureSchema and migrations/001_init.sql
+	legacy := `
+CREATE TABLE chat_rooms (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE TABLE messages (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+INSERT INTO chat_rooms(name) VALUES ('general');
+INSERT INTO messages(room_id, content) VALUES (1, 'one'), (1, 'two');`
+	if _, err := pool.Exec(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	r := must(migrate.New(pool, migrations.FS))(t)
+	applied := must(r.Up(ctx))(t)
+	if len(applied) == 0 || applied[0] != 2 {
+		t.Fatalf("applied %v, want 0001 stamped and 2.. applied", applied)
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := pool.QueryRow(ctx, `SELECT count(DISTINCT client_msg_id) FROM messages WHERE source = 'rest'`).Scan(&n); err != nil || n != 2 {
+		t.Fatalf("backfilled client_msg_id: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+)
+
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	return api.NewRouter(api.Deps{
+		Handlers: &api.Handlers{},
+		WS:       func(http.ResponseWriter, *http.Request) {},
+	})
+}
+
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	if err := api.OpenAPI().Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	doc := api.OpenAPI()
+	for _, rt := range testRouter().Routes() {
+		item := doc.Paths.Find(api.SpecPath(rt.Path))
+		if item == nil || item.GetOperation(rt.Method) == nil {
+			t.Errorf("%s %s is routed but missing from the OpenAPI document", rt.Method, rt.Path)
+		}
+	}
+}
+
+func TestValidateRequestRejectsAgainstSpec(t *testing.T) {
+	r := testRouter()
+	cases := []struct {
+		name, method, path, body, field string
+	}{
+		{"name too long", http.MethodPost, "/v1/rooms", `{"name":"` + strings.Repeat("가", 201) + `"}`, "name"},
+		{"name missing", http.MethodPost, "/v1/rooms", `{}`, "name"},
+		{"bad roomId", http.MethodGet, "/v1/rooms/abc/messages", "", "roomId"},
+		{"bad sort", http.MethodGet, "/v1/rooms?sort=size", "", "sort"},
+		{"content too long", http.MethodPost, "/v1/rooms/1/messages", `{"content":"` + strings.Repeat("a", 5001) + `"}`, "content"},
+		{"invalid json", http.MethodPost, "/v1/rooms/1/messages", `{"content":`, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status=%d want=400 body=%s", w.Code, w.Body)
+			}
+			var out struct {
+				Error     string `json:"error"`
+				Code      string `json:"code"`
+				Field     string `json:"field"`
+				RequestID string `json:"requestId"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || out.Error == "" || out.RequestID == "" {
+				t.Fatalf("error body does not match the Error schema: %s", w.Body)
+			}
+			if out.Code != "validation_failed" {
+				t.Fatalf("code=%q want=validation_failed", out.Code)
+			}
+			if out.Field != tc.field {
+				t.Fatalf("field=%q want=%q (%s)", out.Field, tc.field, out.Error)
+			}
+		})
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	w := httptest.NewRecorder()
+	testRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"openapi":"3.0.3"`) {
+		t.Fatalf("status=%d body=%.200s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/clientip"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+func TestMemoryLimiterBurstAndRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := ratelimit.NewMemoryWithClock(func() time.Time { return now })
+	b := ratelimit.Budget{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.Allow(ctx, "k", b); !ok {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	ok, wait, _ := l.Allow(ctx, "k", b)
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("wait=%s want=500ms", wait)
+	}
+	if ok, _, _ := l.Allow(ctx, "other", b); !ok {
+		t.Fatal("keys must not share a bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _, _ := l.Allow(ctx, "k", b); !ok {
+		t.Fatal("token was not refilled")
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ratelimit.ParseRoutes("POST /v1/rooms/:roomId/messages=5:10, POST  /v1/rooms=0.5:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budgets := ratelimit.Budgets{Default: ratelimit.Budget{Rate: 20, Burst: 40}, Routes: routes}
+	if got := budgets.For("POST", "/v1/rooms"); got != (ratelimit.Budget{Rate: 0.5, Burst: 2}) {
+		t.Fatalf("got=%+v", got)
+	}
+	if got := budgets.For("GET", "/v1/rooms"); got != budgets.Default {
+		t.Fatalf("got=%+v want default", got)
+	}
+	if _, err := ratelimit.ParseRoutes("POST /v1/rooms"); err == nil {
+		t.Fatal("expected error for missing budget")
+	}
+}
+
+func TestClientIPBehindTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies, err := clientip.New([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		peer, xff, realIP, want string
+	}{
+		{"198.51.100.9:1234", "1.1.1.1", "", "198.51.100.9"},           // untrusted peer: headers ignored
+		{"10.1.2.3:1234", "1.1.1.1, 203.0.113.7", "", "203.0.113.7"},   // client-sent entry on the left
+		{"10.1.2.3:1234", "203.0.113.7, 10.9.9.9", "", "203.0.113.7"},  // trusted hops skipped
+		{"192.0.2.1:1234", "10.0.0.5, 10.0.0.6", "", "10.0.0.5"},       // all trusted: leftmost
+		{"10.1.2.3:1234", "", "203.0.113.8", "203.0.113.8"},            // X-Real-IP from the proxy
+		{"10.1.2.3:1234", "not-an-ip, 203.0.113.7", "", "203.0.113.7"}, // malformed left of the client
+		{"10.1.2.3:1234", "203.0.113.7, not-an-ip", "203.0.113.8", "203.0.113.8"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.peer
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := proxies.IP(req); got != tc.want {
+			t.Errorf("%+v: IP = %q, want %q", tc, got, tc.want)
+		}
+		// gin must agree, or REST and WebSocket would bucket clients differently
+		r := gin.New()
+		if err := r.SetTrustedProxies(proxies.CIDRs()); err != nil {
+			t.Fatal(err)
+		}
+		var ginIP string
+		r.GET("/", func(c *gin.Context) { ginIP = c.ClientIP() })
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		if ginIP != tc.want {
+			t.Errorf("%+v: gin ClientIP = %q, want %q", tc, ginIP, tc.want)
+		}
+	}
+
+	var none *clientip.Resolver
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if got := none.IP(req); got != "192.0.2.1" {
+		t.Fatalf("nil resolver IP = %q, want the peer", got)
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies, err := clientip.New([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := api.NewRouter(api.Deps{
+		Handlers:    &api.Handlers{Store: store.NewMemory()},
+		RateLimiter: ratelimit.NewMemory(),
+		RateBudgets: ratelimit.Budgets{Default: ratelimit.Budget{Rate: 0.001, Burst: 2}},
+		Proxies:     proxies,
+	})
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/v1/rooms", nil)
+		req.RemoteAddr = "10.0.0.1:4000" // the proxy
+		// the client rotates what it claims; the proxy appends what it saw
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d, 203.0.113.7", i))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if want := map[bool]int{true: http.StatusOK, false: http.StatusTooManyRequests}[i < 2]; w.Code != want {
+			t.Fatalf("request %d: %d %s, want %d", i, w.Code, w.Body, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yngus4862/chat/internal/retention"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// agedStore makes every message look older by age, as if that much time had
+// passed since it was sent.
+type agedStore struct {
+	*store.Memory
+	age time.Duration
+}
+
+func (s agedStore) CountMessagesBefore(ctx context.Context, roomID int64, before time.Time) (int64, error) {
+	return s.Memory.CountMessagesBefore(ctx, roomID, before.Add(s.age))
+}
+
+func (s agedStore) PurgeMessages(ctx context.Context, roomID int64, before time.Time, limit int) (int64, error) {
+	return s.Memory.PurgeMessages(ctx, roomID, before.Add(s.age), limit)
+}
+
+func TestRetentionPurge(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	st := agedStore{Memory: mem, age: 10 * 24 * time.Hour}
+	room := func(name string, n int) int64 {
+		r := must(mem.CreateRoom(ctx, name, ""))(t)
+		for i := 0; i < n; i++ {
+			must(mem.CreateMessage(ctx, r.ID, "", "m", "rest", ""))(t)
+		}
+		return r.ID
+	}
+	expired, held, forever, longer := room("expired", 5), room("held", 2), room("forever", 2), room("longer", 2)
+	zero, thirty := 0, 30
+	must(mem.SetRetention(ctx, store.RoomRetention{RoomID: held, LegalHold: true}))(t)
+	must(mem.SetRetention(ctx, store.RoomRetention{RoomID: forever, Days: &zero}))(t)
+	must(mem.SetRetention(ctx, store.RoomRetention{RoomID: longer, Days: &thirty}))(t)
+
+	p := retention.New(st, retention.Options{DefaultDays: 7, BatchSize: 2})
+
+	dry := must(p.DryRun(ctx))(t)
+	if !dry.DryRun || dry.Messages != 5 || len(dry.Rooms) != 2 || dry.Rooms[0].RoomID != expired || !dry.Rooms[1].LegalHold {
+		t.Fatalf("dry run = %+v", dry)
+	}
+	if n := must(mem.CountMessagesBefore(ctx, expired, time.Now().Add(time.Second)))(t); n != 5 {
+		t.Fatalf("dry run deleted messages: %d left", n)
+	}
+
+	rep := must(p.Purge(ctx))(t)
+	if rep.DryRun || rep.Messages != 5 {
+		t.Fatalf("purge = %+v", rep)
+	}
+	for id, want := range map[int64]int64{expired: 0, held: 2, forever: 2, longer: 2} {
+		if n := must(mem.CountMessagesBefore(ctx, id, time.Now().Add(time.Second)))(t); n != want {
+			t.Errorf("room %d has %d messages, want %d", id, n, want)
+		}
+	}
+	log := must(mem.ListPurges(ctx, 0, 10))(t)
+	if len(log) != 1 || log[0].RoomID != expired || log[0].Deleted != 5 {
+		t.Fatalf("purge log = %+v", log)
+	}
+
+	if rep = must(p.Purge(ctx))(t); rep.Messages != 0 {
+		t.Fatalf("second purge = %+v", rep)
+	}
+}
+
+func TestRetentionAdminRoutes(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	room := must(mem.CreateRoom(ctx, "room", ""))(t)
+	mux := http.NewServeMux()
+	for _, rt := range retention.New(mem, retention.Options{DefaultDays: 30}).Routes() {
+		mux.HandleFunc(rt.Pattern, rt.Handler)
+	}
+	path := "/admin/retention/rooms/" + strconv.FormatInt(room.ID, 10)
+
+	var pol store.RoomRetention
+	steps := []struct {
+		body      string
+		status    int
+		days      *int
+		legalHold bool
+	}{
+		{`{"days":90}`, http.StatusOK, ptr(90), false},
+		{`{"legalHold":true}`, http.StatusOK, ptr(90), true},
+		{`{"days":null}`, http.StatusOK, nil, true},
+		{`{"days":-1}`, http.StatusBadRequest, nil, true},
+		{`{"days":"x"}`, http.StatusBadRequest, nil, true},
+	}
+	for _, s := range steps {
+		w := do(mux, http.MethodPut, path, "", s.body)
+		if w.Code != s.status {
+			t.Fatalf("PUT %s: %d %s", s.body, w.Code, w.Body)
+		}
+		_ = json.Unmarshal(do(mux, http.MethodGet, path, "", "").Body.Bytes(), &pol)
+		if pol.LegalHold != s.legalHold || (pol.Days == nil) != (s.days == nil) || pol.Days != nil && *pol.Days != *s.days {
+			t.Fatalf("after PUT %s: %+v", s.body, pol)
+		}
+	}
+
+	if w := do(mux, http.MethodPut, "/admin/retention/rooms/999", "", `{"days":1}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown room: %d %s", w.Code, w.Body)
+	}
+	w := do(mux, http.MethodGet, "/admin/retention/report", "", "")
+	var rep retention.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil || w.Code != http.StatusOK || !rep.DryRun || rep.DefaultDays != 30 {
+		t.Fatalf("report: %d %s", w.Code, w.Body)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
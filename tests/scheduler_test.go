@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yngus4862/chat/internal/moderation"
+	"github.com/yngus4862/chat/internal/push"
+	"github.com/yngus4862/chat/internal/scheduler"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+)
+
+// schedStore shortens every lease so failed items are retried on the next
+// pass, and fails the first failCreate message inserts.
+type schedStore struct {
+	store.Store
+	mu         sync.Mutex
+	failCreate int
+}
+
+func (s *schedStore) ClaimDueScheduled(ctx context.Context, owner string, _ time.Duration, limit int) ([]store.ScheduledMessage, error) {
+	return s.Store.ClaimDueScheduled(ctx, owner, time.Millisecond, limit)
+}
+
+func (s *schedStore) CreateMessage(ctx context.Context, roomID int64, author, content, source, clientMsgID string) (store.Message, error) {
+	s.mu.Lock()
+	fail := s.failCreate > 0
+	s.failCreate--
+	s.mu.Unlock()
+	if fail {
+		return store.Message{}, errors.New("connection reset")
+	}
+	return s.Store.CreateMessage(ctx, roomID, author, content, source, clientMsgID)
+}
+
+// recordingNotifier keeps the notifications it was asked to send.
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []push.Notification
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, _ string, p push.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, p)
+	return nil
+}
+
+func (n *recordingNotifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.sent)
+}
+
+func scheduledByID(t *testing.T, st store.Store, user string, id int64) (store.ScheduledMessage, bool) {
+	t.Helper()
+	for _, sm := range must(st.ListScheduled(context.Background(), user))(t) {
+		if sm.ID == id {
+			return sm, true
+		}
+	}
+	return store.ScheduledMessage{}, false
+}
+
+func TestScheduler(t *testing.T) {
+	suite := map[string]func(t *testing.T, st store.Store){
+		"sends due messages": testSchedulerSend,
+		"claims once":        testSchedulerClaim,
+		"retries failures":   testSchedulerRetry,
+		"moderates at send":  testSchedulerModeration,
+		"reminds":            testSchedulerRemind,
+	}
+	for name, open := range storeBackends(t) {
+		for tname, fn := range suite {
+			t.Run(name+"/"+tname, func(t *testing.T) { fn(t, open(t)) })
+		}
+	}
+}
+
+func testSchedulerSend(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	due := must(st.ScheduleMessage(ctx, "alice", room.ID, "now", time.Now().Add(-time.Second)))(t)
+	later := must(st.ScheduleMessage(ctx, "alice", room.ID, "later", time.Now().Add(time.Hour)))(t)
+
+	scheduler.New(st, nil, moderation.New(st), nil, time.Second).RunOnce(ctx)
+
+	page := must(st.ListMessages(ctx, room.ID, store.MessageQuery{Limit: 10}))(t)
+	if len(page.Items) != 1 || page.Items[0].Content != "now" || page.Items[0].Source != "scheduled" {
+		t.Fatalf("messages = %+v", page.Items)
+	}
+	if _, ok := scheduledByID(t, st, "alice", due.ID); ok {
+		t.Error("sent item still listed")
+	}
+	if sm, ok := scheduledByID(t, st, "alice", later.ID); !ok || sm.Status != store.ScheduledPending {
+		t.Errorf("future item = %+v, %v", sm, ok)
+	}
+}
+
+func testSchedulerClaim(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	for range 20 {
+		must(st.ScheduleMessage(ctx, "alice", room.ID, "hi", time.Now().Add(-time.Second)))(t)
+	}
+
+	// An item leased by a crashed instance is left alone until the lease
+	// runs out.
+	stuck := must(st.ScheduleMessage(ctx, "alice", room.ID, "stuck", time.Now().Add(-time.Second)))(t)
+	var claimed []store.ScheduledMessage
+	for _, sm := range must(st.ClaimDueScheduled(ctx, "crashed", 200*time.Millisecond, 100))(t) {
+		if sm.ID == stuck.ID {
+			claimed = append(claimed, sm)
+		} else if err := st.MarkScheduledFailed(ctx, sm.ID, "crashed", "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(claimed) != 1 {
+		t.Fatalf("crashed owner claimed %v", claimed)
+	}
+
+	// Two instances racing over the same rows send each message once.
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.New(st, nil, moderation.New(st), nil, time.Second).RunOnce(ctx)
+		}()
+	}
+	wg.Wait()
+	if n := len(must(st.ListMessages(ctx, room.ID, store.MessageQuery{Limit: 100}))(t).Items); n != 0 {
+		t.Fatalf("sent %d while leased elsewhere", n)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.New(st, nil, moderation.New(st), nil, time.Second).RunOnce(ctx)
+		}()
+	}
+	wg.Wait()
+	if n := len(must(st.ListMessages(ctx, room.ID, store.MessageQuery{Limit: 100}))(t).Items); n != 21 {
+		t.Fatalf("sent %d messages, want 21", n)
+	}
+}
+
+func testSchedulerRetry(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	flaky := &schedStore{Store: st, failCreate: 1}
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	sm := must(st.ScheduleMessage(ctx, "alice", room.ID, "hi", time.Now().Add(-time.Second)))(t)
+	s := scheduler.New(flaky, nil, moderation.New(st), nil, time.Second)
+
+	s.RunOnce(ctx)
+	got, ok := scheduledByID(t, st, "alice", sm.ID)
+	if !ok || got.Status != store.ScheduledProcessing || got.LastError != "connection reset" || got.Attempts != 1 {
+		t.Fatalf("after failure: %+v, %v", got, ok)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	s.RunOnce(ctx)
+	if _, ok := scheduledByID(t, st, "alice", sm.ID); ok {
+		t.Fatal("retried item still listed")
+	}
+	page := must(st.ListMessages(ctx, room.ID, store.MessageQuery{Limit: 10}))(t)
+	if len(page.Items) != 1 || page.Items[0].ClientMsgID == "" {
+		t.Fatalf("messages = %+v", page.Items)
+	}
+}
+
+func testSchedulerModeration(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	rejected := must(st.ScheduleMessage(ctx, "alice", room.ID, "now forbidden", time.Now().Add(-time.Second)))(t)
+	must(st.ScheduleMessage(ctx, "alice", room.ID, "a scam offer", time.Now().Add(-time.Second)))(t)
+
+	// The filters were tightened after both items were scheduled.
+	mod := moderation.New(st,
+		moderation.Words(moderation.Reject, "forbidden"),
+		moderation.Words(moderation.Flag, "scam"),
+	)
+	scheduler.New(st, nil, mod, nil, time.Second).RunOnce(ctx)
+
+	got, ok := scheduledByID(t, st, "alice", rejected.ID)
+	if !ok || got.Status != store.ScheduledFailed || got.Attempts != 1 {
+		t.Fatalf("rejected item = %+v, %v", got, ok)
+	}
+	page := must(st.ListMessages(ctx, room.ID, store.MessageQuery{Limit: 10}))(t)
+	if len(page.Items) != 1 || page.Items[0].Content != "a scam offer" {
+		t.Fatalf("messages = %+v", page.Items)
+	}
+	queue, _, err := st.ListReviews(ctx, store.ReviewPending, 0, 10)
+	if err != nil || len(queue) != 1 || queue[0].MessageID != page.Items[0].ID {
+		t.Fatalf("review queue = %+v", queue)
+	}
+}
+
+func testSchedulerRemind(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	msg := must(st.CreateMessage(ctx, room.ID, "", "remember me", "rest", "c-1"))(t)
+	hub := ws.NewHub(st, nil, ws.Options{})
+	notifier := &recordingNotifier{}
+	s := scheduler.New(st, hub, moderation.New(st), notifier, time.Second)
+
+	// Offline: the reminder falls back to push.
+	must(st.ScheduleReminder(ctx, "alice", msg.ID, "", time.Now().Add(-time.Second)))(t)
+	s.RunOnce(ctx)
+	if notifier.count() != 1 || notifier.sent[0].Body != "remember me" || notifier.sent[0].Data["messageId"] != msg.ID {
+		t.Fatalf("push = %+v", notifier.sent)
+	}
+
+	// Online: the reminder goes to the live connection instead.
+	sub := must(hub.Subscribe(ctx, room.ID, ws.SubscribeOptions{UserID: "alice", Personal: true}))(t)
+	defer sub.Close()
+	must(st.ScheduleReminder(ctx, "alice", msg.ID, "call back", time.Now().Add(-time.Second)))(t)
+	s.RunOnce(ctx)
+	select {
+	case f := <-sub.Frames():
+		if !strings.Contains(string(f.Data), `"type":"reminder"`) || !strings.Contains(string(f.Data), `"note":"call back"`) {
+			t.Fatalf("frame = %s", f.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reminder frame")
+	}
+	if notifier.count() != 1 {
+		t.Fatalf("pushed while online: %+v", notifier.sent)
+	}
+}
@@ -0,0 +1,784 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yngus4862/chat/internal/db"
+	"github.com/yngus4862/chat/internal/store"
+)
+
+// storeBackends opens a fresh, empty instance of every backend. Postgres runs
+// only when TEST_DATABASE_URL is set; the suite truncates its tables, so point
+// it at a throwaway database.
+func storeBackends(t *testing.T) map[string]func(t *testing.T) store.Store {
+	backends := map[string]func(t *testing.T) store.Store{
+		"memory": func(*testing.T) store.Store { return store.NewMemory() },
+		"sqlite": func(t *testing.T) store.Store {
+			st, err := store.OpenSQLite(filepath.Join(t.TempDir(), "chat.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = st.Close() })
+			return st
+		},
+	}
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		backends["postgres"] = func(t *testing.T) store.Store {
+			ctx := context.Background()
+			conn, err := db.Connect(ctx, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(conn.Close)
+			st := store.NewPostgres(conn.Pool)
+			if err := st.EnsureSchema(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Pool.Exec(ctx, `TRUNCATE chat_rooms, messages, room_members, room_pins, user_bookmarks, scheduled_messages, retention_purges, audit_events, message_reviews, user_blocks, message_reports RESTART IDENTITY CASCADE`); err != nil {
+				t.Fatal(err)
+			}
+			return st
+		}
+	}
+	return backends
+}
+
+func TestStoreConformance(t *testing.T) {
+	suite := map[string]func(t *testing.T, st store.Store){
+		"rooms":      testStoreRooms,
+		"messages":   testStoreMessages,
+		"pins":       testStorePins,
+		"bookmarks":  testStoreBookmarks,
+		"scheduled":  testStoreScheduled,
+		"retention":  testStoreRetention,
+		"archive":    testStoreArchive,
+		"audit":      testStoreAudit,
+		"moderation": testStoreModeration,
+		"blocks":     testStoreBlocks,
+		"reports":    testStoreReports,
+	}
+	for name, open := range storeBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			for caseName, run := range suite {
+				t.Run(caseName, func(t *testing.T) { run(t, open(t)) })
+			}
+		})
+	}
+}
+
+// must turns a (value, error) result into the value, failing the test on
+// error: must(st.CreateRoom(...))(t).
+func must[T any](v T, err error) func(t *testing.T) T {
+	return func(t *testing.T) T {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+}
+
+func wantErr(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("err = %v, want %v", err, want)
+	}
+}
+
+func testStoreRooms(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	if err := st.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	a := must(st.CreateRoom(ctx, "bravo", "alice"))(t)
+	b := must(st.CreateRoom(ctx, "alpha", ""))(t)
+	c := must(st.CreateRoom(ctx, "charlie", ""))(t)
+	if a.ID == 0 || a.Name != "bravo" || a.CreatedAt.IsZero() {
+		t.Fatalf("CreateRoom = %+v", a)
+	}
+
+	if ok := must(st.RoomExists(ctx, a.ID))(t); !ok {
+		t.Fatal("created room does not exist")
+	}
+	if ok := must(st.RoomExists(ctx, c.ID+1000))(t); ok {
+		t.Fatal("unknown room exists")
+	}
+	if ok := must(st.IsRoomAdmin(ctx, a.ID, "alice"))(t); !ok {
+		t.Fatal("creator is not admin")
+	}
+	if ok := must(st.IsRoomAdmin(ctx, b.ID, "alice"))(t); ok {
+		t.Fatal("alice is admin of a room she did not create")
+	}
+
+	// name order, paged two at a time
+	page, next, err := st.ListRooms(ctx, store.ListRoomsParams{Sort: store.RoomSortName, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != b.ID || page[1].ID != a.ID || next == "" {
+		t.Fatalf("first name page = %v next=%q", roomIDs(page), next)
+	}
+	page, next = mustRooms(t)(st.ListRooms(ctx, store.ListRoomsParams{Sort: store.RoomSortName, Limit: 2, Cursor: next}))
+	if len(page) != 1 || page[0].ID != c.ID || next != "" {
+		t.Fatalf("second name page = %v next=%q", roomIDs(page), next)
+	}
+
+	page, _ = mustRooms(t)(st.ListRooms(ctx, store.ListRoomsParams{Sort: store.RoomSortCreated}))
+	if got := roomIDs(page); len(got) != 3 || got[0] != c.ID || got[2] != a.ID {
+		t.Fatalf("created order = %v", got)
+	}
+
+	// a message moves its room to the top of the activity order
+	time.Sleep(2 * time.Millisecond)
+	m := must(st.CreateMessage(ctx, a.ID, "", "hello", "rest", ""))(t)
+	page, next = mustRooms(t)(st.ListRooms(ctx, store.ListRoomsParams{Limit: 1}))
+	if len(page) != 1 || page[0].ID != a.ID || next == "" {
+		t.Fatalf("activity page = %v", roomIDs(page))
+	}
+	if page[0].LastMessage == nil || page[0].LastMessage.ID != m.ID || page[0].LastMessageID != m.ID || page[0].LastSeq != 1 {
+		t.Fatalf("room %+v lacks its last message", page[0])
+	}
+	rest, _ := mustRooms(t)(st.ListRooms(ctx, store.ListRoomsParams{Limit: 5, Cursor: next}))
+	if got := roomIDs(rest); len(got) != 2 || got[0] != c.ID || got[1] != b.ID {
+		t.Fatalf("activity rest = %v", got)
+	}
+
+	_, _, err = st.ListRooms(ctx, store.ListRoomsParams{Sort: store.RoomSortCreated, Cursor: next})
+	wantErr(t, err, store.ErrInvalidCursor)
+}
+
+func mustRooms(t *testing.T) func([]store.Room, string, error) ([]store.Room, string) {
+	return func(rs []store.Room, next string, err error) ([]store.Room, string) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs, next
+	}
+}
+
+func roomIDs(rs []store.Room) []int64 {
+	out := make([]int64, len(rs))
+	for i, r := range rs {
+		out[i] = r.ID
+	}
+	return out
+}
+
+func testStoreMessages(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "room", ""))(t)
+	other := must(st.CreateRoom(ctx, "other", ""))(t)
+
+	var ids []int64
+	for i := 1; i <= 7; i++ {
+		m := must(st.CreateMessage(ctx, room.ID, "alice", "m"+strconv.Itoa(i), "rest", "c"+strconv.Itoa(i)))(t)
+		if m.Seq != int64(i) || m.RoomID != room.ID || m.ClientMsgID != "c"+strconv.Itoa(i) || m.Author != "alice" {
+			t.Fatalf("message %d = %+v", i, m)
+		}
+		ids = append(ids, m.ID)
+	}
+	dup := must(st.CreateMessage(ctx, room.ID, "", "again", "ws", "c3"))(t)
+	if dup.ID != ids[2] || dup.Content != "m3" || dup.Author != "alice" {
+		t.Fatalf("retry of c3 = %+v, want the original", dup)
+	}
+	if m := must(st.CreateMessage(ctx, room.ID, "", "m8", "rest", ""))(t); m.Seq != 8 || m.ClientMsgID == "" {
+		t.Fatalf("dedupe consumed a seq or no client id was assigned: %+v", m)
+	}
+	if m := must(st.CreateMessage(ctx, other.ID, "", "x", "rest", "c1"))(t); m.Seq != 1 {
+		t.Fatalf("client ids and seqs are per room: %+v", m)
+	}
+	_, err := st.CreateMessage(ctx, other.ID+1000, "", "x", "rest", "")
+	wantErr(t, err, store.ErrRoomNotFound)
+
+	got := must(st.GetMessage(ctx, ids[0]))(t)
+	if got.Content != "m1" || got.Source != "rest" || got.CreatedAt.IsZero() {
+		t.Fatalf("GetMessage = %+v", got)
+	}
+	_, err = st.GetMessage(ctx, ids[6]+1000)
+	wantErr(t, err, store.ErrMessageNotFound)
+
+	// newest first, seq 8..5
+	page := must(st.ListMessages(ctx, room.ID, store.MessageQuery{Limit: 4}))(t)
+	if s := seqs(page.Items); len(s) != 4 || s[0] != 8 || s[3] != 5 || page.NextCursor != 5 || page.PrevCursor != 0 {
+		t.Fatalf("latest = %v next=%d prev=%d", s, page.NextCursor, page.PrevCursor)
+	}
+	page = must(st.ListMessages(ctx, room.ID, store.MessageQuery{Before: 5, Limit: 4}))(t)
+	if s := seqs(page.Items); len(s) != 4 || s[0] != 4 || s[3] != 1 || page.NextCursor != 0 || page.PrevCursor != 4 {
+		t.Fatalf("before 5 = %v next=%d prev=%d", s, page.NextCursor, page.PrevCursor)
+	}
+	page = must(st.ListMessages(ctx, room.ID, store.MessageQuery{After: 2, Limit: 3}))(t)
+	if s := seqs(page.Items); len(s) != 3 || s[0] != 5 || s[2] != 3 || page.NextCursor != 3 || page.PrevCursor != 5 {
+		t.Fatalf("after 2 = %v next=%d prev=%d", s, page.NextCursor, page.PrevCursor)
+	}
+	page = must(st.ListMessages(ctx, room.ID, store.MessageQuery{Around: 4, Limit: 4}))(t)
+	if s := seqs(page.Items); len(s) != 4 || s[0] != 6 || s[1] != 5 || s[2] != 4 || s[3] != 3 {
+		t.Fatalf("around 4 = %v", s)
+	}
+	if page.NextCursor != 3 || page.PrevCursor != 6 {
+		t.Fatalf("around 4 cursors next=%d prev=%d", page.NextCursor, page.PrevCursor)
+	}
+
+	after, more, err := st.MessagesAfter(ctx, room.ID, 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := seqs(after); len(s) != 2 || s[0] != 6 || s[1] != 7 || !more {
+		t.Fatalf("MessagesAfter(5, 2) = %v more=%v", s, more)
+	}
+	after, more, _ = st.MessagesAfter(ctx, room.ID, 7, 0)
+	if s := seqs(after); len(s) != 1 || s[0] != 8 || more {
+		t.Fatalf("MessagesAfter(7) = %v more=%v", s, more)
+	}
+}
+
+func seqs(ms []store.Message) []int64 {
+	out := make([]int64, len(ms))
+	for i, m := range ms {
+		out[i] = m.Seq
+	}
+	return out
+}
+
+func testStorePins(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "room", ""))(t)
+	other := must(st.CreateRoom(ctx, "other", ""))(t)
+	var ms []store.Message
+	for i := 0; i <= store.MaxPinsPerRoom; i++ {
+		ms = append(ms, must(st.CreateMessage(ctx, room.ID, "", "m", "rest", ""))(t))
+	}
+	foreign := must(st.CreateMessage(ctx, other.ID, "", "x", "rest", ""))(t)
+
+	p, created, err := st.AddPin(ctx, room.ID, ms[0].ID, "alice")
+	if err != nil || !created || p.Position != 1 || p.PinnedBy != "alice" || p.Message == nil || p.Message.ID != ms[0].ID {
+		t.Fatalf("AddPin = %+v created=%v err=%v", p, created, err)
+	}
+	if _, created, _ = st.AddPin(ctx, room.ID, ms[0].ID, "bob"); created {
+		t.Fatal("pinning twice created a second pin")
+	}
+	_, _, err = st.AddPin(ctx, room.ID, foreign.ID, "alice")
+	wantErr(t, err, store.ErrMessageNotFound)
+	_, _, err = st.AddPin(ctx, other.ID+1000, ms[0].ID, "alice")
+	wantErr(t, err, store.ErrRoomNotFound)
+
+	mustPin(t)(st.AddPin(ctx, room.ID, ms[1].ID, "alice"))
+	mustPin(t)(st.AddPin(ctx, room.ID, ms[2].ID, "alice"))
+	pins := must(st.ReorderPins(ctx, room.ID, []int64{ms[2].ID, ms[0].ID, ms[1].ID}))(t)
+	if len(pins) != 3 || pins[0].MessageID != ms[2].ID || pins[2].MessageID != ms[1].ID || pins[0].Message == nil {
+		t.Fatalf("reordered = %+v", pins)
+	}
+	_, err = st.ReorderPins(ctx, room.ID, []int64{ms[2].ID, ms[0].ID})
+	wantErr(t, err, store.ErrPinOrderInvalid)
+	_, err = st.ReorderPins(ctx, room.ID, []int64{ms[2].ID, ms[0].ID, ms[3].ID})
+	wantErr(t, err, store.ErrPinOrderInvalid)
+	if pins = must(st.ListPins(ctx, room.ID))(t); pins[0].MessageID != ms[2].ID {
+		t.Fatalf("failed reorder changed the order: %+v", pins)
+	}
+
+	if err := st.RemovePin(ctx, room.ID, ms[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(t, st.RemovePin(ctx, room.ID, ms[0].ID), store.ErrPinNotFound)
+	if pins = must(st.ListPins(ctx, room.ID))(t); len(pins) != 2 {
+		t.Fatalf("after remove = %d pins", len(pins))
+	}
+
+	for _, m := range ms[3 : store.MaxPinsPerRoom+1] {
+		mustPin(t)(st.AddPin(ctx, room.ID, m.ID, "alice"))
+	}
+	_, _, err = st.AddPin(ctx, room.ID, ms[0].ID, "alice")
+	wantErr(t, err, store.ErrPinLimit)
+}
+
+func mustPin(t *testing.T) func(store.Pin, bool, error) {
+	return func(_ store.Pin, _ bool, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testStoreBookmarks(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "room", ""))(t)
+	var ms []store.Message
+	for i := 0; i < 3; i++ {
+		ms = append(ms, must(st.CreateMessage(ctx, room.ID, "", "m", "rest", ""))(t))
+	}
+
+	first := must(st.PutBookmark(ctx, "alice", ms[0].ID, "read later"))(t)
+	again := must(st.PutBookmark(ctx, "alice", ms[0].ID, "updated"))(t)
+	if again.ID != first.ID || again.Note != "updated" {
+		t.Fatalf("re-bookmarking = %+v, want note update of %+v", again, first)
+	}
+	must(st.PutBookmark(ctx, "alice", ms[1].ID, ""))(t)
+	must(st.PutBookmark(ctx, "alice", ms[2].ID, ""))(t)
+	must(st.PutBookmark(ctx, "bob", ms[2].ID, ""))(t)
+	_, err := st.PutBookmark(ctx, "alice", ms[2].ID+1000, "")
+	wantErr(t, err, store.ErrMessageNotFound)
+
+	bs, next, err := st.ListBookmarks(ctx, "alice", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 2 || bs[0].MessageID != ms[2].ID || bs[1].MessageID != ms[1].ID || next == 0 || bs[0].Message == nil {
+		t.Fatalf("first bookmarks page = %+v next=%d", bs, next)
+	}
+	bs, next, _ = st.ListBookmarks(ctx, "alice", next, 2)
+	if len(bs) != 1 || bs[0].MessageID != ms[0].ID || bs[0].Note != "updated" || next != 0 {
+		t.Fatalf("second bookmarks page = %+v next=%d", bs, next)
+	}
+
+	if err := st.DeleteBookmark(ctx, "alice", ms[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(t, st.DeleteBookmark(ctx, "alice", ms[0].ID), store.ErrBookmarkNotFound)
+}
+
+func testStoreScheduled(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "room", ""))(t)
+	msg := must(st.CreateMessage(ctx, room.ID, "", "m", "rest", ""))(t)
+	past := time.Now().Add(-time.Minute)
+
+	due := must(st.ScheduleMessage(ctx, "alice", room.ID, "hello later", past))(t)
+	if due.Kind != store.ScheduledKindMessage || due.Status != store.ScheduledPending || due.RoomID != room.ID {
+		t.Fatalf("ScheduleMessage = %+v", due)
+	}
+	rem := must(st.ScheduleReminder(ctx, "alice", msg.ID, "look", past.Add(time.Second)))(t)
+	if rem.Kind != store.ScheduledKindReminder || rem.RoomID != room.ID || rem.MessageID != msg.ID || rem.Content != "look" {
+		t.Fatalf("ScheduleReminder = %+v", rem)
+	}
+	later := must(st.ScheduleMessage(ctx, "alice", room.ID, "tomorrow", time.Now().Add(24*time.Hour)))(t)
+	_, err := st.ScheduleMessage(ctx, "alice", room.ID+1000, "x", past)
+	wantErr(t, err, store.ErrRoomNotFound)
+	_, err = st.ScheduleReminder(ctx, "alice", msg.ID+1000, "x", past)
+	wantErr(t, err, store.ErrMessageNotFound)
+
+	list := must(st.ListScheduled(ctx, "alice"))(t)
+	if len(list) != 3 || list[0].ID != due.ID || list[2].ID != later.ID {
+		t.Fatalf("ListScheduled = %+v", list)
+	}
+
+	claimed := must(st.ClaimDueScheduled(ctx, "w1", time.Minute, 10))(t)
+	if len(claimed) != 2 || claimed[0].ID != due.ID || claimed[1].ID != rem.ID || claimed[0].Attempts != 1 || claimed[0].Status != store.ScheduledProcessing {
+		t.Fatalf("claimed = %+v", claimed)
+	}
+	if again := must(st.ClaimDueScheduled(ctx, "w2", time.Minute, 10))(t); len(again) != 0 {
+		t.Fatalf("leased items claimed again: %+v", again)
+	}
+	wantErr(t, st.CancelScheduled(ctx, "alice", due.ID), store.ErrScheduledNotFound)
+
+	if err := st.MarkScheduledSent(ctx, due.ID, "w1", msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkScheduledFailed(ctx, rem.ID, "w2", "not the owner", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkScheduledFailed(ctx, rem.ID, "w1", "push failed", true); err != nil {
+		t.Fatal(err)
+	}
+	list = must(st.ListScheduled(ctx, "alice"))(t)
+	if len(list) != 2 || list[0].ID != rem.ID || list[0].Status != store.ScheduledFailed || list[0].LastError != "push failed" {
+		t.Fatalf("after send/fail = %+v", list)
+	}
+
+	// an expired lease makes the item claimable again
+	expiring := must(st.ScheduleMessage(ctx, "bob", room.ID, "x", past))(t)
+	must(st.ClaimDueScheduled(ctx, "w1", time.Millisecond, 10))(t)
+	time.Sleep(5 * time.Millisecond)
+	reclaimed := must(st.ClaimDueScheduled(ctx, "w2", time.Minute, 10))(t)
+	if len(reclaimed) != 1 || reclaimed[0].ID != expiring.ID || reclaimed[0].Attempts != 2 {
+		t.Fatalf("reclaimed = %+v", reclaimed)
+	}
+
+	if err := st.CancelScheduled(ctx, "alice", rem.ID); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(t, st.CancelScheduled(ctx, "bob", later.ID), store.ErrScheduledNotFound)
+	if err := st.CancelScheduled(ctx, "alice", later.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list = must(st.ListScheduled(ctx, "alice"))(t); len(list) != 0 {
+		t.Fatalf("after cancel = %+v", list)
+	}
+}
+
+func testStoreRetention(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "room", ""))(t)
+	other := must(st.CreateRoom(ctx, "other", ""))(t)
+	var ms []store.Message
+	for i := 0; i < 5; i++ {
+		ms = append(ms, must(st.CreateMessage(ctx, room.ID, "", "m", "rest", ""))(t))
+	}
+	must(st.CreateMessage(ctx, other.ID, "", "kept", "rest", ""))(t)
+	mustPin(t)(st.AddPin(ctx, room.ID, ms[0].ID, "alice"))
+	must(st.PutBookmark(ctx, "alice", ms[1].ID, ""))(t)
+	must(st.ScheduleReminder(ctx, "alice", ms[1].ID, "x", time.Now().Add(time.Hour)))(t)
+
+	pol := must(st.GetRetention(ctx, room.ID))(t)
+	if pol.Days != nil || pol.LegalHold || pol.EffectiveDays(30) != 30 {
+		t.Fatalf("default policy = %+v", pol)
+	}
+	days := 7
+	must(st.SetRetention(ctx, store.RoomRetention{RoomID: room.ID, Days: &days}))(t)
+	_, err := st.SetRetention(ctx, store.RoomRetention{RoomID: other.ID + 1000})
+	wantErr(t, err, store.ErrRoomNotFound)
+	all := must(st.ListRetention(ctx))(t)
+	if len(all) != 2 || all[0].RoomID != room.ID || all[0].Days == nil || *all[0].Days != 7 || all[1].Days != nil {
+		t.Fatalf("ListRetention = %+v", all)
+	}
+
+	cutoff := time.Now().Add(time.Second)
+	if n := must(st.CountMessagesBefore(ctx, room.ID, cutoff))(t); n != 5 {
+		t.Fatalf("CountMessagesBefore = %d, want 5", n)
+	}
+	if n := must(st.PurgeMessages(ctx, room.ID, cutoff, 2))(t); n != 2 {
+		t.Fatalf("first batch deleted %d, want 2", n)
+	}
+	_, err = st.GetMessage(ctx, ms[0].ID)
+	wantErr(t, err, store.ErrMessageNotFound)
+
+	must(st.SetRetention(ctx, store.RoomRetention{RoomID: room.ID, Days: &days, LegalHold: true}))(t)
+	if n := must(st.PurgeMessages(ctx, room.ID, cutoff, 10))(t); n != 0 {
+		t.Fatalf("purge under legal hold deleted %d", n)
+	}
+	must(st.SetRetention(ctx, store.RoomRetention{RoomID: room.ID}))(t)
+
+	// An open report keeps its message; the room's last message falls back
+	// to it when the newer ones go.
+	report, _, err := st.ReportMessage(ctx, store.Report{MessageID: ms[2].ID, RoomID: room.ID, Reporter: "bob", Reason: "spam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := must(st.CountMessagesBefore(ctx, room.ID, cutoff))(t); n != 2 {
+		t.Fatalf("CountMessagesBefore with an open report = %d, want 2", n)
+	}
+	if n := must(st.PurgeMessages(ctx, room.ID, cutoff, 10))(t); n != 2 {
+		t.Fatalf("second batch deleted %d, want 2", n)
+	}
+	if r := must(st.GetRoom(ctx, room.ID))(t); r.LastMessageID != ms[2].ID || r.LastMessage == nil || r.LastMessage.ID != ms[2].ID {
+		t.Fatalf("room after purge = %+v", r)
+	}
+	must(st.ResolveReport(ctx, report.ID, store.ReportResolved, "admin"))(t)
+	if n := must(st.PurgeMessages(ctx, room.ID, cutoff, 10))(t); n != 1 {
+		t.Fatalf("purge after resolve deleted %d, want 1", n)
+	}
+	if r := must(st.GetRoom(ctx, room.ID))(t); r.LastMessageID != 0 || r.LastMessage != nil {
+		t.Fatalf("room after full purge = %+v", r)
+	}
+	if page := must(st.ListMessages(ctx, room.ID, store.MessageQuery{}))(t); len(page.Items) != 0 {
+		t.Fatalf("messages left: %+v", page.Items)
+	}
+	if n := must(st.CountMessagesBefore(ctx, other.ID, cutoff))(t); n != 1 {
+		t.Fatalf("other room lost messages: %d left", n)
+	}
+	if pins := must(st.ListPins(ctx, room.ID))(t); len(pins) != 0 {
+		t.Fatalf("pins of purged messages left: %+v", pins)
+	}
+	if bs, _, _ := st.ListBookmarks(ctx, "alice", 0, 10); len(bs) != 0 {
+		t.Fatalf("bookmarks of purged messages left: %+v", bs)
+	}
+	if list := must(st.ListScheduled(ctx, "alice"))(t); len(list) != 0 {
+		t.Fatalf("reminders of purged messages left: %+v", list)
+	}
+
+	rec := must(st.RecordPurge(ctx, store.PurgeRecord{RoomID: room.ID, Cutoff: cutoff, Deleted: 5}))(t)
+	if rec.ID == 0 || rec.PurgedAt.IsZero() {
+		t.Fatalf("RecordPurge = %+v", rec)
+	}
+	must(st.RecordPurge(ctx, store.PurgeRecord{RoomID: other.ID, Cutoff: cutoff, Deleted: 1}))(t)
+	if ps := must(st.ListPurges(ctx, 0, 10))(t); len(ps) != 2 || ps[0].RoomID != other.ID {
+		t.Fatalf("ListPurges(all) = %+v", ps)
+	}
+	ps := must(st.ListPurges(ctx, room.ID, 10))(t)
+	if len(ps) != 1 || ps[0].Deleted != 5 || !ps[0].Cutoff.Equal(cutoff.Truncate(time.Microsecond)) {
+		t.Fatalf("ListPurges(room) = %+v", ps)
+	}
+}
+
+func testStoreArchive(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	src := must(st.CreateRoom(ctx, "source", "alice"))(t)
+	must(st.CreateMessage(ctx, src.ID, "", "hello", "rest", "c-1"))(t)
+	got := must(st.GetRoom(ctx, src.ID))(t)
+	if got.Name != "source" || got.LastSeq != 1 || got.LastMessage == nil || got.LastMessage.Content != "hello" {
+		t.Fatalf("GetRoom = %+v", got)
+	}
+	_, err := st.GetRoom(ctx, src.ID+1000)
+	wantErr(t, err, store.ErrRoomNotFound)
+	if ms := must(st.ListMembers(ctx, src.ID))(t); len(ms) != 1 || ms[0].UserID != "alice" || !ms[0].IsAdmin {
+		t.Fatalf("ListMembers = %+v", ms)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	room, isNew, err := st.ImportRoom(ctx, "room:1:1", "imported", created)
+	if err != nil || !isNew || room.Name != "imported" || !room.CreatedAt.Equal(created) {
+		t.Fatalf("ImportRoom = %+v %v %v", room, isNew, err)
+	}
+	again, isNew, err := st.ImportRoom(ctx, "room:1:1", "renamed", time.Now())
+	if err != nil || isNew || again.ID != room.ID {
+		t.Fatalf("second ImportRoom = %+v %v %v", again, isNew, err)
+	}
+
+	members := []store.Member{{UserID: "alice", IsAdmin: true, JoinedAt: created}, {UserID: "bob", JoinedAt: created}}
+	if n := must(st.ImportMembers(ctx, room.ID, members))(t); n != 2 {
+		t.Fatalf("ImportMembers added %d, want 2", n)
+	}
+	if n := must(st.ImportMembers(ctx, room.ID, members))(t); n != 0 {
+		t.Fatalf("re-import added %d members", n)
+	}
+	if ok := must(st.IsRoomAdmin(ctx, room.ID, "alice"))(t); !ok {
+		t.Fatal("imported admin is not admin")
+	}
+	_, err = st.ImportMembers(ctx, room.ID+1000, members)
+	wantErr(t, err, store.ErrRoomNotFound)
+
+	msgs := []store.Message{
+		{Content: "one", Source: "rest", ClientMsgID: "a", CreatedAt: created},
+		{Content: "two", Source: "ws", Author: "bob", ClientMsgID: "b", CreatedAt: created.Add(time.Minute)},
+	}
+	if n := must(st.ImportMessages(ctx, room.ID, msgs))(t); n != 2 {
+		t.Fatalf("ImportMessages stored %d, want 2", n)
+	}
+	msgs = append(msgs, store.Message{Content: "three", Source: "rest", ClientMsgID: "c", CreatedAt: created.Add(2 * time.Minute)})
+	if n := must(st.ImportMessages(ctx, room.ID, msgs))(t); n != 1 {
+		t.Fatalf("re-import stored %d, want 1", n)
+	}
+	after, _, _ := st.MessagesAfter(ctx, room.ID, 0, 10)
+	if len(after) != 3 || seqs(after)[2] != 3 || after[1].Source != "ws" || after[1].Author != "bob" || !after[1].CreatedAt.Equal(created.Add(time.Minute)) {
+		t.Fatalf("imported messages = %+v", after)
+	}
+	if got := must(st.GetRoom(ctx, room.ID))(t); got.LastMessage == nil || got.LastMessage.Content != "three" {
+		t.Fatalf("room after import = %+v", got)
+	}
+	next := must(st.CreateMessage(ctx, room.ID, "", "live", "rest", ""))(t)
+	if next.Seq != 4 {
+		t.Fatalf("message after import has seq %d, want 4", next.Seq)
+	}
+	// Importing older history keeps the newer message as the room's last.
+	older := []store.Message{
+		{Content: "older", Source: "rest", ClientMsgID: "d", CreatedAt: created.Add(-time.Hour)},
+		{Content: "oldest", Source: "rest", ClientMsgID: "e", CreatedAt: created.Add(-2 * time.Hour)},
+	}
+	if n := must(st.ImportMessages(ctx, room.ID, older))(t); n != 2 {
+		t.Fatalf("importing older messages stored %d, want 2", n)
+	}
+	if got := must(st.GetRoom(ctx, room.ID))(t); got.LastSeq != 6 || got.LastMessage == nil || got.LastMessage.ID != next.ID {
+		t.Fatalf("room after importing older messages = %+v", got)
+	}
+	_, err = st.ImportMessages(ctx, room.ID+1000, msgs)
+	wantErr(t, err, store.ErrRoomNotFound)
+}
+
+func testStoreAudit(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Second)
+	first := must(st.AppendAudit(ctx, store.AuditEvent{
+		Actor: "alice", Action: "room.create", TargetType: "room", TargetID: "1",
+		RequestID: "req-1", RemoteAddr: "10.0.0.1", UserAgent: "test",
+		Details: json.RawMessage(`{"name":"general"}`),
+	}))(t)
+	if first.ID == 0 || first.At.Before(start) {
+		t.Fatalf("AppendAudit = %+v", first)
+	}
+	for i := 0; i < 4; i++ {
+		must(st.AppendAudit(ctx, store.AuditEvent{Actor: "admin", Action: "retention.set", TargetType: "room", TargetID: strconv.Itoa(i)}))(t)
+	}
+
+	items, next := mustAudit(t)(st.ListAudit(ctx, store.AuditQuery{Actor: "alice"}))
+	if len(items) != 1 || next != 0 || items[0].RequestID != "req-1" || items[0].RemoteAddr != "10.0.0.1" {
+		t.Fatalf("by actor = %+v, next %d", items, next)
+	}
+	var details map[string]string
+	if err := json.Unmarshal(items[0].Details, &details); err != nil || details["name"] != "general" {
+		t.Fatalf("details = %s", items[0].Details)
+	}
+
+	page, next := mustAudit(t)(st.ListAudit(ctx, store.AuditQuery{Action: "retention.set", Limit: 3}))
+	if len(page) != 3 || next == 0 || page[0].TargetID != "3" {
+		t.Fatalf("first page = %+v, next %d", page, next)
+	}
+	rest, next := mustAudit(t)(st.ListAudit(ctx, store.AuditQuery{Action: "retention.set", Limit: 3, Before: next}))
+	if len(rest) != 1 || next != 0 || rest[0].TargetID != "0" {
+		t.Fatalf("second page = %+v, next %d", rest, next)
+	}
+	if items, _ := mustAudit(t)(st.ListAudit(ctx, store.AuditQuery{TargetType: "room", TargetID: "1"})); len(items) != 2 {
+		t.Fatalf("by target = %+v", items)
+	}
+	if items, _ := mustAudit(t)(st.ListAudit(ctx, store.AuditQuery{Until: start})); len(items) != 0 {
+		t.Fatalf("until before the first event = %+v", items)
+	}
+	if items, _ := mustAudit(t)(st.ListAudit(ctx, store.AuditQuery{Since: start})); len(items) != 5 {
+		t.Fatalf("since start = %d events, want 5", len(items))
+	}
+}
+
+func mustAudit(t *testing.T) func([]store.AuditEvent, int64, error) ([]store.AuditEvent, int64) {
+	return func(items []store.AuditEvent, next int64, err error) ([]store.AuditEvent, int64) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return items, next
+	}
+}
+
+func testStoreModeration(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	m1 := must(st.CreateMessage(ctx, room.ID, "", "first", "rest", "c-1"))(t)
+	m2 := must(st.CreateMessage(ctx, room.ID, "", "second", "ws", "c-2"))(t)
+
+	r1 := must(st.FlagMessage(ctx, store.Review{MessageID: m1.ID, UserID: "bob", Reasons: []string{"words", "length"}}))(t)
+	if r1.ID == 0 || r1.RoomID != room.ID || r1.Content != "first" || r1.Status != store.ReviewPending ||
+		len(r1.Reasons) != 2 || r1.Reasons[1] != "length" || r1.ReviewedAt != nil {
+		t.Fatalf("FlagMessage = %+v", r1)
+	}
+	if again := must(st.FlagMessage(ctx, store.Review{MessageID: m1.ID, Reasons: []string{"other"}}))(t); again.ID != r1.ID || again.Reasons[0] != "words" {
+		t.Fatalf("second FlagMessage = %+v", again)
+	}
+	_, err := st.FlagMessage(ctx, store.Review{MessageID: m2.ID + 1000, Reasons: []string{"words"}})
+	wantErr(t, err, store.ErrMessageNotFound)
+	r2 := must(st.FlagMessage(ctx, store.Review{MessageID: m2.ID, UserID: "carol", Reasons: []string{"words"}}))(t)
+
+	page, next, err := st.ListReviews(ctx, store.ReviewPending, 0, 1)
+	if err != nil || len(page) != 1 || page[0].ID != r2.ID || next == 0 {
+		t.Fatalf("first page = %+v, next %d, %v", page, next, err)
+	}
+	page, next, err = st.ListReviews(ctx, store.ReviewPending, next, 1)
+	if err != nil || len(page) != 1 || page[0].ID != r1.ID || next != 0 {
+		t.Fatalf("second page = %+v, next %d, %v", page, next, err)
+	}
+
+	done := must(st.ResolveReview(ctx, r1.ID, store.ReviewRejected, "admin"))(t)
+	if done.Status != store.ReviewRejected || done.ReviewedBy != "admin" || done.ReviewedAt == nil {
+		t.Fatalf("ResolveReview = %+v", done)
+	}
+	_, err = st.ResolveReview(ctx, r1.ID, store.ReviewApproved, "admin")
+	wantErr(t, err, store.ErrReviewResolved)
+	_, err = st.ResolveReview(ctx, r2.ID+1000, store.ReviewApproved, "admin")
+	wantErr(t, err, store.ErrReviewNotFound)
+	if pending, _, _ := st.ListReviews(ctx, store.ReviewPending, 0, 10); len(pending) != 1 || pending[0].ID != r2.ID {
+		t.Fatalf("pending after resolve = %+v", pending)
+	}
+	if all, _, _ := st.ListReviews(ctx, "", 0, 10); len(all) != 2 {
+		t.Fatalf("all reviews = %+v", all)
+	}
+
+	// purged messages take their reviews with them
+	if _, err := st.PurgeMessages(ctx, room.ID, time.Now().Add(time.Hour), 10); err != nil {
+		t.Fatal(err)
+	}
+	if all, _, _ := st.ListReviews(ctx, "", 0, 10); len(all) != 0 {
+		t.Fatalf("reviews after purge = %+v", all)
+	}
+}
+
+func testStoreBlocks(t *testing.T, st store.Store) {
+	ctx := context.Background()
+
+	b, created, err := st.BlockUser(ctx, "alice", "bob")
+	if err != nil || !created || b.UserID != "bob" || b.BlockedAt.IsZero() {
+		t.Fatalf("BlockUser = %+v, %v, %v", b, created, err)
+	}
+	again, created, err := st.BlockUser(ctx, "alice", "bob")
+	if err != nil || created || !again.BlockedAt.Equal(b.BlockedAt) {
+		t.Fatalf("second BlockUser = %+v, %v, %v", again, created, err)
+	}
+	for _, pair := range [][2]string{{"alice", "carol"}, {"bob", "alice"}} {
+		if _, _, err := st.BlockUser(ctx, pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bs := must(st.ListBlocks(ctx, "alice"))(t)
+	if len(bs) != 2 || bs[0].UserID == bs[1].UserID || bs[0].BlockedAt.Before(bs[1].BlockedAt) {
+		t.Fatalf("ListBlocks = %+v", bs)
+	}
+	if bs := must(st.ListBlocks(ctx, "dave"))(t); bs == nil || len(bs) != 0 {
+		t.Fatalf("ListBlocks without blocks = %#v", bs)
+	}
+
+	if err := st.UnblockUser(ctx, "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(t, st.UnblockUser(ctx, "alice", "bob"), store.ErrBlockNotFound)
+	if bs := must(st.ListBlocks(ctx, "alice"))(t); len(bs) != 1 || bs[0].UserID != "carol" {
+		t.Fatalf("ListBlocks after unblock = %+v", bs)
+	}
+}
+
+func testStoreReports(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", "alice"))(t)
+	other := must(st.CreateRoom(ctx, "random", "alice"))(t)
+	m1 := must(st.CreateMessage(ctx, room.ID, "", "first", "rest", "c-1"))(t)
+	m2 := must(st.CreateMessage(ctx, room.ID, "", "second", "ws", "c-2"))(t)
+
+	r1, created, err := st.ReportMessage(ctx, store.Report{MessageID: m1.ID, RoomID: room.ID, Reporter: "bob", Reason: "spam", Note: "ads"})
+	if err != nil || !created || r1.ID == 0 || r1.Content != "first" || r1.Status != store.ReportOpen || r1.Note != "ads" || r1.ResolvedAt != nil {
+		t.Fatalf("ReportMessage = %+v, %v, %v", r1, created, err)
+	}
+	again, created, err := st.ReportMessage(ctx, store.Report{MessageID: m1.ID, RoomID: room.ID, Reporter: "bob", Reason: "abuse"})
+	if err != nil || created || again.ID != r1.ID || again.Reason != "spam" {
+		t.Fatalf("second ReportMessage = %+v, %v, %v", again, created, err)
+	}
+	_, _, err = st.ReportMessage(ctx, store.Report{MessageID: m1.ID, RoomID: other.ID, Reporter: "carol", Reason: "spam"})
+	wantErr(t, err, store.ErrMessageNotFound)
+	_, _, err = st.ReportMessage(ctx, store.Report{MessageID: m2.ID + 1000, RoomID: room.ID, Reporter: "carol", Reason: "spam"})
+	wantErr(t, err, store.ErrMessageNotFound)
+	r2, _, err := st.ReportMessage(ctx, store.Report{MessageID: m1.ID, RoomID: room.ID, Reporter: "carol", Reason: "abuse"})
+	if err != nil || r2.ID == r1.ID {
+		t.Fatalf("report by another user = %+v, %v", r2, err)
+	}
+	r3, _, err := st.ReportMessage(ctx, store.Report{MessageID: m2.ID, RoomID: room.ID, Reporter: "bob", Reason: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, next, err := st.ListReports(ctx, store.ReportOpen, 0, 2)
+	if err != nil || len(page) != 2 || page[0].ID != r3.ID || page[1].ID != r2.ID || next == 0 {
+		t.Fatalf("first page = %+v, next %d, %v", page, next, err)
+	}
+	page, next, err = st.ListReports(ctx, store.ReportOpen, next, 2)
+	if err != nil || len(page) != 1 || page[0].ID != r1.ID || next != 0 {
+		t.Fatalf("second page = %+v, next %d, %v", page, next, err)
+	}
+
+	done := must(st.ResolveReport(ctx, r1.ID, store.ReportDismissed, "admin"))(t)
+	if done.Status != store.ReportDismissed || done.ResolvedBy != "admin" || done.ResolvedAt == nil {
+		t.Fatalf("ResolveReport = %+v", done)
+	}
+	_, err = st.ResolveReport(ctx, r1.ID, store.ReportResolved, "admin")
+	wantErr(t, err, store.ErrReportResolved)
+	_, err = st.ResolveReport(ctx, r3.ID+1000, store.ReportResolved, "admin")
+	wantErr(t, err, store.ErrReportNotFound)
+	if open, _, _ := st.ListReports(ctx, store.ReportOpen, 0, 10); len(open) != 2 {
+		t.Fatalf("open after resolve = %+v", open)
+	}
+
+	// Retention keeps reported messages until every report on them is
+	// closed, then takes the closed reports along.
+	if n := must(st.PurgeMessages(ctx, room.ID, time.Now().Add(time.Hour), 10))(t); n != 0 {
+		t.Fatalf("purge with open reports deleted %d", n)
+	}
+	must(st.ResolveReport(ctx, r2.ID, store.ReportResolved, "admin"))(t)
+	if n := must(st.PurgeMessages(ctx, room.ID, time.Now().Add(time.Hour), 10))(t); n != 1 {
+		t.Fatalf("purge after closing m1's reports deleted %d, want 1", n)
+	}
+	all, _, _ := st.ListReports(ctx, "", 0, 10)
+	if len(all) != 1 || all[0].ID != r3.ID || all[0].Status != store.ReportOpen {
+		t.Fatalf("reports after purge = %+v", all)
+	}
+}
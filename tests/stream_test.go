@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yngus4862/chat/internal/api"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+)
+
+// streamServer serves the REST API with a hub, as the SSE and long-poll
+// handlers need one.
+func streamServer(t *testing.T, st store.Store) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	hub := ws.NewHub(st, nil, ws.Options{})
+	srv := httptest.NewServer(api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: st, Hub: hub}}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func postMessage(t *testing.T, srv *httptest.Server, roomID int64, content string) store.Message {
+	t.Helper()
+	body := `{"content":"` + content + `","clientMsgId":"c-` + content + `"}`
+	res, err := http.Post(srv.URL+"/v1/rooms/"+strconv.FormatInt(roomID, 10)+"/messages", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var m store.Message
+	if err := json.NewDecoder(res.Body).Decode(&m); err != nil || res.StatusCode != http.StatusCreated {
+		t.Fatalf("post %q: status %d, %v", content, res.StatusCode, err)
+	}
+	return m
+}
+
+// sseEvent is one dispatched Server-Sent Event.
+type sseEvent struct {
+	event, id, data string
+}
+
+// readSSE yields the events of an SSE body, skipping comments and the retry
+// hint.
+func readSSE(body *bufio.Reader) (sseEvent, error) {
+	var ev sseEvent
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return ev, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if ev != (sseEvent{}) {
+				return ev, nil
+			}
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestSSEResumesAfterLastEventID(t *testing.T) {
+	st := store.NewMemory()
+	srv := streamServer(t, st)
+	room := must(st.CreateRoom(context.Background(), "general", ""))(t)
+	first := postMessage(t, srv, room.ID, "one")
+	second := postMessage(t, srv, room.ID, "two")
+	third := postMessage(t, srv, room.ID, "three")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/rooms/"+strconv.FormatInt(room.ID, 10)+"/events", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(first.ID, 10))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	body := bufio.NewReader(res.Body)
+
+	// The replay, then a live message, each exactly once.
+	for i, want := range []int64{second.ID, third.ID, 0} {
+		if want == 0 {
+			want = postMessage(t, srv, room.ID, "four").ID
+		}
+		ev, err := readSSE(body)
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		var m store.Message
+		if err := json.Unmarshal([]byte(ev.data), &m); err != nil || ev.event != "" || ev.id != strconv.FormatInt(want, 10) || m.ID != want {
+			t.Fatalf("event %d = %+v, want message %d", i, ev, want)
+		}
+	}
+}
+
+func TestSSEReplayKeepsLiveMessages(t *testing.T) {
+	st := store.NewMemory()
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	// Large enough that the replay outgrows the socket buffers and blocks
+	// while the client is not reading.
+	body := strings.Repeat("x", 4096)
+	first := must(st.CreateMessage(ctx, room.ID, "", body, "rest", "old-0"))(t)
+	for i := 1; i < 1000; i++ {
+		must(st.CreateMessage(ctx, room.ID, "", body, "rest", "old-"+strconv.Itoa(i)))(t)
+	}
+	gin.SetMode(gin.TestMode)
+	hub := ws.NewHub(st, nil, ws.Options{})
+	srv := httptest.NewServer(api.NewRouter(api.Deps{Handlers: &api.Handlers{Store: st, Hub: hub}}))
+	defer srv.Close()
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(reqCtx, http.MethodGet, srv.URL+"/v1/rooms/"+strconv.FormatInt(room.ID, 10)+"/events", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(first.ID, 10))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	for deadline := time.Now().Add(time.Second); hub.Stats().Rooms == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber never joined")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// More live messages than the frame buffer holds arrive mid-replay.
+	for i := range 400 {
+		hub.BroadcastMessage(ctx, must(st.CreateMessage(ctx, room.ID, "", "live", "rest", "live-"+strconv.Itoa(i)))(t))
+	}
+
+	r := bufio.NewReaderSize(res.Body, 1<<16)
+	for want := int64(2); want <= 1400; want++ {
+		ev, err := readSSE(r)
+		if err != nil {
+			t.Fatalf("waiting for seq %d: %v", want, err)
+		}
+		var m store.Message
+		if err := json.Unmarshal([]byte(ev.data), &m); err != nil || m.Seq != want {
+			t.Fatalf("got event %s (seq %d), want seq %d", ev.id, m.Seq, want)
+		}
+	}
+}
+
+func TestSSERejectsUnknownLastEventID(t *testing.T) {
+	st := store.NewMemory()
+	srv := streamServer(t, st)
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	other := must(st.CreateRoom(ctx, "other", ""))(t)
+	foreign := postMessage(t, srv, other.ID, "elsewhere")
+	path := srv.URL + "/v1/rooms/" + strconv.FormatInt(room.ID, 10) + "/events"
+
+	for _, tc := range []struct{ header, query string }{
+		{header: "999"},
+		{header: strconv.FormatInt(foreign.ID, 10)},
+		{header: "abc"},
+		{query: "?lastEventId=999"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, path+tc.query, nil)
+		if tc.header != "" {
+			req.Header.Set("Last-Event-ID", tc.header)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]any
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest || body["code"] != "validation_failed" {
+			t.Errorf("%+v: status %d, body %v", tc, res.StatusCode, body)
+		}
+	}
+}
+
+type pollPage struct {
+	Items   []store.Message `json:"items"`
+	LastSeq int64           `json:"lastSeq"`
+	HasMore bool            `json:"hasMore"`
+}
+
+func TestLongPoll(t *testing.T) {
+	st := store.NewMemory()
+	srv := streamServer(t, st)
+	room := must(st.CreateRoom(context.Background(), "general", ""))(t)
+	first := postMessage(t, srv, room.ID, "one")
+	poll := func(after int64, timeout string) (pollPage, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		res, err := http.Get(srv.URL + "/v1/rooms/" + strconv.FormatInt(room.ID, 10) + "/messages/poll?after=" + strconv.FormatInt(after, 10) + "&timeout=" + timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var page pollPage
+		if err := json.NewDecoder(res.Body).Decode(&page); err != nil || res.StatusCode != http.StatusOK {
+			t.Fatalf("poll: status %d, %v", res.StatusCode, err)
+		}
+		return page, time.Since(start)
+	}
+
+	// Pending messages answer at once.
+	if page, _ := poll(0, "5"); len(page.Items) != 1 || page.LastSeq != first.Seq {
+		t.Fatalf("pending: %+v", page)
+	}
+
+	// A new message wakes a waiting poll well before its timeout.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		res, err := http.Post(srv.URL+"/v1/rooms/"+strconv.FormatInt(room.ID, 10)+"/messages", "application/json",
+			strings.NewReader(`{"content":"two","clientMsgId":"c-two"}`))
+		if err == nil {
+			_ = res.Body.Close()
+		}
+	}()
+	page, took := poll(first.Seq, "10")
+	if len(page.Items) != 1 || page.Items[0].Content != "two" || page.LastSeq != first.Seq+1 || took > 5*time.Second {
+		t.Fatalf("woken: %+v after %v", page, took)
+	}
+
+	// Nothing new: an empty page once the timeout passes.
+	page, took = poll(page.LastSeq, "1")
+	if len(page.Items) != 0 || page.LastSeq != first.Seq+1 || page.HasMore || took < time.Second {
+		t.Fatalf("timed out: %+v after %v", page, took)
+	}
+}
@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yngus4862/chat/internal/tlsutil"
+)
+
+// testCert is a key pair signed by its CA (or self-signed when ca is nil).
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issueCert(t *testing.T, cn string, ca *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write stores the cert and key as PEM files, returning their paths.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) keyPair() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// tlsServer accepts connections with cfg and writes "ok" to each client that
+// completes the handshake.
+func tlsServer(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					_, _ = conn.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// tlsHello connects to addr and reads the server's greeting, returning the
+// served certificate.
+func tlsHello(addr string, cfg *tls.Config) (*x509.Certificate, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestTLSReloaderPicksUpNewCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "test ca", nil, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := issueCert(t, "first", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	r, err := tlsutil.NewReloader(tlsutil.Files{CertFile: certFile, KeyFile: keyFile}, tls.VersionTLS12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+	addr := tlsServer(t, r.TLSConfig())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := &tls.Config{RootCAs: pool}
+	cert, err := tlsHello(addr, client)
+	if err != nil || cert.Subject.CommonName != "first" {
+		t.Fatalf("before swap: %v, %v", cert, err)
+	}
+
+	// Replace both files in place, as cert tooling does on renewal.
+	next := issueCert(t, "second", ca, x509.ExtKeyUsageServerAuth)
+	newCert, newKey := next.write(t, t.TempDir(), "server")
+	for _, p := range [][2]string{{newCert, certFile}, {newKey, keyFile}} {
+		if err := os.Rename(p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Second)
+		_ = os.Chtimes(p[1], later, later)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		cert, err := tlsHello(addr, client)
+		if err == nil && cert.Subject.CommonName == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new certificate never served: %v, %v", cert, err)
+		}
+	}
+
+	// A broken replacement keeps the last good certificate.
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if cert, err := tlsHello(addr, client); err != nil || cert.Subject.CommonName != "second" {
+		t.Fatalf("after bad swap: %v, %v", cert, err)
+	}
+}
+
+func TestTLSRequiresClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "test ca", nil, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := issueCert(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	clientCA := issueCert(t, "client ca", nil, x509.ExtKeyUsageClientAuth)
+	caFile, _ := clientCA.write(t, dir, "client-ca")
+
+	r, err := tlsutil.NewReloader(tlsutil.Files{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, tls.VersionTLS12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := tlsServer(t, r.TLSConfig())
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	signed := issueCert(t, "alice", clientCA, x509.ExtKeyUsageClientAuth)
+	stranger := issueCert(t, "mallory", issueCert(t, "other ca", nil, x509.ExtKeyUsageClientAuth), x509.ExtKeyUsageClientAuth)
+	cases := []struct {
+		name  string
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{"no client cert", nil, false},
+		{"cert from another CA", []tls.Certificate{stranger.keyPair()}, false},
+		{"CA-signed cert", []tls.Certificate{signed.keyPair()}, true},
+	}
+	for _, tc := range cases {
+		_, err := tlsHello(addr, &tls.Config{RootCAs: pool, Certificates: tc.certs})
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yngus4862/chat/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := tracing.Setup(context.Background(), tracing.Config{Exporter: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestRESTSpanContinuesTraceparent(t *testing.T) {
+	rec := recordSpans(t)
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	testRouter().ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "GET /healthz" {
+		t.Errorf("name = %q", s.Name())
+	}
+	if got := s.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("trace id = %s", got)
+	}
+	if got := s.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent = %s", got)
+	}
+}
+
+func TestInjectExtractRoundTrip(t *testing.T) {
+	recordSpans(t)
+	ctx, span := tracing.Start(context.Background(), "publish")
+	defer span.End()
+
+	carried := tracing.Inject(ctx)
+	if carried["traceparent"] == "" {
+		t.Fatalf("no traceparent in %v", carried)
+	}
+	got := tracing.TraceID(tracing.Extract(context.Background(), carried))
+	if got != tracing.TraceID(ctx) {
+		t.Fatalf("extracted trace %q, want %q", got, tracing.TraceID(ctx))
+	}
+	if tracing.Inject(context.Background()) != nil {
+		t.Fatal("empty context injected a trace")
+	}
+}
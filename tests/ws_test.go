@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yngus4862/chat/internal/clientip"
+	"github.com/yngus4862/chat/internal/ratelimit"
+	"github.com/yngus4862/chat/internal/store"
+	"github.com/yngus4862/chat/internal/ws"
+)
+
+// wsServer serves hub.ServeWS and returns the ws:// URL to dial.
+func wsServer(t *testing.T, hub *ws.Hub) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(hub.ServeWS))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialWS connects to url, failing the test unless the handshake succeeds.
+func dialWS(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial %s: %v (status %d)", url, err, status)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// readFrame reads the next JSON frame, failing after a second.
+func readFrame(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	var f map[string]any
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("frame %s: %v", data, err)
+	}
+	return f
+}
+
+func TestWSSendLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	st := store.NewMemory()
+	room, _ := st.CreateRoom(context.Background(), "general", "")
+	proxies, err := clientip.New([]string{"127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := ws.NewHub(st, nil, ws.Options{
+		Proxies:     proxies,
+		SendLimiter: ratelimit.NewMemory(),
+		SendBudget:  ratelimit.Budget{Rate: 0.001, Burst: 1},
+	})
+	url := wsServer(t, hub) + "?roomId=" + strconv.FormatInt(room.ID, 10)
+
+	// two anonymous connections claim different addresses; the proxy (the
+	// test's loopback peer) appended the same real one to both
+	for i, spoofed := range []string{"198.51.100.1", "198.51.100.2"} {
+		conn := dialWS(t, url, http.Header{"X-Forwarded-For": {spoofed + ", 203.0.113.7"}})
+		_ = conn.WriteJSON(map[string]string{"content": "hi", "clientMsgId": "c-" + spoofed})
+		f := readFrame(t, conn)
+		if i == 0 && f["content"] != "hi" {
+			t.Fatalf("first send: %v", f)
+		}
+		if i == 1 && (f["type"] != "error" || f["code"] != "rate_limited") {
+			t.Fatalf("second send from a rotated address: %v", f)
+		}
+	}
+}
+
+func TestWSReplayKeepsLiveMessages(t *testing.T) {
+	st := store.NewMemory()
+	ctx := context.Background()
+	room := must(st.CreateRoom(ctx, "general", ""))(t)
+	// Large enough that the replay outgrows the socket buffers and blocks
+	// while the client is not reading.
+	body := strings.Repeat("x", 4096)
+	for i := range 1000 {
+		must(st.CreateMessage(ctx, room.ID, "", body, "rest", "old-"+strconv.Itoa(i)))(t)
+	}
+	hub := ws.NewHub(st, nil, ws.Options{})
+	conn := dialWS(t, wsServer(t, hub)+"?roomId="+strconv.FormatInt(room.ID, 10)+"&sinceSeq=0", nil)
+	for deadline := time.Now().Add(time.Second); hub.Stats().Rooms == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("client never joined")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// More live messages than the send buffer holds arrive mid-replay.
+	for i := range 400 {
+		hub.BroadcastMessage(ctx, must(st.CreateMessage(ctx, room.ID, "", "live", "rest", "live-"+strconv.Itoa(i)))(t))
+	}
+
+	for want := int64(1); want <= 1400; want++ {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var m store.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("waiting for seq %d: %v", want, err)
+		}
+		if m.Seq != want {
+			t.Fatalf("got seq %d, want %d", m.Seq, want)
+		}
+	}
+}
+
+// handshake dials url and returns the handshake status, keeping the
+// connection open for the rest of the test when it succeeds.
+func handshake(t *testing.T, url string, header http.Header) (int, *websocket.Conn) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("dial %s: %v", url, err)
+		}
+		return resp.StatusCode, nil
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return http.StatusSwitchingProtocols, conn
+}
+
+func TestWSCheckOrigin(t *testing.T) {
+	st := store.NewMemory()
+	room := must(st.CreateRoom(context.Background(), "general", ""))(t)
+	query := "?roomId=" + strconv.FormatInt(room.ID, 10)
+	listed := wsServer(t, ws.NewHub(st, nil, ws.Options{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+	})) + query
+	sameHost := wsServer(t, ws.NewHub(st, nil, ws.Options{})) + query
+	u, err := neturl.Parse(sameHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url, origin string
+		want        int
+	}{
+		{listed, "", http.StatusSwitchingProtocols}, // native client
+		{listed, "https://app.example.com", http.StatusSwitchingProtocols},
+		{listed, "HTTPS://APP.EXAMPLE.COM", http.StatusSwitchingProtocols},
+		{listed, "https://chat.example.org", http.StatusSwitchingProtocols},
+		{listed, "https://a.b.example.org", http.StatusSwitchingProtocols},
+		{listed, "https://example.org", http.StatusForbidden},
+		{listed, "http://chat.example.org", http.StatusForbidden},
+		{listed, "https://evilexample.org", http.StatusForbidden},
+		{listed, "https://app.example.com.evil.com", http.StatusForbidden},
+		{listed, "https://evil.com", http.StatusForbidden},
+		{sameHost, "http://" + u.Host, http.StatusSwitchingProtocols},
+		{sameHost, "https://evil.com", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		h := http.Header{}
+		if tc.origin != "" {
+			h.Set("Origin", tc.origin)
+		}
+		if got, _ := handshake(t, tc.url, h); got != tc.want {
+			t.Errorf("origin %q on %s: status %d, want %d", tc.origin, tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestWSAdmission(t *testing.T) {
+	st := store.NewMemory()
+	room := must(st.CreateRoom(context.Background(), "general", ""))(t)
+	proxies, err := clientip.New([]string{"127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := ws.NewHub(st, nil, ws.Options{Proxies: proxies, MaxConns: 5, MaxConnsPerUser: 2})
+	url := wsServer(t, hub) + "?roomId=" + strconv.FormatInt(room.ID, 10)
+	user := func(id string) http.Header { return http.Header{"X-User-Id": {id}} }
+	from := func(ip string) http.Header { return http.Header{"X-Forwarded-For": {ip}} }
+
+	steps := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"alice 1", user("alice"), http.StatusSwitchingProtocols},
+		{"alice 2", user("alice"), http.StatusSwitchingProtocols},
+		{"alice over user limit", user("alice"), http.StatusTooManyRequests},
+		{"anonymous A 1", from("203.0.113.1"), http.StatusSwitchingProtocols},
+		{"anonymous A 2", from("203.0.113.1"), http.StatusSwitchingProtocols},
+		{"anonymous A over IP limit", from("203.0.113.1"), http.StatusTooManyRequests},
+		{"anonymous B 1", from("203.0.113.2"), http.StatusSwitchingProtocols},
+		{"bob over instance limit", user("bob"), http.StatusServiceUnavailable},
+	}
+	conns := map[string]*websocket.Conn{}
+	for _, s := range steps {
+		got, conn := handshake(t, url, s.header)
+		if got != s.want {
+			t.Fatalf("%s: status %d, want %d", s.name, got, s.want)
+		}
+		if conn != nil {
+			conns[s.name] = conn
+		}
+	}
+	if stats := hub.Stats(); stats.Connections != 5 || stats.Users != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// Closing a connection gives its slot back once the server notices.
+	redial := func(name string, header http.Header) {
+		t.Helper()
+		_ = conns[name].Close()
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+			got, conn := handshake(t, url, header)
+			if got == http.StatusSwitchingProtocols {
+				conns[name] = conn
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: slot not released (status %d)", name, got)
+			}
+		}
+	}
+	redial("alice 1", user("alice"))
+	redial("anonymous A 1", from("203.0.113.1"))
+	if stats := hub.Stats(); stats.Connections != 5 {
+		t.Fatalf("stats after release = %+v", stats)
+	}
+}